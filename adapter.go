@@ -0,0 +1,288 @@
+package gobt
+
+import (
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/godbus/dbus"
+	"github.com/potch8228/gobt/bluetooth"
+	"github.com/potch8228/gobt/hid"
+	btlog "github.com/potch8228/gobt/log"
+)
+
+const (
+	ADAPTERIFACE    = "org.bluez.Adapter1"
+	DEVICEIFACE     = "org.bluez.Device1"
+	PROPERTIESIFACE = "org.freedesktop.DBus.Properties"
+)
+
+// Adapter properties managed by gobt; restored in reverse order so that Powered comes last
+var adapterProps = []string{"Powered", "Alias", "Pairable", "DiscoverableTimeout", "Discoverable"}
+
+type adapterProp struct {
+	name  string
+	value interface{}
+}
+
+// Gets and sets properties of org.bluez.Adapter1
+type propertySetter interface {
+	GetProperty(name string) (dbus.Variant, error)
+	SetProperty(name string, v interface{}) error
+}
+
+// Sets properties through org.freedesktop.DBus.Properties
+type dbusProperties struct {
+	obj dbus.BusObject
+}
+
+func (d dbusProperties) GetProperty(name string) (dbus.Variant, error) {
+	return d.obj.GetProperty(ADAPTERIFACE + "." + name)
+}
+
+func (d dbusProperties) SetProperty(name string, v interface{}) error {
+	return d.obj.Call(PROPERTIESIFACE+".Set", 0, ADAPTERIFACE, name, dbus.MakeVariant(v)).Err
+}
+
+type AdapterConfig struct {
+	// Adapter name such as "hci0"
+	Name string
+
+	Powered             bool
+	Discoverable        bool
+	Pairable            bool
+	DiscoverableTimeout uint32
+	// Empty keeps current alias
+	Alias string
+
+	// Makes adapter discoverable whenever no host is paired
+	DiscoverableWhenUnpaired bool
+	// Makes adapter discoverable when pressed on a local keyboard
+	DiscoverableHotkey hid.Chord
+}
+
+func DefaultAdapterConfig() AdapterConfig {
+	return AdapterConfig{
+		Name:                     "hci0",
+		Powered:                  true,
+		Discoverable:             false,
+		Pairable:                 true,
+		DiscoverableTimeout:      180,
+		DiscoverableWhenUnpaired: true,
+	}
+}
+
+// Controls org.bluez.Adapter1 and restores its state on Restore()
+type Adapter struct {
+	conn  *dbus.Conn
+	obj   dbus.BusObject
+	path  dbus.ObjectPath
+	cfg   AdapterConfig
+	props propertySetter
+
+	// State before Apply() of properties it changed
+	saved   map[string]dbus.Variant
+	signals chan *dbus.Signal
+}
+
+func NewAdapter(conn *dbus.Conn, cfg AdapterConfig) *Adapter {
+	p := (dbus.ObjectPath)("/org/bluez/" + cfg.Name)
+	obj := conn.Object("org.bluez", p)
+	return &Adapter{
+		conn:  conn,
+		obj:   obj,
+		path:  p,
+		cfg:   cfg,
+		props: dbusProperties{obj},
+		saved: make(map[string]dbus.Variant),
+	}
+}
+
+func (a *Adapter) Path() dbus.ObjectPath {
+	return a.path
+}
+
+// Returns controller index; e.g. 0 for hci0
+func (a *Adapter) Index() uint16 {
+	i, err := strconv.ParseUint(strings.TrimPrefix(a.cfg.Name, "hci"), 10, 16)
+	if err != nil {
+		return 0
+	}
+	return uint16(i)
+}
+
+// Saves current adapter state and applies configuration
+// Properties already changed are restored when applying fails
+func (a *Adapter) Apply() error {
+	orig := make(map[string]dbus.Variant, len(adapterProps))
+	for _, n := range adapterProps {
+		v, err := a.props.GetProperty(n)
+		if err != nil {
+			btlog.Debug("Adapter: failed to get property", n, err)
+			return err
+		}
+		orig[n] = v
+	}
+
+	props := []adapterProp{
+		{"Powered", a.cfg.Powered},
+		{"Pairable", a.cfg.Pairable},
+		{"DiscoverableTimeout", a.cfg.DiscoverableTimeout},
+		{"Discoverable", a.cfg.Discoverable},
+	}
+	if a.cfg.Alias != "" {
+		props = append(props, adapterProp{"Alias", a.cfg.Alias})
+	}
+
+	for _, p := range props {
+		if err := a.set(p.name, p.value); err != nil {
+			a.Restore()
+			return err
+		}
+		a.saved[p.name] = orig[p.name]
+	}
+	btlog.Debug("Adapter configured", a.path)
+
+	if a.cfg.DiscoverableWhenUnpaired {
+		a.discoverableIfUnpaired()
+	}
+	return nil
+}
+
+// Restores properties changed by Apply()
+func (a *Adapter) Restore() error {
+	if a.signals != nil {
+		a.conn.RemoveSignal(a.signals)
+		close(a.signals)
+		a.signals = nil
+	}
+
+	var rerr error
+	for i := len(adapterProps) - 1; i >= 0; i-- {
+		n := adapterProps[i]
+		v, ok := a.saved[n]
+		if !ok {
+			continue
+		}
+		if err := a.set(n, v.Value()); err != nil {
+			rerr = err
+		}
+	}
+	a.saved = make(map[string]dbus.Variant)
+	btlog.Debug("Adapter state restored", a.path)
+	return rerr
+}
+
+func (a *Adapter) SetDiscoverable(on bool) error {
+	return a.set("Discoverable", on)
+}
+
+// Registers discoverable hotkey
+func (a *Adapter) RegisterHotkey(hotkeys *hid.Hotkeys) {
+	if len(a.cfg.DiscoverableHotkey) > 0 {
+		hotkeys.Register(a.cfg.DiscoverableHotkey, func() {
+			btlog.Debug("Adapter: discoverable hotkey pressed")
+			a.SetDiscoverable(true)
+		})
+	}
+}
+
+// Registers discoverable hotkey and watches device removal
+func (a *Adapter) Watch(hotkeys *hid.Hotkeys) error {
+	a.RegisterHotkey(hotkeys)
+
+	if !a.cfg.DiscoverableWhenUnpaired {
+		return nil
+	}
+
+	rule := "type='signal',sender='org.bluez',interface='org.freedesktop.DBus.ObjectManager',member='InterfacesRemoved'"
+	if call := a.conn.BusObject().Call("org.freedesktop.DBus.AddMatch", 0, rule); call.Err != nil {
+		return call.Err
+	}
+
+	a.signals = make(chan *dbus.Signal, 10)
+	a.conn.Signal(a.signals)
+	go func(ch chan *dbus.Signal) {
+		for sig := range ch {
+			if sig.Name != "org.freedesktop.DBus.ObjectManager.InterfacesRemoved" || len(sig.Body) < 1 {
+				continue
+			}
+			if p, ok := sig.Body[0].(dbus.ObjectPath); ok && path.Dir(string(p)) == string(a.path) {
+				btlog.Debug("Adapter: device removed", p)
+				a.discoverableIfUnpaired()
+			}
+		}
+	}(a.signals)
+	return nil
+}
+
+// Host paired with the adapter
+type PairedDevice struct {
+	Path      dbus.ObjectPath
+	Addr      bluetooth.Addr
+	Name      string
+	Connected bool
+}
+
+// Devices paired with this adapter, ordered by address
+func (a *Adapter) PairedDevices() ([]PairedDevice, error) {
+	var objs map[dbus.ObjectPath]map[string]map[string]dbus.Variant
+	call := a.conn.Object("org.bluez", "/").Call("org.freedesktop.DBus.ObjectManager.GetManagedObjects", 0)
+	if err := call.Store(&objs); err != nil {
+		return nil, err
+	}
+
+	var devs []PairedDevice
+	for p, ifaces := range objs {
+		dev, ok := ifaces[DEVICEIFACE]
+		if !ok || path.Dir(string(p)) != string(a.path) {
+			continue
+		}
+		if paired, ok := dev["Paired"].Value().(bool); !ok || !paired {
+			continue
+		}
+		addr, err := deviceAddr(p)
+		if err != nil {
+			continue
+		}
+		pd := PairedDevice{Path: p, Addr: addr}
+		pd.Name, _ = dev["Alias"].Value().(string)
+		pd.Connected, _ = dev["Connected"].Value().(bool)
+		devs = append(devs, pd)
+	}
+	sort.Slice(devs, func(i, j int) bool { return devs[i].Addr.String() < devs[j].Addr.String() })
+	return devs, nil
+}
+
+// Removes pairing and connection of host addr
+func (a *Adapter) RemoveDevice(addr bluetooth.Addr) error {
+	dev := (dbus.ObjectPath)(string(a.path) + "/dev_" + strings.Replace(addr.String(), ":", "_", -1))
+	return a.obj.Call(ADAPTERIFACE+".RemoveDevice", 0, dev).Err
+}
+
+// Reports whether any device under this adapter is paired
+func (a *Adapter) HasPairedDevice() (bool, error) {
+	devs, err := a.PairedDevices()
+	return len(devs) > 0, err
+}
+
+func (a *Adapter) discoverableIfUnpaired() {
+	paired, err := a.HasPairedDevice()
+	if err != nil {
+		btlog.Debug("Adapter: failed to list devices", err)
+		return
+	}
+	if !paired {
+		btlog.Debug("Adapter: no paired host; becoming discoverable")
+		a.SetDiscoverable(true)
+	}
+}
+
+func (a *Adapter) set(name string, v interface{}) error {
+	err := a.props.SetProperty(name, v)
+	if err != nil {
+		btlog.Debug("Adapter: failed to set property", name, v, err)
+	}
+	return err
+}
@@ -0,0 +1,163 @@
+package gobt
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/godbus/dbus"
+)
+
+// Holds adapter properties and records every set
+type fakeProps struct {
+	values map[string]interface{}
+	sets   []adapterProp
+	// Fails setting this property
+	fail string
+}
+
+func newFakeProps() *fakeProps {
+	return &fakeProps{values: map[string]interface{}{
+		"Powered":             false,
+		"Alias":               "raspberrypi",
+		"Pairable":            false,
+		"DiscoverableTimeout": uint32(0),
+		"Discoverable":        true,
+	}}
+}
+
+func (f *fakeProps) GetProperty(name string) (dbus.Variant, error) {
+	v, ok := f.values[name]
+	if !ok {
+		return dbus.Variant{}, errors.New("no property " + name)
+	}
+	return dbus.MakeVariant(v), nil
+}
+
+func (f *fakeProps) SetProperty(name string, v interface{}) error {
+	f.sets = append(f.sets, adapterProp{name, v})
+	if name == f.fail {
+		return errors.New("failed " + name)
+	}
+	f.values[name] = v
+	return nil
+}
+
+func newFakeAdapter(cfg AdapterConfig) (*Adapter, *fakeProps) {
+	f := newFakeProps()
+	cfg.DiscoverableWhenUnpaired = false
+	return &Adapter{cfg: cfg, props: f, saved: make(map[string]dbus.Variant)}, f
+}
+
+func TestAdapterApplyRestore(t *testing.T) {
+	cfg := DefaultAdapterConfig()
+	cfg.Alias = "gobt"
+	a, f := newFakeAdapter(cfg)
+	orig := newFakeProps().values
+
+	if err := a.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	want := []adapterProp{
+		{"Powered", true},
+		{"Pairable", true},
+		{"DiscoverableTimeout", uint32(180)},
+		{"Discoverable", false},
+		{"Alias", "gobt"},
+	}
+	if !reflect.DeepEqual(f.sets, want) {
+		t.Errorf("applied %v; want %v", f.sets, want)
+	}
+
+	f.sets = nil
+	if err := a.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	want = []adapterProp{
+		{"Discoverable", true},
+		{"DiscoverableTimeout", uint32(0)},
+		{"Pairable", false},
+		{"Alias", "raspberrypi"},
+		{"Powered", false},
+	}
+	if !reflect.DeepEqual(f.sets, want) {
+		t.Errorf("restored %v; want %v", f.sets, want)
+	}
+	if !reflect.DeepEqual(f.values, orig) {
+		t.Errorf("properties %v; want %v", f.values, orig)
+	}
+}
+
+func TestAdapterApplyKeepsAlias(t *testing.T) {
+	a, f := newFakeAdapter(DefaultAdapterConfig())
+	if err := a.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	// alias renamed meanwhile stays as well
+	f.values["Alias"] = "renamed"
+	if err := a.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range f.sets {
+		if p.name == "Alias" {
+			t.Errorf("alias set to %v", p.value)
+		}
+	}
+}
+
+// Properties set before the failing one are restored
+func TestAdapterApplyFails(t *testing.T) {
+	a, f := newFakeAdapter(DefaultAdapterConfig())
+	orig := newFakeProps().values
+	f.fail = "DiscoverableTimeout"
+	if err := a.Apply(); err == nil {
+		t.Error("no error")
+	}
+	want := []adapterProp{
+		{"Powered", true},
+		{"Pairable", true},
+		{"DiscoverableTimeout", uint32(180)},
+		{"Pairable", false},
+		{"Powered", false},
+	}
+	if !reflect.DeepEqual(f.sets, want) {
+		t.Errorf("set %v; want %v", f.sets, want)
+	}
+	if !reflect.DeepEqual(f.values, orig) {
+		t.Errorf("properties %v; want %v", f.values, orig)
+	}
+
+	// nothing left to restore
+	f.sets = nil
+	if err := a.Restore(); err != nil || len(f.sets) != 0 {
+		t.Errorf("restored %v %v", f.sets, err)
+	}
+}
+
+func TestAdapterApplyGetFails(t *testing.T) {
+	a, f := newFakeAdapter(DefaultAdapterConfig())
+	delete(f.values, "Pairable")
+	if err := a.Apply(); err == nil {
+		t.Error("no error")
+	}
+	if len(f.sets) != 0 {
+		t.Errorf("set %v before saving state", f.sets)
+	}
+}
+
+// A failing property does not keep the others from being restored
+func TestAdapterRestoreFails(t *testing.T) {
+	a, f := newFakeAdapter(DefaultAdapterConfig())
+	if err := a.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	f.sets = nil
+	f.fail = "Pairable"
+	if err := a.Restore(); err == nil {
+		t.Error("no error")
+	}
+	// every property but Alias
+	if len(f.sets) != len(adapterProps)-1 || f.sets[len(f.sets)-1].name != "Powered" {
+		t.Errorf("restored %v", f.sets)
+	}
+}
@@ -0,0 +1,250 @@
+package gobt
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus"
+	"github.com/potch8228/gobt/hid"
+	btlog "github.com/potch8228/gobt/log"
+)
+
+// IO capabilities passed to org.bluez.AgentManager1.RegisterAgent
+const (
+	CAPNOINPUTNOOUTPUT = "NoInputNoOutput"
+	CAPKEYBOARDONLY    = "KeyboardOnly"
+	CAPDISPLAYONLY     = "DisplayOnly"
+)
+
+const (
+	AGENTERRREJECTED = "org.bluez.Error.Rejected"
+	AGENTERRCANCELED = "org.bluez.Error.Canceled"
+)
+
+type AgentPolicy byte
+
+const (
+	POLICYACCEPT AgentPolicy = iota
+	POLICYREJECT
+)
+
+type AgentConfig struct {
+	Capability string
+
+	// Answer for RequestConfirmation and RequestAuthorization
+	Confirm AgentPolicy
+	// Answer for AuthorizeService
+	Authorize AgentPolicy
+
+	// Reads passkey/PIN code typed on the locally attached keyboard
+	// Otherwise Passkey/PinCode is answered
+	PasskeyFromKeyboard bool
+	KeyboardGlob        string
+	PasskeyTimeout      time.Duration
+
+	Passkey uint32
+	PinCode string
+}
+
+func DefaultAgentConfig() AgentConfig {
+	return AgentConfig{
+		Capability:          CAPKEYBOARDONLY,
+		Confirm:             POLICYACCEPT,
+		Authorize:           POLICYACCEPT,
+		PasskeyFromKeyboard: true,
+		KeyboardGlob:        "/dev/input/by-path/*event-kbd",
+		PasskeyTimeout:      30 * time.Second,
+		PinCode:             "0000",
+	}
+}
+
+// org.bluez.Agent1 implementation
+type Agent struct {
+	path   dbus.ObjectPath
+	cfg    AgentConfig
+	policy *HostPolicy
+
+	mu     sync.Mutex
+	cancel chan struct{}
+	// Keyboards forwarded by gobt; nil opens KeyboardGlob instead
+	keyboards *hid.Hotkeys
+}
+
+func NewAgent(path string, cfg AgentConfig, policy *HostPolicy) *Agent {
+	return &Agent{
+		path:   (dbus.ObjectPath)(path),
+		cfg:    cfg,
+		policy: policy,
+	}
+}
+
+// Reads passkeys from the keyboards sharing hotkeys instead of opening KeyboardGlob
+// Needed while gobt grabs keyboards; the passkey is not typed on connected hosts
+func (a *Agent) SetKeyboards(hotkeys *hid.Hotkeys) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.keyboards = hotkeys
+}
+
+func (a *Agent) Path() dbus.ObjectPath {
+	return a.path
+}
+
+// Registers agent as the default agent
+// Agent must be exported on conn beforehand
+func (a *Agent) Register(conn *dbus.Conn) error {
+	obj := conn.Object("org.bluez", "/org/bluez")
+	if call := obj.Call("org.bluez.AgentManager1.RegisterAgent", 0, a.path, a.cfg.Capability); call.Err != nil {
+		return call.Err
+	}
+	if call := obj.Call("org.bluez.AgentManager1.RequestDefaultAgent", 0, a.path); call.Err != nil {
+		return call.Err
+	}
+	btlog.Debug("Agent registered", a.path, a.cfg.Capability)
+	return nil
+}
+
+func (a *Agent) Unregister(conn *dbus.Conn) error {
+	a.Cancel()
+	obj := conn.Object("org.bluez", "/org/bluez")
+	return obj.Call("org.bluez.AgentManager1.UnregisterAgent", 0, a.path).Err
+}
+
+func (a *Agent) Release() *dbus.Error {
+	btlog.Debug("Agent Release")
+	a.Cancel()
+	return nil
+}
+
+func (a *Agent) RequestPinCode(dev dbus.ObjectPath) (string, *dbus.Error) {
+	btlog.Debug("Agent RequestPinCode", dev)
+	if derr := a.checkHost(dev); derr != nil {
+		return "", derr
+	}
+	if !a.cfg.PasskeyFromKeyboard {
+		return a.cfg.PinCode, nil
+	}
+
+	btlog.ForceDebug(fmt.Sprintf("Type PIN code for %s on keyboard and press Enter", dev))
+	pin, err := a.readKeyboard()
+	if err != nil {
+		return "", err
+	}
+	return pin, nil
+}
+
+func (a *Agent) DisplayPinCode(dev dbus.ObjectPath, pincode string) *dbus.Error {
+	btlog.ForceDebug(fmt.Sprintf("PIN code for %s: %s", dev, pincode))
+	return nil
+}
+
+func (a *Agent) RequestPasskey(dev dbus.ObjectPath) (uint32, *dbus.Error) {
+	btlog.Debug("Agent RequestPasskey", dev)
+	if derr := a.checkHost(dev); derr != nil {
+		return 0, derr
+	}
+	if !a.cfg.PasskeyFromKeyboard {
+		return a.cfg.Passkey, nil
+	}
+
+	btlog.ForceDebug(fmt.Sprintf("Type passkey for %s on keyboard and press Enter", dev))
+	digits, derr := a.readKeyboard()
+	if derr != nil {
+		return 0, derr
+	}
+
+	passkey, err := strconv.ParseUint(digits, 10, 32)
+	if err != nil || passkey > 999999 {
+		btlog.Debug("Agent RequestPasskey: invalid passkey", digits)
+		return 0, dbus.NewError(AGENTERRREJECTED, []interface{}{"invalid passkey"})
+	}
+	return uint32(passkey), nil
+}
+
+func (a *Agent) DisplayPasskey(dev dbus.ObjectPath, passkey uint32, entered uint16) *dbus.Error {
+	btlog.ForceDebug(fmt.Sprintf("Passkey for %s: %06d (%d entered)", dev, passkey, entered))
+	return nil
+}
+
+func (a *Agent) RequestConfirmation(dev dbus.ObjectPath, passkey uint32) *dbus.Error {
+	if derr := a.checkHost(dev); derr != nil {
+		return derr
+	}
+	btlog.ForceDebug(fmt.Sprintf("Confirm passkey for %s: %06d", dev, passkey))
+	return a.decide(a.cfg.Confirm, "confirmation")
+}
+
+func (a *Agent) RequestAuthorization(dev dbus.ObjectPath) *dbus.Error {
+	btlog.Debug("Agent RequestAuthorization", dev)
+	if derr := a.checkHost(dev); derr != nil {
+		return derr
+	}
+	return a.decide(a.cfg.Confirm, "authorization")
+}
+
+func (a *Agent) AuthorizeService(dev dbus.ObjectPath, uuid string) *dbus.Error {
+	btlog.Debug("Agent AuthorizeService", dev, uuid)
+	if derr := a.checkHost(dev); derr != nil {
+		return derr
+	}
+	return a.decide(a.cfg.Authorize, "service "+uuid)
+}
+
+func (a *Agent) Cancel() *dbus.Error {
+	btlog.Debug("Agent Cancel")
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.cancel != nil {
+		close(a.cancel)
+		a.cancel = nil
+	}
+	return nil
+}
+
+func (a *Agent) checkHost(dev dbus.ObjectPath) *dbus.Error {
+	if addr, ok := a.policy.AllowedDevice(dev); !ok {
+		btlog.Debug("Agent: host is not allowed", dev)
+		return rejectedError(addr)
+	}
+	return nil
+}
+
+func (a *Agent) decide(p AgentPolicy, what string) *dbus.Error {
+	if p == POLICYACCEPT {
+		return nil
+	}
+	btlog.Debug("Agent rejected", what)
+	return dbus.NewError(AGENTERRREJECTED, []interface{}{what + " rejected"})
+}
+
+func (a *Agent) readKeyboard() (string, *dbus.Error) {
+	cancel := make(chan struct{})
+	a.mu.Lock()
+	a.cancel = cancel
+	keyboards := a.keyboards
+	a.mu.Unlock()
+
+	var digits string
+	var err error
+	if keyboards != nil {
+		digits, err = keyboards.ReadDigits(a.cfg.PasskeyTimeout, cancel)
+	} else {
+		paths, _ := filepath.Glob(a.cfg.KeyboardGlob)
+		digits, err = hid.ReadDigits(paths, a.cfg.PasskeyTimeout, cancel)
+	}
+
+	a.mu.Lock()
+	if a.cancel == cancel {
+		a.cancel = nil
+	}
+	a.mu.Unlock()
+
+	if err != nil {
+		btlog.Debug("Agent reading keyboard failed", err)
+		return "", dbus.NewError(AGENTERRCANCELED, []interface{}{err.Error()})
+	}
+	return digits, nil
+}
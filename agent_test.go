@@ -0,0 +1,89 @@
+package gobt
+
+import (
+	"testing"
+
+	"github.com/godbus/dbus"
+	"github.com/potch8228/gobt/bluetooth"
+)
+
+const (
+	allowedDev = dbus.ObjectPath("/org/bluez/hci0/dev_AA_BB_CC_DD_EE_FF")
+	deniedDev  = dbus.ObjectPath("/org/bluez/hci0/dev_11_22_33_44_55_66")
+)
+
+func testAgent(cfg AgentConfig) *Agent {
+	a, _ := bluetooth.ParseAddr("AA:BB:CC:DD:EE:FF")
+	return NewAgent("/test/agent", cfg, NewHostPolicy([]bluetooth.Addr{a}))
+}
+
+func errName(err *dbus.Error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Name
+}
+
+func TestAgentPolicy(t *testing.T) {
+	fixed := DefaultAgentConfig()
+	fixed.PasskeyFromKeyboard = false
+	rejecting := fixed
+	rejecting.Confirm, rejecting.Authorize = POLICYREJECT, POLICYREJECT
+	passkey := fixed
+	passkey.Passkey, passkey.PinCode = 123456, "1234"
+	// no keyboard to type on
+	keyboard := DefaultAgentConfig()
+	keyboard.KeyboardGlob = "/nonexistent/*event-kbd"
+
+	for _, c := range []struct {
+		name string
+		cfg  AgentConfig
+		dev  dbus.ObjectPath
+		call func(a *Agent, dev dbus.ObjectPath) (interface{}, *dbus.Error)
+		want interface{}
+		err  string
+	}{
+		{"passkey without keyboard", fixed, allowedDev, requestPasskey, uint32(0), ""},
+		{"configured passkey", passkey, allowedDev, requestPasskey, uint32(123456), ""},
+		{"passkey from missing keyboard", keyboard, allowedDev, requestPasskey, uint32(0), AGENTERRCANCELED},
+		{"passkey of denied host", passkey, deniedDev, requestPasskey, uint32(0), AGENTERRREJECTED},
+		{"passkey of unknown device", passkey, "/org/bluez/hci0", requestPasskey, uint32(0), AGENTERRREJECTED},
+		{"default PIN code", fixed, allowedDev, requestPinCode, "0000", ""},
+		{"configured PIN code", passkey, allowedDev, requestPinCode, "1234", ""},
+		{"PIN code from missing keyboard", keyboard, allowedDev, requestPinCode, "", AGENTERRCANCELED},
+		{"PIN code of denied host", passkey, deniedDev, requestPinCode, "", AGENTERRREJECTED},
+		{"confirm", fixed, allowedDev, confirm, nil, ""},
+		{"confirm rejected", rejecting, allowedDev, confirm, nil, AGENTERRREJECTED},
+		{"confirm denied host", fixed, deniedDev, confirm, nil, AGENTERRREJECTED},
+		{"authorization", fixed, allowedDev, authorize, nil, ""},
+		{"authorization rejected", rejecting, allowedDev, authorize, nil, AGENTERRREJECTED},
+		{"service", fixed, allowedDev, authorizeService, nil, ""},
+		{"service rejected", rejecting, allowedDev, authorizeService, nil, AGENTERRREJECTED},
+		{"service of denied host", fixed, deniedDev, authorizeService, nil, AGENTERRREJECTED},
+	} {
+		got, err := c.call(testAgent(c.cfg), c.dev)
+		if got != c.want || errName(err) != c.err {
+			t.Errorf("%s: got %v %v; want %v %q", c.name, got, err, c.want, c.err)
+		}
+	}
+}
+
+func requestPasskey(a *Agent, dev dbus.ObjectPath) (interface{}, *dbus.Error) {
+	return a.RequestPasskey(dev)
+}
+
+func requestPinCode(a *Agent, dev dbus.ObjectPath) (interface{}, *dbus.Error) {
+	return a.RequestPinCode(dev)
+}
+
+func confirm(a *Agent, dev dbus.ObjectPath) (interface{}, *dbus.Error) {
+	return nil, a.RequestConfirmation(dev, 123456)
+}
+
+func authorize(a *Agent, dev dbus.ObjectPath) (interface{}, *dbus.Error) {
+	return nil, a.RequestAuthorization(dev)
+}
+
+func authorizeService(a *Agent, dev dbus.ObjectPath) (interface{}, *dbus.Error) {
+	return nil, a.AuthorizeService(dev, "00001124-0000-1000-8000-00805f9b34fb")
+}
@@ -0,0 +1,39 @@
+package bluetooth
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Bluetooth device address(BD_ADDR)
+// Stored in bdaddr_t byte order; the least significant byte first
+type Addr [6]uint8
+
+var ADDRANY = Addr{0, 0, 0, 0, 0, 0}
+
+// Formats address as "AA:BB:CC:DD:EE:FF"
+func (a Addr) String() string {
+	return fmt.Sprintf("%02X:%02X:%02X:%02X:%02X:%02X", a[5], a[4], a[3], a[2], a[1], a[0])
+}
+
+// Parses address formatted as "AA:BB:CC:DD:EE:FF"; "_" is also accepted as separator
+func ParseAddr(s string) (Addr, error) {
+	var a Addr
+	ps := strings.FieldsFunc(s, func(r rune) bool { return r == ':' || r == '_' })
+	if len(ps) != len(a) {
+		return a, fmt.Errorf("invalid bluetooth address: %q", s)
+	}
+
+	for i, p := range ps {
+		if len(p) != 2 {
+			return a, fmt.Errorf("invalid bluetooth address: %q", s)
+		}
+		b, err := strconv.ParseUint(p, 16, 8)
+		if err != nil {
+			return a, fmt.Errorf("invalid bluetooth address: %q", s)
+		}
+		a[len(a)-1-i] = uint8(b)
+	}
+	return a, nil
+}
@@ -0,0 +1,28 @@
+package bluetooth
+
+import "testing"
+
+func TestParseAddr(t *testing.T) {
+	a, err := ParseAddr("00:1A:7D:DA:71:13")
+	if err != nil {
+		t.Fatal("ParseAddr failed", err)
+	}
+
+	if a != (Addr{0x13, 0x71, 0xda, 0x7d, 0x1a, 0x00}) {
+		t.Error("Incorrect byte order: got ", a)
+	}
+
+	if a.String() != "00:1A:7D:DA:71:13" {
+		t.Error("Incorrect format: got ", a.String())
+	}
+
+	if b, err := ParseAddr("00_1A_7D_DA_71_13"); err != nil || b != a {
+		t.Error("Object path style address is not parsed: got ", b, err)
+	}
+
+	for _, s := range []string{"", "00:1A:7D:DA:71", "00:1A:7D:DA:71:1G", "001:A:7D:DA:71:13"} {
+		if _, err := ParseAddr(s); err == nil {
+			t.Error("Invalid address is accepted: ", s)
+		}
+	}
+}
@@ -3,15 +3,18 @@ package bluetooth
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"syscall"
-	"time"
 	"unsafe"
 
 	btlog "github.com/potch8228/gobt/log"
+	"github.com/potch8228/gobt/metrics"
 
 	"golang.org/x/sys/unix"
 )
 
+var writeErrors = metrics.Default.Counter("gobt_bluetooth_write_errors_total", "Failed writes on L2CAP sockets, by error.", "error")
+
 type _Socklen uint32
 type RawSockaddrL2 struct {
 	Family uint16
@@ -68,9 +71,20 @@ type Bluetooth struct {
 	proto  int
 	typ    int
 	saddr  SockaddrL2
+	raddr  SockaddrL2
 
 	block bool
-	mu    sync.Mutex
+	// Held for each syscall on fd; not while waiting for data
+	mu sync.Mutex
+	// Set by Close; pending Read and Accept return EBADF
+	closed int32
+
+	// Eventfd written by Close to wake waits in poll(2); created by the first wait
+	efd     int
+	efdErr  error
+	efdOnce sync.Once
+	// Read locked while waiting in poll(2); Close write locks it before closing fds
+	waiting sync.RWMutex
 }
 
 // Sets socket as blocking mode(true) or Non-blocking mode(false)
@@ -127,7 +141,21 @@ func NewBluetoothSocket(fd int) (*Bluetooth, error) {
 		Bdaddr: rsa.Bdaddr,
 	}
 
-	btlog.Debug("Resolved sockname", bt.saddr, "New Socket is created")
+	var rpa RawSockaddrL2
+	addrlen = _Socklen(unsafe.Sizeof(RawSockaddrL2{}))
+	_, _, err = unix.RawSyscall(unix.SYS_GETPEERNAME, uintptr(fd), uintptr(unsafe.Pointer(&rpa)), uintptr(unsafe.Pointer(&addrlen)))
+	if int(err) != 0 {
+		btlog.Debug("Failure on getpeername", err)
+		unix.Close(fd)
+		return nil, err
+	}
+
+	bt.raddr = SockaddrL2{
+		PSM:    rpa.Psm,
+		Bdaddr: rpa.Bdaddr,
+	}
+
+	btlog.Debug("Resolved sockname", bt.saddr, "peername", bt.raddr, "New Socket is created")
 
 	return bt, nil
 }
@@ -190,30 +218,37 @@ func Listen(psm uint, bklen int, block bool) (*Bluetooth, error) {
 	return bt, nil
 }
 
+// Returns address of the connected peer
+func (bt *Bluetooth) RemoteAddr() Addr {
+	return Addr(bt.raddr.Bdaddr)
+}
+
 // Accepts on listening socket and return received connection
+// Waits for a connection without holding the socket, so that Close interrupts it
 func (bt *Bluetooth) Accept() (*Bluetooth, error) {
-	mu.Lock()
-	defer mu.Unlock()
-
 	var nFd int
 	var rAddr *SockaddrL2
 
-	fds := &FdSet{Bits: [32]int32{0}}
-	setFd(bt.fd, fds)
 	for {
+		if err := bt.wait(unix.POLLIN); err != nil {
+			return nil, err
+		}
+
 		var raddr RawSockaddrL2
 		var addrlen _Socklen = _Socklen(unsafe.Sizeof(RawSockaddrL2{}))
+		bt.mu.Lock()
+		if atomic.LoadInt32(&bt.closed) != 0 {
+			bt.mu.Unlock()
+			return nil, syscall.EBADF
+		}
 		rFd, _, err := unix.Syscall(unix.SYS_ACCEPT, uintptr(bt.fd), uintptr(unsafe.Pointer(&raddr)), uintptr(unsafe.Pointer(&addrlen)))
+		bt.mu.Unlock()
 		if err != 0 {
 			switch err {
-			case syscall.EAGAIN:
-				time.Sleep(1 * time.Millisecond)
-				continue
-			case syscall.ECONNABORTED:
+			case syscall.EAGAIN, syscall.ECONNABORTED:
 				continue
 			}
 			btlog.Debug("Accept: Socket Error", err)
-			unix.Close(int(rFd))
 			return nil, err
 		}
 
@@ -234,6 +269,7 @@ func (bt *Bluetooth) Accept() (*Bluetooth, error) {
 		block:  bt.block,
 		fd:     nFd,
 		saddr:  *rAddr,
+		raddr:  *rAddr,
 	}
 
 	unix.CloseOnExec(nFd)
@@ -250,10 +286,8 @@ func (bt *Bluetooth) Accept() (*Bluetooth, error) {
 	return rbt, nil
 }
 
+// Reads one packet; waits for data without holding the socket, so that Close interrupts it
 func (bt *Bluetooth) Read(b []byte) (int, error) {
-	bt.mu.Lock()
-	defer bt.mu.Unlock()
-
 	var bp unsafe.Pointer
 	var _zero uintptr
 	if len(b) > 0 {
@@ -261,23 +295,35 @@ func (bt *Bluetooth) Read(b []byte) (int, error) {
 	} else {
 		bp = unsafe.Pointer(&_zero)
 	}
-	fds := &FdSet{Bits: [32]int32{0}}
-	setFd(bt.fd, fds)
 
 	var r int
 	for {
+		// blocking sockets must not wait in read(2) holding mu
+		if err := bt.wait(unix.POLLIN); err != nil {
+			return -1, err
+		}
+
+		bt.mu.Lock()
+		if atomic.LoadInt32(&bt.closed) != 0 {
+			bt.mu.Unlock()
+			return -1, syscall.EBADF
+		}
 		_r, _, err := unix.Syscall(unix.SYS_READ, uintptr(bt.fd), uintptr(bp), uintptr(len(b)))
+		bt.mu.Unlock()
 
 		if err != 0 {
 			switch err {
 			case syscall.EAGAIN:
-				time.Sleep(1 * time.Millisecond)
 				continue
 			}
 			btlog.Debug("Bluetooth Read Error", err)
 			return -1, err
 		}
 
+		// closed while reading
+		if atomic.LoadInt32(&bt.closed) != 0 {
+			return -1, syscall.EBADF
+		}
 		r = int(_r)
 		break
 	}
@@ -288,6 +334,9 @@ func (bt *Bluetooth) Read(b []byte) (int, error) {
 func (bt *Bluetooth) Write(d []byte) (int, error) {
 	bt.mu.Lock()
 	defer bt.mu.Unlock()
+	if atomic.LoadInt32(&bt.closed) != 0 {
+		return -1, syscall.EBADF
+	}
 
 	var dp unsafe.Pointer
 	var _zero uintptr
@@ -305,6 +354,7 @@ func (bt *Bluetooth) Write(d []byte) (int, error) {
 
 		if err != 0 {
 			btlog.Debug("Bluetooth Write Error", err)
+			writeErrors.With(err.Error()).Inc()
 			return -1, err
 		}
 
@@ -315,7 +365,68 @@ func (bt *Bluetooth) Write(d []byte) (int, error) {
 	return r, nil
 }
 
+// Waits in poll(2) until fd has events or Close is called
+func (bt *Bluetooth) wait(events int16) error {
+	bt.efdOnce.Do(func() {
+		bt.efd, bt.efdErr = unix.Eventfd(0, unix.EFD_CLOEXEC|unix.EFD_NONBLOCK)
+		if bt.efdErr != nil {
+			btlog.Debug("Failure on creating eventfd", bt.efdErr)
+			bt.efd = -1
+		}
+	})
+
+	bt.waiting.RLock()
+	defer bt.waiting.RUnlock()
+	if atomic.LoadInt32(&bt.closed) != 0 {
+		return syscall.EBADF
+	}
+	if bt.efdErr != nil {
+		return bt.efdErr
+	}
+
+	fds := []unix.PollFd{
+		{Fd: int32(bt.fd), Events: events},
+		{Fd: int32(bt.efd), Events: unix.POLLIN},
+	}
+	for {
+		_, err := unix.Poll(fds, -1)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			btlog.Debug("Bluetooth Poll Error", err)
+			return err
+		}
+		break
+	}
+
+	if fds[1].Revents != 0 {
+		return syscall.EBADF
+	}
+	return nil
+}
+
+// Closes socket; a Read or Accept waiting on it returns EBADF
 func (bt *Bluetooth) Close() error {
+	if !atomic.CompareAndSwapInt32(&bt.closed, 0, 1) {
+		return unix.EINVAL
+	}
+
+	// no eventfd is needed once closed when nobody has waited yet
+	bt.efdOnce.Do(func() {
+		bt.efd = -1
+	})
+	if bt.efd >= 0 {
+		// never read, so every later poll(2) returns at once as well
+		unix.Write(bt.efd, []byte{1, 0, 0, 0, 0, 0, 0, 0})
+	}
+
+	bt.waiting.Lock()
+	defer bt.waiting.Unlock()
+	if bt.efd >= 0 {
+		unix.Close(bt.efd)
+	}
+
 	bt.mu.Lock()
 	defer bt.mu.Unlock()
 	if bt.fd <= 0 {
@@ -0,0 +1,165 @@
+package bluetooth
+
+import (
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Socket pair standing in for an L2CAP connection
+func socketPair(t *testing.T, block bool) (*Bluetooth, int) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bt := &Bluetooth{fd: fds[0], block: block}
+	if err := bt.SetBlocking(block); err != nil {
+		t.Fatal(err)
+	}
+	return bt, fds[1]
+}
+
+func TestReadWrite(t *testing.T) {
+	bt, peer := socketPair(t, false)
+	defer unix.Close(peer)
+	defer bt.Close()
+
+	if _, err := unix.Write(peer, []byte{0xa1, 0x01}); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, BUFSIZE)
+	if n, err := bt.Read(b); err != nil || n != 2 || b[0] != 0xa1 {
+		t.Errorf("Read %d %v % x", n, err, b[:2])
+	}
+
+	if n, err := bt.Write([]byte{0x00}); err != nil || n != 1 {
+		t.Errorf("Write %d %v", n, err)
+	}
+}
+
+// Close must not wait for the peer to send something
+func TestCloseWhileReading(t *testing.T) {
+	for _, block := range []bool{false, true} {
+		bt, peer := socketPair(t, block)
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := bt.Read(make([]byte, BUFSIZE))
+			done <- err
+		}()
+		// lets Read wait for data
+		time.Sleep(20 * time.Millisecond)
+
+		closed := make(chan error, 1)
+		go func() {
+			closed <- bt.Close()
+		}()
+		select {
+		case err := <-closed:
+			if err != nil {
+				t.Error("Close", block, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Close blocked by pending Read; blocking", block)
+		}
+
+		select {
+		case err := <-done:
+			if err != syscall.EBADF {
+				t.Error("pending Read returned", err, "; blocking", block)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("pending Read not interrupted; blocking", block)
+		}
+
+		if _, err := bt.Write([]byte{0}); err != syscall.EBADF {
+			t.Error("Write after Close returned", err)
+		}
+		if err := bt.Close(); err == nil {
+			t.Error("second Close succeeded")
+		}
+		unix.Close(peer)
+	}
+}
+
+// Listening socket standing in for an L2CAP one
+func listener(t *testing.T) (*Bluetooth, *unix.SockaddrUnix) {
+	fd, err := unix.Socket(unix.AF_UNIX, unix.SOCK_SEQPACKET|unix.SOCK_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// abstract address
+	sa := &unix.SockaddrUnix{Name: fmt.Sprintf("@gobt-test-%d", fd)}
+	if err := unix.Bind(fd, sa); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Listen(fd, 1); err != nil {
+		t.Fatal(err)
+	}
+	return &Bluetooth{fd: fd}, sa
+}
+
+func TestAccept(t *testing.T) {
+	bt, sa := listener(t)
+	defer bt.Close()
+
+	accepted := make(chan error, 1)
+	go func() {
+		rbt, err := bt.Accept()
+		if err == nil {
+			rbt.Close()
+		}
+		accepted <- err
+	}()
+	// lets Accept wait for a connection
+	time.Sleep(20 * time.Millisecond)
+
+	fd, err := unix.Socket(unix.AF_UNIX, unix.SOCK_SEQPACKET, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(fd)
+	if err := unix.Connect(fd, sa); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-accepted:
+		if err != nil {
+			t.Error("Accept", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("connection not accepted")
+	}
+}
+
+// Close must not wait for a connection to come
+func TestCloseWhileAccepting(t *testing.T) {
+	bt, _ := listener(t)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := bt.Accept()
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	if err := bt.Close(); err != nil {
+		t.Error("Close", err)
+	}
+	select {
+	case err := <-done:
+		if err != syscall.EBADF {
+			t.Error("pending Accept returned", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pending Accept not interrupted")
+	}
+
+	if _, err := bt.Accept(); err != syscall.EBADF {
+		t.Error("Accept after Close returned", err)
+	}
+}
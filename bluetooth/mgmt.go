@@ -0,0 +1,105 @@
+package bluetooth
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unsafe"
+
+	btlog "github.com/potch8228/gobt/log"
+
+	"golang.org/x/sys/unix"
+)
+
+// BlueZ Management API over HCI control channel
+// See doc/mgmt-api.txt in BlueZ sources
+const (
+	HCIDEVNONE        = 0xffff
+	HCICHANNELCONTROL = 3
+
+	MGMTOPSETDEVCLASS = 0x000e
+
+	MGMTEVCMDCOMPLETE = 0x0001
+	MGMTEVCMDSTATUS   = 0x0002
+
+	MGMTHDRSIZE = 6
+)
+
+type RawSockaddrHCI struct {
+	Family  uint16
+	Dev     uint16
+	Channel uint16
+}
+
+type MgmtError struct {
+	Opcode uint16
+	Status byte
+}
+
+func (e *MgmtError) Error() string {
+	return fmt.Sprintf("MgmtError: opcode 0x%04x failed with status 0x%02x", e.Opcode, e.Status)
+}
+
+// Sets Major/Minor Class of Device of the adapter(e.g. 0 for hci0)
+func SetDeviceClass(index uint16, major, minor byte) error {
+	return mgmtCommand(index, MGMTOPSETDEVCLASS, []byte{major & 0x1f, minor})
+}
+
+func mgmtCommand(index, opcode uint16, params []byte) error {
+	fd, err := unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_RAW, unix.BTPROTO_HCI)
+	if err != nil {
+		btlog.Debug("Mgmt socket could not be created", err)
+		return err
+	}
+	defer unix.Close(fd)
+	unix.CloseOnExec(fd)
+
+	addr := RawSockaddrHCI{
+		Family:  unix.AF_BLUETOOTH,
+		Dev:     HCIDEVNONE,
+		Channel: HCICHANNELCONTROL,
+	}
+	if _, _, err := unix.Syscall(unix.SYS_BIND, uintptr(fd), uintptr(unsafe.Pointer(&addr)), unsafe.Sizeof(addr)); int(err) != 0 {
+		btlog.Debug("Failure on Binding Mgmt Socket", err)
+		return err
+	}
+
+	tv := unix.Timeval{Sec: 2}
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		return err
+	}
+
+	cmd := make([]byte, MGMTHDRSIZE+len(params))
+	binary.LittleEndian.PutUint16(cmd[0:], opcode)
+	binary.LittleEndian.PutUint16(cmd[2:], index)
+	binary.LittleEndian.PutUint16(cmd[4:], uint16(len(params)))
+	copy(cmd[MGMTHDRSIZE:], params)
+	if _, err := unix.Write(fd, cmd); err != nil {
+		btlog.Debug("Failure on Writing Mgmt Command", err)
+		return err
+	}
+
+	r := make([]byte, BUFSIZE)
+	for {
+		n, err := unix.Read(fd, r)
+		if err != nil {
+			btlog.Debug("Failure on Reading Mgmt Reply", err)
+			return err
+		}
+		if n < MGMTHDRSIZE+3 {
+			continue
+		}
+
+		ev := binary.LittleEndian.Uint16(r[0:])
+		if ev != MGMTEVCMDCOMPLETE && ev != MGMTEVCMDSTATUS {
+			continue
+		}
+		if binary.LittleEndian.Uint16(r[2:]) != index || binary.LittleEndian.Uint16(r[MGMTHDRSIZE:]) != opcode {
+			continue
+		}
+
+		if st := r[MGMTHDRSIZE+2]; st != 0 {
+			return &MgmtError{Opcode: opcode, Status: st}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/potch8228/gobt/control"
+)
+
+// Parses flags of a command talking to the daemon and connects to its control socket
+func dial(name, args, help string, argv []string) (*control.Client, *flag.FlagSet) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	socket := fs.String("control", control.DEFAULTSOCKET, "control socket of gobt serve")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: gobt %s [options] %s\n\n%s\n\nOptions:\n", name, args, help)
+		fs.PrintDefaults()
+	}
+	fs.Parse(argv)
+
+	c, err := control.Dial(*socket)
+	if err != nil {
+		fail("gobt serve is not running:", err)
+	}
+	return c, fs
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func status(args []string) {
+	c, _ := dial("status", "", "Shows the state of gobt serve.", args)
+	defer c.Close()
+
+	st, err := c.Status()
+	if err != nil {
+		fail(err)
+	}
+	active := st.Active
+	if active == "" {
+		active = "-"
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "active host:\t%s\n", active)
+	fmt.Fprintf(w, "hosts:\t%d connected, %d paired\n", st.Connected, st.Paired)
+	fmt.Fprintf(w, "devices:\t%d keyboards, %d mice\n", st.Keyboards, st.Mice)
+	fmt.Fprintf(w, "grabbed:\t%s\n", yesNo(st.Grabbing))
+	fmt.Fprintf(w, "local:\t%s\n", yesNo(st.Local))
+	w.Flush()
+}
+
+func hosts(args []string) {
+	c, _ := dial("hosts", "", "Lists paired and connected hosts; the active host is marked with *.", args)
+	defer c.Close()
+
+	hs, err := c.Hosts()
+	if err != nil {
+		fail(err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "\tADDRESS\tNAME\tPAIRED\tCONNECTED\tPROTOCOL\tQUEUED")
+	for _, h := range hs {
+		mark := ""
+		if h.Active {
+			mark = "*"
+		}
+		proto := h.Protocol
+		if proto == "" {
+			proto = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\n", mark, h.Addr, h.Name, yesNo(h.Paired), yesNo(h.Connected), proto, h.QueueDepth)
+	}
+	w.Flush()
+}
+
+func devices(args []string) {
+	fs := flag.NewFlagSet("devices", flag.ExitOnError)
+	enable := fs.String("enable", "", "forwards device at this path again")
+	disable := fs.String("disable", "", "stops forwarding device at this path; it is left to the local machine")
+	socket := fs.String("control", control.DEFAULTSOCKET, "control socket of gobt serve")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: gobt devices [options]\n\nLists local input devices, or enables or disables one.\n\nOptions:")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	c, err := control.Dial(*socket)
+	if err != nil {
+		fail("gobt serve is not running:", err)
+	}
+	defer c.Close()
+
+	switch {
+	case *enable != "":
+		err = c.SetDevice(*enable, true)
+	case *disable != "":
+		err = c.SetDevice(*disable, false)
+	default:
+		var ds []control.Device
+		if ds, err = c.Devices(); err != nil {
+			break
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "PATH\tKIND\tENABLED\tNAME")
+		for _, d := range ds {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", d.Path, d.Kind, yesNo(d.Enabled), d.Name)
+		}
+		w.Flush()
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+func switchHost(args []string) {
+	c, fs := dial("switch", "<host>", "Sends input to host, given by address or paired name.", args)
+	defer c.Close()
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := c.Switch(fs.Arg(0)); err != nil {
+		fail(err)
+	}
+}
+
+func pair(args []string) {
+	c, _ := dial("pair", "", "Makes the adapter discoverable so that a new host can pair.", args)
+	defer c.Close()
+	if err := c.Pair(); err != nil {
+		fail(err)
+	}
+	fmt.Println("Discoverable; pair from the host now")
+}
+
+func unpair(args []string) {
+	c, fs := dial("unpair", "<host>", "Disconnects host and removes its pairing.", args)
+	defer c.Close()
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := c.Unpair(fs.Arg(0)); err != nil {
+		fail(err)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/potch8228/gobt/hid"
+)
+
+// Prints SDP records registered with BlueZ
+func sdp(args []string) {
+	fs := flag.NewFlagSet("sdp", flag.ExitOnError)
+	did := fs.Bool("did", false, "prints the Device ID record instead of the HID record")
+	cfg := configFlag(fs)
+	fs.Parse(args)
+
+	_, st := configure(fs, *cfg, serverOptions{})
+	id := st.Identity
+	rec, err := id.ServiceRecord()
+	if *did {
+		rec, err = id.DeviceIDRecord()
+	}
+	if err != nil {
+		fail(err)
+	}
+	fmt.Print(rec)
+}
+
+// Decodes the HID report descriptor of gobt, or of a file such as /sys/class/hidraw/hidraw0/device/report_descriptor
+func descriptor(args []string) {
+	fs := flag.NewFlagSet("descriptor", flag.ExitOnError)
+	raw := fs.Bool("raw", false, "prints bytes in hex without decoding")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: gobt descriptor [options] [file]\n\nDecodes the report descriptor of gobt, or of file.\n\nOptions:")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	desc := hid.ReportDescriptor
+	if fs.NArg() > 0 {
+		b, err := ioutil.ReadFile(fs.Arg(0))
+		if err != nil {
+			fail(err)
+		}
+		desc = b
+	}
+
+	if *raw {
+		fmt.Printf("% x\n", desc)
+		return
+	}
+	items, err := hid.ParseItems(desc)
+	if ferr := hid.FormatItems(os.Stdout, items); ferr != nil {
+		fail(ferr)
+	}
+	if err != nil {
+		fail(err)
+	}
+}
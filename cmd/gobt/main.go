@@ -1,70 +1,146 @@
 package main
 
 import (
-	"bytes"
-	"io/ioutil"
+	"flag"
+	"fmt"
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
 
-	"github.com/godbus/dbus"
 	"github.com/potch8228/gobt"
-	"github.com/potch8228/gobt/bluetooth"
+	"github.com/potch8228/gobt/config"
+	"github.com/potch8228/gobt/control"
 	btlog "github.com/potch8228/gobt/log"
-	"github.com/satori/go.uuid"
 )
 
-func main() {
-	connIntr, err := bluetooth.Listen(bluetooth.PSMINTR, 1, false)
-	if err != nil {
-		btlog.Fatal("Listen failed", err, bluetooth.PSMINTR)
-	}
+func usage() {
+	fmt.Fprintln(os.Stderr, `Usage: gobt [command] [arguments]
 
-	hidp := gobt.NewHidProfile("/red/potch/profile", connIntr)
+Commands:
+	serve       forwards local keyboards and mice to hosts(default)
+	status      shows the state of gobt serve
+	hosts       lists paired and connected hosts
+	devices     lists local input devices; enables or disables one
+	switch      sends input to another host
+	type        types text on a host and exits
+	pair        makes the adapter discoverable for a new host
+	unpair      removes pairing of a host
+	sdp         prints the SDP record
+	descriptor  decodes the HID report descriptor
 
-	conn, err := dbus.SystemBus()
-	if err != nil {
-		btlog.Fatal("Failed to connect to system bus", err)
-	}
+Run "gobt <command> -h" for the options of a command.`)
+}
 
-	if err := conn.Export(hidp, hidp.Path(), "org.bluez.Profile1"); err != nil {
-		btlog.Fatal(err)
+func main() {
+	cmd, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
 	}
-	btlog.Debug("org.bluez.Profile1 exported")
 
-	s, err := os.Open("./sdp_record.xml")
-	if err != nil {
-		btlog.Fatal(err)
+	switch cmd {
+	case "serve":
+		serve(args)
+	case "status":
+		status(args)
+	case "hosts":
+		hosts(args)
+	case "devices":
+		devices(args)
+	case "switch":
+		switchHost(args)
+	case "type":
+		typeText(args)
+	case "pair":
+		pair(args)
+	case "unpair":
+		unpair(args)
+	case "sdp":
+		sdp(args)
+	case "descriptor":
+		descriptor(args)
+	case "help":
+		usage()
+	default:
+		usage()
+		os.Exit(2)
 	}
-	sdp, err := ioutil.ReadAll(s)
+}
+
+// Prints error and exits
+func fail(args ...interface{}) {
+	fmt.Fprintln(os.Stderr, append([]interface{}{"gobt:"}, args...)...)
+	os.Exit(1)
+}
+
+// Adds the -config flag to fs
+func configFlag(fs *flag.FlagSet) *string {
+	return fs.String("config", config.DEFAULTPATH, "configuration file(TOML); defaults are used when the default file is missing")
+}
+
+// Names of flags given on the command line
+func setFlags(fs *flag.FlagSet) map[string]bool {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+// Loads configuration file given by the -config flag; exits on errors
+func configure(fs *flag.FlagSet, path string, sopts serverOptions) (serverOptions, *config.Settings) {
+	sopts.Config = path
+	// a file given explicitly has to exist
+	sopts.ConfigOptional = !setFlags(fs)["config"]
+	st, err := loadSettings(sopts)
 	if err != nil {
-		btlog.Fatal(err)
+		fail(err)
 	}
+	return sopts, st
+}
 
-	opts := map[string]dbus.Variant{
-		"PSM": dbus.MakeVariant(uint16(bluetooth.PSMCTRL)),
-		"RequireAuthentication": dbus.MakeVariant(true),
-		"RequireAuthorization":  dbus.MakeVariant(true),
-		"ServiceRecord":         dbus.MakeVariant(bytes.NewBuffer(sdp).String()),
-	}
-	uid := uuid.NewV4()
-
-	dObjCh := make(chan *dbus.Call, 1)
-	dObj := conn.Object("org.bluez", "/org/bluez")
-	regObjCall := dObj.Go("org.bluez.ProfileManager1.RegisterProfile", 0, dObjCh, hidp.Path(), uid.String(), opts)
-	btlog.Debug(regObjCall)
-	var r interface{}
-	if regObjCall.Err != nil {
-		btlog.Fatal(regObjCall.Store(&r), r, regObjCall.Err)
-	}
-	btlog.Debug("HID Profile registered")
+func serve(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	cfg := configFlag(fs)
+	vendorKeys := fs.Bool("vendor-keys", false, "forward keys without HID usage as vendor usages(report ID 3); overrides devices.vendor_keys")
+	maxHold := fs.Duration("max-hold", gobt.DEFAULTMAXHOLD, "releases keys held this long without key events; 0 disables it; overrides devices.max_hold")
+	metricsAddr := fs.String("metrics", "", "serves Prometheus metrics on /metrics at this address(e.g. :9101); overrides profile.metrics")
+	socket := fs.String("control", control.DEFAULTSOCKET, "Unix socket of the control API; empty disables it; overrides profile.control_socket")
+	fs.Parse(args)
+
+	set := setFlags(fs)
+	sopts, st := configure(fs, *cfg, serverOptions{
+		Inputs: true,
+		Override: func(st *config.Settings) {
+			if set["vendor-keys"] {
+				st.VendorKeys = *vendorKeys
+			}
+			if set["max-hold"] {
+				st.MaxHold = *maxHold
+			}
+			if set["metrics"] {
+				st.MetricsAddr = *metricsAddr
+			}
+			if set["control"] {
+				st.ControlSocket = *socket
+			}
+		},
+	})
+	s := startServer(sopts, st)
 
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt)
+	// SIGUSR1 prints report latency histograms
+	dump := make(chan os.Signal, 1)
+	signal.Notify(dump, syscall.SIGUSR1)
+	// SIGHUP reloads the configuration file
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 
 	evloop := true
 	for evloop {
 		select {
-		case dObjCall := <-dObjCh:
+		case dObjCall := <-s.dObjCh:
 			if dObjCall.Err != nil {
 				btlog.Debug(dObjCall.Err)
 				evloop = false
@@ -72,20 +148,12 @@ func main() {
 		case <-sig:
 			btlog.Debug("Will Quit Program")
 			evloop = false
-		default:
+		case <-dump:
+			s.hidp.Latency().Dump(os.Stdout)
+		case <-hup:
+			s.reload()
 		}
 	}
 
-	// Probably no need of closing profile
-	btlog.Debug("Trying to Close Profile")
-	unregObjCall := dObj.Call("org.bluez.ProfileManager1.UnregisterProfile", 0, hidp.Path())
-	btlog.Debug(unregObjCall)
-	if unregObjCall.Err != nil {
-		btlog.Debug(unregObjCall.Store(&r), r, regObjCall.Err)
-	}
-	btlog.Debug("HID Profile unregistered", "Trying to Destroy Profile Obj")
-	hidp.Close()
-
-	close(dObjCh)
-	conn.Close()
+	s.stop()
 }
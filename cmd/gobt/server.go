@@ -0,0 +1,366 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/godbus/dbus"
+	"github.com/potch8228/gobt"
+	"github.com/potch8228/gobt/bluetooth"
+	"github.com/potch8228/gobt/config"
+	"github.com/potch8228/gobt/control"
+	"github.com/potch8228/gobt/hid"
+	btlog "github.com/potch8228/gobt/log"
+	"github.com/potch8228/gobt/metrics"
+	"github.com/satori/go.uuid"
+)
+
+// Registered profiles, agent and adapter of a running gobt
+type server struct {
+	conn    *dbus.Conn
+	hidp    *gobt.HidProfile
+	didp    *gobt.DeviceIDProfile
+	agent   *gobt.Agent
+	adapter *gobt.Adapter
+	control *control.Server
+	policy  *gobt.HostPolicy
+
+	// Settings in effect and where they came from
+	settings *config.Settings
+	sopts    serverOptions
+	logFile  *os.File
+	// Device settings in effect; devices are reopened when they change
+	devices deviceSettings
+
+	dObj dbus.BusObject
+	// Receives the result of HID profile registration
+	dObjCh chan *dbus.Call
+}
+
+type serverOptions struct {
+	// Forwards local input devices
+	Inputs bool
+	// Configuration file read again on reload
+	Config string
+	// Configuration file may be missing; the defaults are used then
+	ConfigOptional bool
+	// Applied over every loaded configuration; e.g. command line flags
+	Override func(st *config.Settings)
+}
+
+// Settings applied when input devices are opened
+type deviceSettings struct {
+	inputDir   string
+	rules      hid.DeviceRules
+	layers     *hid.LayerConfig
+	vendorKeys bool
+	maxHold    time.Duration
+}
+
+// Reads the configuration file of sopts
+func loadSettings(sopts serverOptions) (*config.Settings, error) {
+	st, err := config.Load(sopts.Config, sopts.ConfigOptional)
+	if err != nil {
+		return nil, err
+	}
+	if sopts.Override != nil {
+		sopts.Override(st)
+	}
+	return st, nil
+}
+
+// Registers profiles and starts accepting hosts
+func startServer(sopts serverOptions, st *config.Settings) *server {
+	s := &server{sopts: sopts}
+	s.applyLogging(st)
+
+	connIntr, err := bluetooth.Listen(uint(st.PSMIntr), 1, false)
+	if err != nil {
+		btlog.Fatal("Listen failed", err, st.PSMIntr)
+	}
+
+	policy := gobt.NewHostPolicy(st.Security.AllowedHosts)
+
+	hidp := gobt.NewHidProfile(st.ProfilePath, connIntr, policy, st.Security.InterruptTimeout)
+	s.hidp, s.policy = hidp, policy
+
+	if err := s.apply(st); err != nil {
+		btlog.Fatal("Failed to load keymaps", err)
+	}
+
+	if st.MetricsAddr != "" {
+		serveMetrics(st.MetricsAddr)
+	}
+
+	if sopts.Inputs {
+		if err := hidp.Devices().Start(); err != nil {
+			btlog.Fatal("Failed to watch input devices", err)
+		}
+	}
+
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		btlog.Fatal("Failed to connect to system bus", err)
+	}
+
+	if err := conn.Export(hidp, hidp.Path(), "org.bluez.Profile1"); err != nil {
+		btlog.Fatal(err)
+	}
+	btlog.Debug("org.bluez.Profile1 exported")
+
+	agent := gobt.NewAgent(st.AgentPath, st.Agent, policy)
+	if sopts.Inputs {
+		// keyboards are grabbed while hosts are connected
+		agent.SetKeyboards(hidp.Hotkeys())
+	}
+	if err := conn.Export(agent, agent.Path(), "org.bluez.Agent1"); err != nil {
+		btlog.Fatal(err)
+	}
+	if err := agent.Register(conn); err != nil {
+		btlog.Fatal("Agent registration failed", err)
+	}
+
+	adapter := gobt.NewAdapter(conn, st.Adapter)
+	if err := adapter.Apply(); err != nil {
+		btlog.Fatal("Failed to configure adapter", err)
+	}
+	if err := adapter.Watch(hidp.Hotkeys()); err != nil {
+		btlog.Debug("Failed to watch adapter", err)
+	}
+
+	identity := st.Identity
+
+	major, minor := identity.ClassOfDevice()
+	if err := bluetooth.SetDeviceClass(adapter.Index(), major, minor); err != nil {
+		btlog.Debug("Failed to set Class of Device", err)
+	}
+
+	sdp, err := identity.ServiceRecord()
+	if err != nil {
+		btlog.Fatal(err)
+	}
+
+	opts := map[string]dbus.Variant{
+		"PSM":                   dbus.MakeVariant(st.PSMCtrl),
+		"RequireAuthentication": dbus.MakeVariant(true),
+		"RequireAuthorization":  dbus.MakeVariant(true),
+		"ServiceRecord":         dbus.MakeVariant(sdp),
+	}
+	uid := uuid.NewV4()
+
+	dObjCh := make(chan *dbus.Call, 1)
+	dObj := conn.Object("org.bluez", "/org/bluez")
+	regObjCall := dObj.Go("org.bluez.ProfileManager1.RegisterProfile", 0, dObjCh, hidp.Path(), uid.String(), opts)
+	btlog.Debug(regObjCall)
+	var r interface{}
+	if regObjCall.Err != nil {
+		btlog.Fatal(regObjCall.Store(&r), r, regObjCall.Err)
+	}
+	btlog.Debug("HID Profile registered")
+
+	didp := gobt.NewDeviceIDProfile(string(hidp.Path()) + "/did")
+	if err := conn.Export(didp, didp.Path(), "org.bluez.Profile1"); err != nil {
+		btlog.Fatal(err)
+	}
+
+	did, err := identity.DeviceIDRecord()
+	if err != nil {
+		btlog.Fatal(err)
+	}
+
+	didOpts := map[string]dbus.Variant{
+		"Role":          dbus.MakeVariant("server"),
+		"ServiceRecord": dbus.MakeVariant(did),
+	}
+	if call := dObj.Call("org.bluez.ProfileManager1.RegisterProfile", 0, didp.Path(), gobt.DIDUUID, didOpts); call.Err != nil {
+		btlog.Debug("Device ID Profile registration failed", call.Err)
+	} else {
+		btlog.Debug("Device ID Profile registered")
+	}
+
+	var ctl *control.Server
+	if st.ControlSocket != "" {
+		if ctl, err = control.Listen(st.ControlSocket, hidp, adapter); err != nil {
+			btlog.Fatal("Control socket failed", err, st.ControlSocket)
+		}
+	}
+
+	s.conn, s.didp, s.agent, s.adapter, s.control = conn, didp, agent, adapter, ctl
+	s.dObj, s.dObjCh = dObj, dObjCh
+	s.settings = st
+	return s
+}
+
+// Applies settings which can change while hosts are connected
+// Hotkeys are registered again from scratch; nothing changes when the keymaps fail to load
+func (s *server) apply(st *config.Settings) error {
+	keymap, err := hid.LoadKeymapDir(st.KeymapDir)
+	if err != nil {
+		return err
+	}
+
+	hidp := s.hidp
+	s.policy.SetAllowed(st.Security.AllowedHosts)
+
+	hidp.Hotkeys().Reset()
+	hidp.Remapper().SetKeymap(keymap)
+	hidp.Macros().Register(hidp.Hotkeys(), keymap.Macros)
+	hidp.Macros().SetAbbrevs(keymap.Abbrevs)
+	dev := deviceSettings{
+		inputDir:   st.InputDir,
+		rules:      st.Rules,
+		vendorKeys: st.VendorKeys,
+		maxHold:    st.MaxHold,
+	}
+	if len(keymap.Layers) > 0 {
+		layers := st.Layers
+		layers.Layers = keymap.Layers
+		dev.layers = &layers
+	}
+
+	hidp.Router().Configure(st.Switch, hidp.Hotkeys())
+	hidp.Devices().ConfigureGrab(st.Grab)
+	if s.adapter != nil {
+		s.adapter.RegisterHotkey(hidp.Hotkeys())
+	}
+	hidp.Devices().SetLayers(dev.layers)
+	hidp.Devices().SetRules(dev.rules)
+	hidp.Devices().SetVendorKeys(dev.vendorKeys)
+	hidp.Devices().SetMaxHold(dev.maxHold)
+	hidp.Devices().SetInputDir(dev.inputDir)
+	s.devices = dev
+	return nil
+}
+
+// Sets debug output from settings; the DEBUG=1 environment variable keeps it enabled
+func (s *server) applyLogging(st *config.Settings) {
+	btlog.SetEnabled(st.Debug || os.Getenv("DEBUG") == "1")
+
+	old := s.logFile
+	s.logFile = nil
+	if st.LogFile == "" {
+		btlog.SetOutput(os.Stdout)
+	} else if f, err := os.OpenFile(st.LogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640); err != nil {
+		btlog.SetOutput(os.Stdout)
+		btlog.Debug("Failed to open log file", err, st.LogFile)
+	} else {
+		btlog.SetOutput(f)
+		s.logFile = f
+	}
+	if old != nil {
+		old.Close()
+	}
+}
+
+// Reads the configuration file again and applies it; connected hosts are kept
+// Settings of the adapter, the profiles and the listeners take effect on restart only
+func (s *server) reload() {
+	st, err := loadSettings(s.sopts)
+	if err != nil {
+		btlog.ForceDebug("Reload failed; keeping the current configuration", err)
+		return
+	}
+
+	old, oldDevices := s.settings, s.devices
+	s.applyLogging(st)
+	if err := s.apply(st); err != nil {
+		btlog.ForceDebug("Reload failed; keeping the current configuration", err)
+		s.applyLogging(old)
+		return
+	}
+	s.settings = st
+
+	// device settings apply to devices opened afterwards
+	if s.sopts.Inputs && !reflect.DeepEqual(oldDevices, s.devices) {
+		btlog.Debug("Device settings changed; reopening input devices")
+		s.hidp.Devices().ReleaseAll()
+		s.hidp.Devices().Stop()
+		if err := s.hidp.Devices().Start(); err != nil {
+			btlog.ForceDebug("Failed to watch input devices", err)
+		}
+	}
+
+	for _, c := range restartOnly(old, st) {
+		btlog.ForceDebug("Reload: restart gobt to apply " + c)
+	}
+	btlog.Debug("Configuration reloaded", s.sopts.Config)
+}
+
+// Names of changed settings which cannot be applied while running
+func restartOnly(old, st *config.Settings) []string {
+	var changed []string
+	for _, c := range []struct {
+		name     string
+		old, new interface{}
+	}{
+		{"profile.path", old.ProfilePath, st.ProfilePath},
+		{"profile.agent_path", old.AgentPath, st.AgentPath},
+		{"profile.psm_ctrl", old.PSMCtrl, st.PSMCtrl},
+		{"profile.psm_intr", old.PSMIntr, st.PSMIntr},
+		{"profile.control_socket", old.ControlSocket, st.ControlSocket},
+		{"profile.metrics", old.MetricsAddr, st.MetricsAddr},
+		{"adapter", old.Adapter, st.Adapter},
+		{"identity", old.Identity, st.Identity},
+		{"security.agent", old.Agent, st.Agent},
+		{"security.interrupt_timeout", old.Security.InterruptTimeout, st.Security.InterruptTimeout},
+	} {
+		if !reflect.DeepEqual(c.old, c.new) {
+			changed = append(changed, c.name)
+		}
+	}
+	return changed
+}
+
+// Serves metrics.Default over HTTP until the program exits
+func serveMetrics(addr string) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		btlog.Fatal("Metrics listen failed", err, addr)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default)
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			btlog.Debug("Metrics server stopped", err)
+		}
+	}()
+	btlog.Debug("Serving metrics", ln.Addr())
+}
+
+// Unregisters profiles and agent, and restores adapter state
+func (s *server) stop() {
+	if s.control != nil {
+		s.control.Close()
+	}
+
+	// Probably no need of closing profile
+	btlog.Debug("Trying to Close Profile")
+	var r interface{}
+	unregObjCall := s.dObj.Call("org.bluez.ProfileManager1.UnregisterProfile", 0, s.hidp.Path())
+	btlog.Debug(unregObjCall)
+	if unregObjCall.Err != nil {
+		btlog.Debug(unregObjCall.Store(&r), r, unregObjCall.Err)
+	}
+	btlog.Debug("HID Profile unregistered", "Trying to Destroy Profile Obj")
+	s.dObj.Call("org.bluez.ProfileManager1.UnregisterProfile", 0, s.didp.Path())
+	s.hidp.Close()
+
+	if err := s.agent.Unregister(s.conn); err != nil {
+		btlog.Debug("Agent unregistration failed", err)
+	}
+
+	if err := s.adapter.Restore(); err != nil {
+		btlog.Debug("Failed to restore adapter state", err)
+	}
+
+	close(s.dObjCh)
+	s.conn.Close()
+
+	if s.logFile != nil {
+		btlog.SetOutput(os.Stdout)
+		s.logFile.Close()
+	}
+}
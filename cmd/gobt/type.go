@@ -0,0 +1,157 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/potch8228/gobt"
+	"github.com/potch8228/gobt/bluetooth"
+	"github.com/potch8228/gobt/config"
+	"github.com/potch8228/gobt/control"
+	"github.com/potch8228/gobt/hid"
+)
+
+// Types text on a host; waits for the host to connect first
+func typeText(args []string) {
+	fs := flag.NewFlagSet("type", flag.ExitOnError)
+	layout := fs.String("layout", "us", "keyboard layout of the host: "+strings.Join(hid.LayoutNames(), ", ")+"; a running daemon uses its own")
+	delay := fs.Duration("delay", 20*time.Millisecond, "interval between reports")
+	host := fs.String("host", "", "address of the host; the first host connected when empty")
+	wait := fs.Duration("wait", time.Minute, "time to wait for the host to connect")
+	socket := fs.String("control", control.DEFAULTSOCKET, "control socket of a running gobt serve")
+	cfg := configFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: gobt type [options] [text]\n\nTypes text, or standard input when text is omitted or \"-\".\n\nOptions:")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	text := strings.Join(fs.Args(), " ")
+	if fs.NArg() == 0 || text == "-" {
+		b, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fail(err)
+		}
+		text = string(b)
+	}
+
+	// types through a running daemon, which holds the adapter and the hosts
+	if c, err := control.Dial(*socket); err == nil {
+		defer c.Close()
+		if *host != "" {
+			if err := c.Switch(*host); err != nil {
+				fail(err)
+			}
+		}
+		if err := c.Type(text); err != nil {
+			fail(err)
+		}
+		return
+	}
+
+	l, ok := hid.LookupLayout(*layout)
+	if !ok {
+		fail("unknown layout", *layout)
+	}
+	typer := hid.NewTyper(l, *delay)
+	// fails before connecting when the text cannot be typed
+	if _, err := typer.Reports(text); err != nil {
+		fail(err)
+	}
+
+	var addr bluetooth.Addr
+	if *host != "" {
+		var err error
+		if addr, err = bluetooth.ParseAddr(*host); err != nil {
+			fail(err)
+		}
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	cancel := make(chan struct{})
+	go func() {
+		<-sig
+		close(cancel)
+	}()
+
+	sopts, st := configure(fs, *cfg, serverOptions{
+		Override: func(st *config.Settings) {
+			// only types; the running daemon would be reached above
+			st.ControlSocket, st.MetricsAddr = "", ""
+		},
+	})
+	s := startServer(sopts, st)
+	defer s.stop()
+
+	gb := waitHost(s.hidp.Router(), addr, *wait, cancel)
+	if gb == nil {
+		fmt.Fprintln(os.Stderr, "gobt: no host connected")
+		return
+	}
+
+	// straight to the host; routing mode does not matter
+	if err := typer.Type(gb, text, cancel); err != nil {
+		fmt.Fprintln(os.Stderr, "gobt: typing failed:", err)
+	}
+	// lets the output queue drain before disconnecting
+	if !waitDrained(gb, DRAINTIMEOUT) {
+		fmt.Fprintln(os.Stderr, "gobt: host did not take every report")
+	}
+}
+
+const DRAINTIMEOUT = 5 * time.Second
+
+// Waits until every queued report of gb is written; false on timeout
+// The queue must stay empty for two polls since the last report may still be in flight
+func waitDrained(gb *gobt.GoBt, timeout time.Duration) bool {
+	tick := time.NewTicker(20 * time.Millisecond)
+	defer tick.Stop()
+	expire := time.After(timeout)
+
+	empty := 0
+	for {
+		if gb.QueueDepth() == 0 {
+			empty++
+		} else {
+			empty = 0
+		}
+		if empty >= 2 {
+			return true
+		}
+
+		select {
+		case <-tick.C:
+		case <-expire:
+			return false
+		}
+	}
+}
+
+// Waits for host addr, or any host when addr is zero
+func waitHost(router *gobt.Router, addr bluetooth.Addr, timeout time.Duration, cancel <-chan struct{}) *gobt.GoBt {
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+	expire := time.After(timeout)
+
+	for {
+		for _, gb := range router.Hosts() {
+			if addr == bluetooth.ADDRANY || gb.Addr() == addr {
+				return gb
+			}
+		}
+
+		select {
+		case <-tick.C:
+		case <-expire:
+			return nil
+		case <-cancel:
+			return nil
+		}
+	}
+}
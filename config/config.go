@@ -0,0 +1,608 @@
+// Package config reads the gobt configuration file(TOML)
+// Every key is optional; missing keys keep the defaults of the gobt packages
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/potch8228/gobt"
+	"github.com/potch8228/gobt/bluetooth"
+	"github.com/potch8228/gobt/control"
+	"github.com/potch8228/gobt/hid"
+)
+
+const (
+	DEFAULTPATH = "/etc/gobt/gobt.toml"
+
+	DEFAULTPROFILEPATH = "/red/potch/profile"
+	DEFAULTAGENTPATH   = "/red/potch/agent"
+)
+
+// Configuration file as written; see gobt.toml for every key
+type File struct {
+	Profile  ProfileSection  `toml:"profile"`
+	Adapter  AdapterSection  `toml:"adapter"`
+	Identity IdentitySection `toml:"identity"`
+	Devices  DevicesSection  `toml:"devices"`
+	Keymaps  KeymapsSection  `toml:"keymaps"`
+	Hosts    HostsSection    `toml:"hosts"`
+	Security SecuritySection `toml:"security"`
+	Logging  LoggingSection  `toml:"logging"`
+}
+
+type ProfileSection struct {
+	Path          string `toml:"path"`
+	AgentPath     string `toml:"agent_path"`
+	PSMCtrl       uint16 `toml:"psm_ctrl"`
+	PSMIntr       uint16 `toml:"psm_intr"`
+	ControlSocket string `toml:"control_socket"`
+	Metrics       string `toml:"metrics"`
+}
+
+type AdapterSection struct {
+	Name                     string `toml:"name"`
+	Alias                    string `toml:"alias"`
+	Powered                  bool   `toml:"powered"`
+	Pairable                 bool   `toml:"pairable"`
+	Discoverable             bool   `toml:"discoverable"`
+	DiscoverableTimeout      uint32 `toml:"discoverable_timeout"`
+	DiscoverableWhenUnpaired bool   `toml:"discoverable_when_unpaired"`
+	DiscoverableHotkey       string `toml:"discoverable_hotkey"`
+}
+
+type IdentitySection struct {
+	ServiceName    string `toml:"service_name"`
+	Description    string `toml:"description"`
+	Provider       string `toml:"provider"`
+	CountryCode    uint8  `toml:"country_code"`
+	Class          string `toml:"class"`
+	VendorIDSource string `toml:"vendor_id_source"`
+	VendorID       uint16 `toml:"vendor_id"`
+	ProductID      uint16 `toml:"product_id"`
+	Version        uint16 `toml:"version"`
+}
+
+type DevicesSection struct {
+	InputDir      string        `toml:"input_dir"`
+	Grab          bool          `toml:"grab"`
+	EscapeHotkey  string        `toml:"escape_hotkey"`
+	ReleaseHotkey string        `toml:"release_hotkey"`
+	VendorKeys    bool          `toml:"vendor_keys"`
+	MaxHold       time.Duration `toml:"max_hold"`
+	Rules         []RuleSection `toml:"rule"`
+}
+
+type RuleSection struct {
+	Name         string   `toml:"name"`
+	Phys         string   `toml:"phys"`
+	Uniq         string   `toml:"uniq"`
+	Bus          uint16   `toml:"bus"`
+	Vendor       uint16   `toml:"vendor"`
+	Product      uint16   `toml:"product"`
+	Capabilities []string `toml:"capabilities"`
+	Kind         string   `toml:"kind"`
+}
+
+type KeymapsSection struct {
+	Dir            string        `toml:"dir"`
+	TappingTerm    time.Duration `toml:"tapping_term"`
+	PermissiveHold bool          `toml:"permissive_hold"`
+	OneShotTimeout time.Duration `toml:"one_shot_timeout"`
+}
+
+type HostsSection struct {
+	Mode            string        `toml:"mode"`
+	ModeHotkey      string        `toml:"mode_hotkey"`
+	Hotkeys         []string      `toml:"hotkeys"`
+	NextTapKey      string        `toml:"next_tap_key"`
+	NextTaps        int           `toml:"next_taps"`
+	NextTapInterval time.Duration `toml:"next_tap_interval"`
+	Layout          string        `toml:"layout"`
+	Hosts           []HostSection `toml:"host"`
+}
+
+// Host slot; slots are numbered in file order
+type HostSection struct {
+	Address string `toml:"address"`
+	Keymap  string `toml:"keymap"`
+	Layout  string `toml:"layout"`
+}
+
+type SecuritySection struct {
+	AllowedHosts     []string      `toml:"allowed_hosts"`
+	InterruptTimeout time.Duration `toml:"interrupt_timeout"`
+	Agent            AgentSection  `toml:"agent"`
+}
+
+type AgentSection struct {
+	Capability          string        `toml:"capability"`
+	Confirm             string        `toml:"confirm"`
+	Authorize           string        `toml:"authorize"`
+	PasskeyFromKeyboard bool          `toml:"passkey_from_keyboard"`
+	KeyboardGlob        string        `toml:"keyboard_glob"`
+	PasskeyTimeout      time.Duration `toml:"passkey_timeout"`
+	Passkey             uint32        `toml:"passkey"`
+	PinCode             string        `toml:"pin_code"`
+}
+
+type LoggingSection struct {
+	Debug bool `toml:"debug"`
+	// Debug output is appended to this file; standard output when empty
+	File string `toml:"file"`
+}
+
+// Resolved configuration handed to the gobt packages
+type Settings struct {
+	ProfilePath   string
+	AgentPath     string
+	PSMCtrl       uint16
+	PSMIntr       uint16
+	ControlSocket string
+	MetricsAddr   string
+
+	Adapter  gobt.AdapterConfig
+	Identity gobt.Identity
+	Agent    gobt.AgentConfig
+	Security gobt.SecurityConfig
+	Switch   gobt.SwitchConfig
+	Grab     gobt.GrabConfig
+
+	InputDir   string
+	Rules      hid.DeviceRules
+	VendorKeys bool
+	MaxHold    time.Duration
+
+	KeymapDir string
+	// Layer settings; layers themselves come from the keymap files
+	Layers hid.LayerConfig
+
+	Debug   bool
+	LogFile string
+}
+
+// File holding the gobt defaults
+func Default() File {
+	adapter := gobt.DefaultAdapterConfig()
+	id := gobt.DefaultIdentity()
+	agent := gobt.DefaultAgentConfig()
+	security := gobt.DefaultSecurityConfig()
+	sw := gobt.DefaultSwitchConfig()
+	grab := gobt.DefaultGrabConfig()
+	layers := hid.DefaultLayerConfig()
+
+	f := File{
+		Profile: ProfileSection{
+			Path:          DEFAULTPROFILEPATH,
+			AgentPath:     DEFAULTAGENTPATH,
+			PSMCtrl:       bluetooth.PSMCTRL,
+			PSMIntr:       bluetooth.PSMINTR,
+			ControlSocket: control.DEFAULTSOCKET,
+		},
+		Adapter: AdapterSection{
+			Name:                     adapter.Name,
+			Alias:                    adapter.Alias,
+			Powered:                  adapter.Powered,
+			Pairable:                 adapter.Pairable,
+			Discoverable:             adapter.Discoverable,
+			DiscoverableTimeout:      adapter.DiscoverableTimeout,
+			DiscoverableWhenUnpaired: adapter.DiscoverableWhenUnpaired,
+			DiscoverableHotkey:       chordName(adapter.DiscoverableHotkey),
+		},
+		Identity: IdentitySection{
+			ServiceName:    id.ServiceName,
+			Description:    id.Description,
+			Provider:       id.Provider,
+			CountryCode:    id.CountryCode,
+			Class:          nameOf(classes, int(id.Class)),
+			VendorIDSource: nameOf(sources, int(id.VendorIDSource)),
+			VendorID:       id.VendorID,
+			ProductID:      id.ProductID,
+			Version:        id.Version,
+		},
+		Devices: DevicesSection{
+			InputDir:      gobt.INPUTDIR,
+			Grab:          grab.Enable,
+			EscapeHotkey:  chordName(grab.EscapeHotkey),
+			ReleaseHotkey: chordName(grab.ReleaseHotkey),
+			MaxHold:       gobt.DEFAULTMAXHOLD,
+		},
+		Keymaps: KeymapsSection{
+			Dir:            gobt.KEYMAPDIR,
+			TappingTerm:    layers.TappingTerm,
+			PermissiveHold: layers.PermissiveHold,
+			OneShotTimeout: layers.OneShotTimeout,
+		},
+		Hosts: HostsSection{
+			Mode:            sw.Mode.String(),
+			ModeHotkey:      chordName(sw.ModeHotkey),
+			NextTapKey:      hid.KeyName(sw.NextTapKey),
+			NextTaps:        sw.NextTaps,
+			NextTapInterval: sw.NextTapInterval,
+			Layout:          sw.Layout,
+		},
+		Security: SecuritySection{
+			InterruptTimeout: security.InterruptTimeout,
+			Agent: AgentSection{
+				Capability:          agent.Capability,
+				Confirm:             nameOf(policies, int(agent.Confirm)),
+				Authorize:           nameOf(policies, int(agent.Authorize)),
+				PasskeyFromKeyboard: agent.PasskeyFromKeyboard,
+				KeyboardGlob:        agent.KeyboardGlob,
+				PasskeyTimeout:      agent.PasskeyTimeout,
+				Passkey:             agent.Passkey,
+				PinCode:             agent.PinCode,
+			},
+		},
+	}
+	for _, c := range sw.Hotkeys {
+		f.Hosts.Hotkeys = append(f.Hosts.Hotkeys, chordName(c))
+	}
+	return f
+}
+
+// Reads and validates configuration file at path
+// A missing file gives the defaults when missingOK is set
+func Load(path string, missingOK bool) (*Settings, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && missingOK {
+		b, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return Parse(path, b)
+}
+
+// Parses and validates configuration; name is used in error messages
+func Parse(name string, data []byte) (*Settings, error) {
+	f := Default()
+	md, err := toml.Decode(string(data), &f)
+	if err != nil {
+		// e.g. "line 2 (last key "devices.max_hold"): invalid duration: "soon""
+		return nil, &ConfigError{File: name, Msg: strings.TrimPrefix(err.Error(), "toml: ")}
+	}
+
+	v := &validator{name: name}
+	for _, k := range md.Undecoded() {
+		// e.g. hosts.host.adress; array tables are not indexed
+		v.fail(k.String(), "unknown key")
+	}
+	s := f.settings(v)
+	if len(v.errs) > 0 {
+		return nil, v.errs
+	}
+	return s, nil
+}
+
+// Collects errors of fields
+type validator struct {
+	name string
+	errs ConfigErrors
+}
+
+func (v *validator) fail(field string, format string, args ...interface{}) {
+	v.errs = append(v.errs, &ConfigError{File: v.name, Field: field, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) chord(field, s string) hid.Chord {
+	if s == "" {
+		return nil
+	}
+	c, err := hid.ParseChord(s)
+	if err != nil {
+		v.fail(field, "invalid key chord %q", s)
+	}
+	return c
+}
+
+func (v *validator) addr(field, s string) bluetooth.Addr {
+	a, err := bluetooth.ParseAddr(s)
+	if err != nil {
+		v.fail(field, "invalid bluetooth address %q", s)
+	}
+	return a
+}
+
+func (v *validator) layout(field, s string) {
+	if _, ok := hid.LookupLayout(s); !ok {
+		v.fail(field, "unknown layout %q; one of %s", s, strings.Join(hid.LayoutNames(), ", "))
+	}
+}
+
+// Checks s is one of names and gives its value
+func (v *validator) oneOf(field, s string, names map[string]int) int {
+	if n, ok := names[s]; ok {
+		return n
+	}
+	var ns []string
+	for n := range names {
+		ns = append(ns, n)
+	}
+	sort.Strings(ns)
+	v.fail(field, "unknown value %q; one of %s", s, strings.Join(ns, ", "))
+	return 0
+}
+
+func (v *validator) positive(field string, d time.Duration) {
+	if d < 0 {
+		v.fail(field, "negative duration %v", d)
+	}
+}
+
+// Values of the keys taking names
+var classes = map[string]int{
+	"keyboard": int(gobt.CLASSKEYBOARD),
+	"mouse":    int(gobt.CLASSMOUSE),
+	"combo":    int(gobt.CLASSCOMBO),
+}
+
+var sources = map[string]int{
+	"bluetooth": gobt.VIDSOURCEBLUETOOTH,
+	"usb":       gobt.VIDSOURCEUSB,
+}
+
+var policies = map[string]int{
+	"accept": int(gobt.POLICYACCEPT),
+	"reject": int(gobt.POLICYREJECT),
+}
+
+var capabilities = map[string]int{
+	gobt.CAPDISPLAYONLY:     0,
+	gobt.CAPKEYBOARDONLY:    0,
+	gobt.CAPNOINPUTNOOUTPUT: 0,
+}
+
+var kinds = map[string]int{
+	hid.DEVNONE.String():     int(hid.DEVNONE),
+	hid.DEVKEYBOARD.String(): int(hid.DEVKEYBOARD),
+	hid.DEVMOUSE.String():    int(hid.DEVMOUSE),
+}
+
+var modes = map[string]int{
+	gobt.ROUTEACTIVE.String():    int(gobt.ROUTEACTIVE),
+	gobt.ROUTEBROADCAST.String(): int(gobt.ROUTEBROADCAST),
+}
+
+// Name of value n in names
+func nameOf(names map[string]int, n int) string {
+	for name, v := range names {
+		if v == n {
+			return name
+		}
+	}
+	return ""
+}
+
+// Validates file and converts it to settings; errors are collected in v
+func (f *File) settings(v *validator) *Settings {
+	s := &Settings{
+		ProfilePath:   f.Profile.Path,
+		AgentPath:     f.Profile.AgentPath,
+		PSMCtrl:       f.Profile.PSMCtrl,
+		PSMIntr:       f.Profile.PSMIntr,
+		ControlSocket: f.Profile.ControlSocket,
+		MetricsAddr:   f.Profile.Metrics,
+		InputDir:      f.Devices.InputDir,
+		VendorKeys:    f.Devices.VendorKeys,
+		MaxHold:       f.Devices.MaxHold,
+		KeymapDir:     f.Keymaps.Dir,
+		Debug:         f.Logging.Debug,
+		LogFile:       f.Logging.File,
+	}
+
+	for _, p := range []struct {
+		field, path string
+	}{{"profile.path", f.Profile.Path}, {"profile.agent_path", f.Profile.AgentPath}} {
+		if !validObjectPath(p.path) {
+			v.fail(p.field, "invalid D-Bus object path %q", p.path)
+		}
+	}
+	if f.Profile.PSMCtrl == f.Profile.PSMIntr {
+		v.fail("profile.psm_intr", "same PSM as profile.psm_ctrl")
+	}
+	for _, p := range []struct {
+		field string
+		psm   uint16
+	}{{"profile.psm_ctrl", f.Profile.PSMCtrl}, {"profile.psm_intr", f.Profile.PSMIntr}} {
+		// L2CAP PSMs are odd with the least significant bit of the upper byte cleared
+		if p.psm&0x0001 == 0 || p.psm&0x0100 != 0 {
+			v.fail(p.field, "invalid PSM 0x%04x", p.psm)
+		}
+	}
+
+	a := &f.Adapter
+	if !strings.HasPrefix(a.Name, "hci") {
+		v.fail("adapter.name", "adapter name %q is not like hci0", a.Name)
+	}
+	s.Adapter = gobt.AdapterConfig{
+		Name:                     a.Name,
+		Powered:                  a.Powered,
+		Discoverable:             a.Discoverable,
+		Pairable:                 a.Pairable,
+		DiscoverableTimeout:      a.DiscoverableTimeout,
+		Alias:                    a.Alias,
+		DiscoverableWhenUnpaired: a.DiscoverableWhenUnpaired,
+		DiscoverableHotkey:       v.chord("adapter.discoverable_hotkey", a.DiscoverableHotkey),
+	}
+
+	id := &f.Identity
+	s.Identity = gobt.Identity{
+		ServiceName:    id.ServiceName,
+		Description:    id.Description,
+		Provider:       id.Provider,
+		CountryCode:    id.CountryCode,
+		Class:          gobt.DeviceClass(v.oneOf("identity.class", id.Class, classes)),
+		VendorIDSource: uint16(v.oneOf("identity.vendor_id_source", id.VendorIDSource, sources)),
+		VendorID:       id.VendorID,
+		ProductID:      id.ProductID,
+		Version:        id.Version,
+	}
+	if id.CountryCode > 35 {
+		v.fail("identity.country_code", "country code %d is beyond 35", id.CountryCode)
+	}
+
+	ag := &f.Security.Agent
+	v.oneOf("security.agent.capability", ag.Capability, capabilities)
+	s.Agent = gobt.AgentConfig{
+		Capability:          ag.Capability,
+		Confirm:             gobt.AgentPolicy(v.oneOf("security.agent.confirm", ag.Confirm, policies)),
+		Authorize:           gobt.AgentPolicy(v.oneOf("security.agent.authorize", ag.Authorize, policies)),
+		PasskeyFromKeyboard: ag.PasskeyFromKeyboard,
+		KeyboardGlob:        ag.KeyboardGlob,
+		PasskeyTimeout:      ag.PasskeyTimeout,
+		Passkey:             ag.Passkey,
+		PinCode:             ag.PinCode,
+	}
+	if ag.Passkey > 999999 {
+		v.fail("security.agent.passkey", "passkey %d has more than 6 digits", ag.Passkey)
+	}
+	if n := len(ag.PinCode); n < 1 || n > 16 {
+		v.fail("security.agent.pin_code", "PIN code must be 1 to 16 characters")
+	}
+	v.positive("security.agent.passkey_timeout", ag.PasskeyTimeout)
+
+	s.Security = gobt.SecurityConfig{InterruptTimeout: f.Security.InterruptTimeout}
+	for i, h := range f.Security.AllowedHosts {
+		s.Security.AllowedHosts = append(s.Security.AllowedHosts, v.addr(fmt.Sprintf("security.allowed_hosts[%d]", i), h))
+	}
+	if f.Security.InterruptTimeout <= 0 {
+		v.fail("security.interrupt_timeout", "must be positive")
+	}
+
+	d := &f.Devices
+	s.Grab = gobt.GrabConfig{
+		Enable:        d.Grab,
+		EscapeHotkey:  v.chord("devices.escape_hotkey", d.EscapeHotkey),
+		ReleaseHotkey: v.chord("devices.release_hotkey", d.ReleaseHotkey),
+	}
+	v.positive("devices.max_hold", d.MaxHold)
+	for i, r := range d.Rules {
+		field := fmt.Sprintf("devices.rule[%d]", i)
+		rule := hid.DeviceRule{
+			Name:         r.Name,
+			Phys:         r.Phys,
+			Uniq:         r.Uniq,
+			Bus:          r.Bus,
+			Vendor:       r.Vendor,
+			Product:      r.Product,
+			Capabilities: r.Capabilities,
+			Kind:         hid.DeviceKind(v.oneOf(field+".kind", r.Kind, kinds)),
+		}
+		if err := rule.Validate(); err != nil {
+			v.fail(field+".capabilities", "%v", err)
+		}
+		s.Rules = append(s.Rules, rule)
+	}
+
+	k := &f.Keymaps
+	s.Layers = hid.DefaultLayerConfig()
+	s.Layers.TappingTerm = k.TappingTerm
+	s.Layers.PermissiveHold = k.PermissiveHold
+	s.Layers.OneShotTimeout = k.OneShotTimeout
+	v.positive("keymaps.tapping_term", k.TappingTerm)
+	v.positive("keymaps.one_shot_timeout", k.OneShotTimeout)
+
+	h := &f.Hosts
+	s.Switch = gobt.SwitchConfig{
+		Mode:            gobt.RouteMode(v.oneOf("hosts.mode", h.Mode, modes)),
+		ModeHotkey:      v.chord("hosts.mode_hotkey", h.ModeHotkey),
+		NextTaps:        h.NextTaps,
+		NextTapInterval: h.NextTapInterval,
+		Layout:          h.Layout,
+		Keymaps:         make(map[bluetooth.Addr]string),
+		Layouts:         make(map[bluetooth.Addr]string),
+	}
+	for i, c := range h.Hotkeys {
+		s.Switch.Hotkeys = append(s.Switch.Hotkeys, v.chord(fmt.Sprintf("hosts.hotkeys[%d]", i), c))
+	}
+	if h.NextTapKey != "" {
+		code, ok := hid.KeyCode(h.NextTapKey)
+		if !ok {
+			v.fail("hosts.next_tap_key", "unknown key %q", h.NextTapKey)
+		}
+		s.Switch.NextTapKey = code
+	}
+	if h.NextTaps < 0 {
+		v.fail("hosts.next_taps", "negative tap count %d", h.NextTaps)
+	}
+	v.layout("hosts.layout", h.Layout)
+
+	seen := make(map[bluetooth.Addr]int)
+	for i, host := range h.Hosts {
+		field := fmt.Sprintf("hosts.host[%d]", i)
+		addr := v.addr(field+".address", host.Address)
+		if j, ok := seen[addr]; ok && host.Address != "" {
+			v.fail(field+".address", "same host as hosts.host[%d]", j)
+		}
+		seen[addr] = i
+		s.Switch.Hosts = append(s.Switch.Hosts, addr)
+		if host.Keymap != "" {
+			s.Switch.Keymaps[addr] = host.Keymap
+		}
+		if host.Layout != "" {
+			v.layout(field+".layout", host.Layout)
+			s.Switch.Layouts[addr] = host.Layout
+		}
+	}
+	return s
+}
+
+// D-Bus object path such as /red/potch/profile
+func validObjectPath(p string) bool {
+	if p == "/" {
+		return true
+	}
+	if !strings.HasPrefix(p, "/") || strings.HasSuffix(p, "/") {
+		return false
+	}
+	for _, el := range strings.Split(p[1:], "/") {
+		if el == "" {
+			return false
+		}
+		for _, r := range el {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func chordName(c hid.Chord) string {
+	var ns []string
+	for _, code := range c {
+		ns = append(ns, hid.KeyName(code))
+	}
+	return strings.Join(ns, "+")
+}
+
+// Error of a configuration field, or of the file when Field is empty
+type ConfigError struct {
+	File  string
+	Field string
+	Msg   string
+}
+
+func (e *ConfigError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("%s: %s: %s", e.File, e.Field, e.Msg)
+	}
+	return fmt.Sprintf("%s: %s", e.File, e.Msg)
+}
+
+// Every error found in a file
+type ConfigErrors []*ConfigError
+
+func (es ConfigErrors) Error() string {
+	var ss []string
+	for _, e := range es {
+		ss = append(ss, e.Error())
+	}
+	return strings.Join(ss, "\n")
+}
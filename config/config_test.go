@@ -0,0 +1,147 @@
+package config
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/potch8228/gobt"
+	"github.com/potch8228/gobt/bluetooth"
+	"github.com/potch8228/gobt/hid"
+)
+
+// The example file spells out the defaults
+func TestExampleIsDefault(t *testing.T) {
+	b, err := ioutil.ReadFile("gobt.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	example, err := Parse("gobt.toml", b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	empty, err := Parse("empty.toml", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(example, empty) {
+		t.Errorf("example differs from defaults\n%+v\n%+v", example, empty)
+	}
+	if empty.Identity != gobt.DefaultIdentity() || empty.PSMCtrl != bluetooth.PSMCTRL {
+		t.Errorf("defaults differ from gobt: %+v", empty)
+	}
+}
+
+func TestParse(t *testing.T) {
+	st, err := Parse("gobt.toml", []byte(`
+[profile]
+psm_intr = 0x1013
+
+[identity]
+class = "combo"
+
+[devices]
+max_hold = "30s"
+
+[[devices.rule]]
+name = "Yubico*"
+kind = "none"
+
+[hosts]
+mode = "broadcast"
+hotkeys = ["F1"]
+
+[[hosts.host]]
+address = "aa:bb:cc:dd:ee:ff"
+keymap = "mac"
+
+[[hosts.host]]
+address = "11:22:33:44:55:66"
+layout = "de"
+
+[security]
+allowed_hosts = ["AA:BB:CC:DD:EE:FF"]
+
+[logging]
+debug = true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, _ := bluetooth.ParseAddr("AA:BB:CC:DD:EE:FF")
+	b, _ := bluetooth.ParseAddr("11:22:33:44:55:66")
+	if st.PSMIntr != 0x1013 || st.PSMCtrl != bluetooth.PSMCTRL {
+		t.Errorf("PSMs %#x %#x", st.PSMCtrl, st.PSMIntr)
+	}
+	if st.Identity.Class != gobt.CLASSCOMBO || st.MaxHold != 30*time.Second || !st.Debug {
+		t.Errorf("settings %+v", st)
+	}
+	if len(st.Rules) != 1 || st.Rules[0].Kind != hid.DEVNONE {
+		t.Errorf("rules %+v", st.Rules)
+	}
+	sw := st.Switch
+	if sw.Mode != gobt.ROUTEBROADCAST || len(sw.Hotkeys) != 1 || !reflect.DeepEqual(sw.Hosts, []bluetooth.Addr{a, b}) {
+		t.Errorf("switch %+v", sw)
+	}
+	if sw.Keymaps[a] != "mac" || sw.Layouts[b] != "de" || sw.Layout != "us" {
+		t.Errorf("host keymaps %v layouts %v", sw.Keymaps, sw.Layouts)
+	}
+	if !reflect.DeepEqual(st.Security.AllowedHosts, []bluetooth.Addr{a}) {
+		t.Errorf("allowed hosts %v", st.Security.AllowedHosts)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, c := range []struct {
+		data string
+		errs []string
+	}{
+		{"[profile]\npath = /red\n", []string{"gobt.toml: line 2 (last key \"profile.path\"): expected value"}},
+		{"[devices]\nmax_hold = \"soon\"\n", []string{`gobt.toml: line 2 (last key "devices.max_hold"): invalid duration`}},
+		{"[hosts]\nmode = \"all\"\n", []string{`gobt.toml: hosts.mode: unknown value "all"; one of active, broadcast`}},
+		{"[adapter]\ndiscoverable_timout = 0\n", []string{"gobt.toml: adapter.discoverable_timout: unknown key"}},
+		{"[profile]\npath = \"red/potch\"\npsm_ctrl = 0x13\n", []string{
+			`gobt.toml: profile.path: invalid D-Bus object path "red/potch"`,
+			"gobt.toml: profile.psm_intr: same PSM as profile.psm_ctrl",
+		}},
+		{"[profile]\npsm_ctrl = 0x12\n", []string{"gobt.toml: profile.psm_ctrl: invalid PSM 0x0012"}},
+		{"[[hosts.host]]\naddress = \"AA:BB\"\n", []string{`gobt.toml: hosts.host[0].address: invalid bluetooth address "AA:BB"`}},
+		{"[[hosts.host]]\naddress = \"AA:BB:CC:DD:EE:FF\"\n[[hosts.host]]\naddress = \"aa:bb:cc:dd:ee:ff\"\n",
+			[]string{"gobt.toml: hosts.host[1].address: same host as hosts.host[0]"}},
+		{"[[hosts.host]]\naddress = \"AA:BB:CC:DD:EE:FF\"\nlayout = \"xx\"\n", []string{`gobt.toml: hosts.host[0].layout: unknown layout "xx"`}},
+		{"[hosts]\nhotkeys = [\"KEY_A\", \"KEY_NOPE\"]\n", []string{`gobt.toml: hosts.hotkeys[1]: invalid key chord "KEY_NOPE"`}},
+		{"[[devices.rule]]\nkind = \"tablet\"\n", []string{`gobt.toml: devices.rule[0].kind: unknown value "tablet"`}},
+		{"[security.agent]\ncapability = \"DisplayYesNo\"\npin_code = \"\"\n", []string{
+			`gobt.toml: security.agent.capability: unknown value "DisplayYesNo"`,
+			"gobt.toml: security.agent.pin_code: PIN code must be 1 to 16 characters",
+		}},
+	} {
+		_, err := Parse("gobt.toml", []byte(c.data))
+		if err == nil {
+			t.Errorf("%q: no error", c.data)
+			continue
+		}
+		lines := strings.Split(err.Error(), "\n")
+		if len(lines) != len(c.errs) {
+			t.Errorf("%q: errors %q", c.data, lines)
+			continue
+		}
+		for i, want := range c.errs {
+			if !strings.HasPrefix(lines[i], want) {
+				t.Errorf("%q: error %q; want %q", c.data, lines[i], want)
+			}
+		}
+	}
+}
+
+func TestLoadMissing(t *testing.T) {
+	if _, err := Load("/nonexistent/gobt.toml", true); err != nil {
+		t.Error("missing default file:", err)
+	}
+	if _, err := Load("/nonexistent/gobt.toml", false); err == nil {
+		t.Error("missing explicit file loaded")
+	}
+}
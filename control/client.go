@@ -0,0 +1,85 @@
+package control
+
+import (
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+)
+
+// Connection to the control socket of a running gobt
+type Client struct {
+	c *rpc.Client
+}
+
+func Dial(path string) (*Client, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{c: jsonrpc.NewClient(conn)}, nil
+}
+
+func (c *Client) call(method string, args, reply interface{}) error {
+	return c.c.Call(SERVICE+"."+method, args, reply)
+}
+
+func (c *Client) Status() (Status, error) {
+	var st Status
+	err := c.call("Status", Empty{}, &st)
+	return st, err
+}
+
+func (c *Client) Hosts() ([]Host, error) {
+	var hosts []Host
+	err := c.call("Hosts", Empty{}, &hosts)
+	return hosts, err
+}
+
+// Makes host(address or paired name) receive input
+func (c *Client) Switch(host string) error {
+	return c.call("Switch", HostArgs{Host: host}, &Empty{})
+}
+
+func (c *Client) Disconnect(host string) error {
+	return c.call("Disconnect", HostArgs{Host: host}, &Empty{})
+}
+
+// Makes the adapter discoverable for pairing
+func (c *Client) Pair() error {
+	return c.call("Pair", Empty{}, &Empty{})
+}
+
+// Removes pairing of host(address or paired name)
+func (c *Client) Unpair(host string) error {
+	return c.call("Unpair", HostArgs{Host: host}, &Empty{})
+}
+
+func (c *Client) Devices() ([]Device, error) {
+	var devs []Device
+	err := c.call("Devices", Empty{}, &devs)
+	return devs, err
+}
+
+func (c *Client) SetDevice(path string, enabled bool) error {
+	return c.call("SetDevice", DeviceArgs{Path: path, Enabled: enabled}, &Empty{})
+}
+
+func (c *Client) ToggleGrab() (GrabReply, error) {
+	var r GrabReply
+	err := c.call("ToggleGrab", Empty{}, &r)
+	return r, err
+}
+
+// Types text on the active host; returns when typed
+func (c *Client) Type(text string) error {
+	return c.call("Type", TypeArgs{Text: text}, &Empty{})
+}
+
+// Taps chord such as "KEY_LEFTCTRL+KEY_C" on the active host
+func (c *Client) Chord(chord string) error {
+	return c.call("Chord", ChordArgs{Chord: chord}, &Empty{})
+}
+
+func (c *Client) Close() error {
+	return c.c.Close()
+}
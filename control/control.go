@@ -0,0 +1,322 @@
+// Package control operates a running gobt over a Unix domain socket
+// Requests are JSON-RPC 1.0 calls of the "Gobt" service; e.g. {"method":"Gobt.Hosts","params":[{}],"id":1}
+package control
+
+import (
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/potch8228/gobt"
+	"github.com/potch8228/gobt/bluetooth"
+	"github.com/potch8228/gobt/hid"
+	btlog "github.com/potch8228/gobt/log"
+)
+
+const (
+	DEFAULTSOCKET = "/run/gobt/control.sock"
+	SERVICE       = "Gobt"
+)
+
+// Operations of the running profile; implemented by *gobt.HidProfile
+type Profile interface {
+	Hosts() []gobt.HostStatus
+	SwitchTo(addr bluetooth.Addr) error
+	Disconnect(addr bluetooth.Addr) error
+	Type(text string) error
+	SendChord(c hid.Chord) error
+	Devices() *gobt.Devices
+}
+
+// Pairing of the adapter; implemented by *gobt.Adapter
+type Adapter interface {
+	PairedDevices() ([]gobt.PairedDevice, error)
+	SetDiscoverable(on bool) error
+	RemoveDevice(addr bluetooth.Addr) error
+}
+
+type Server struct {
+	ln   net.Listener
+	path string
+	rpc  *rpc.Server
+}
+
+// Serves profile on Unix socket path; adapter may be nil
+// The socket is accessible by the owner and group only
+func Listen(path string, profile Profile, adapter Adapter) (*Server, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	// socket left behind by a daemon which did not stop cleanly
+	if c, err := net.Dial("unix", path); err == nil {
+		c.Close()
+		return nil, &ControlError{msg: "another gobt is serving " + path}
+	}
+	os.Remove(path)
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0660); err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	s := &Server{ln: ln, path: path, rpc: rpc.NewServer()}
+	if err := s.rpc.RegisterName(SERVICE, &Service{profile: profile, adapter: adapter}); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	go s.serve()
+	return s, nil
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			btlog.Debug("Control: accept loop quitting", err)
+			return
+		}
+		go s.rpc.ServeCodec(jsonrpc.NewServerCodec(conn))
+	}
+}
+
+// Stops accepting clients and removes the socket
+func (s *Server) Close() error {
+	err := s.ln.Close()
+	os.Remove(s.path)
+	return err
+}
+
+// Summary of the running gobt
+type Status struct {
+	// Address of the host receiving input; empty when none is connected
+	Active    string
+	Connected int
+	Paired    int
+	Keyboards int
+	Mice      int
+	// Input is kept on the local machine
+	Local bool
+	// Devices are grabbed and input goes to hosts only
+	Grabbing bool
+}
+
+// Host paired with the adapter or connected
+type Host struct {
+	Addr      string
+	Name      string
+	Paired    bool
+	Connected bool
+	Active    bool
+	// "report" or "boot"; empty while not connected
+	Protocol   string
+	QueueDepth int
+}
+
+type Device struct {
+	Path    string
+	Name    string
+	Kind    string
+	Enabled bool
+}
+
+type Empty struct{}
+
+// Host by address or paired name
+type HostArgs struct {
+	Host string
+}
+
+type DeviceArgs struct {
+	Path    string
+	Enabled bool
+}
+
+type GrabReply struct {
+	// Input is kept on the local machine
+	Local bool
+	// Devices are grabbed and input goes to hosts only
+	Grabbing bool
+}
+
+type TypeArgs struct {
+	Text string
+}
+
+// Chord such as "KEY_LEFTCTRL+KEY_C"
+type ChordArgs struct {
+	Chord string
+}
+
+// Methods called by clients as "Gobt.<method>"
+type Service struct {
+	profile Profile
+	adapter Adapter
+}
+
+// Paired and connected hosts; paired hosts first, in address order
+func (s *Service) Hosts(_ Empty, reply *[]Host) error {
+	hosts, err := s.hosts()
+	*reply = hosts
+	return err
+}
+
+func (s *Service) hosts() ([]Host, error) {
+	var hosts []Host
+	index := make(map[string]int)
+	if s.adapter != nil {
+		paired, err := s.adapter.PairedDevices()
+		if err != nil {
+			return nil, err
+		}
+		for _, pd := range paired {
+			index[pd.Addr.String()] = len(hosts)
+			hosts = append(hosts, Host{Addr: pd.Addr.String(), Name: pd.Name, Paired: true})
+		}
+	}
+
+	for _, st := range s.profile.Hosts() {
+		i, ok := index[st.Addr.String()]
+		if !ok {
+			i = len(hosts)
+			hosts = append(hosts, Host{Addr: st.Addr.String()})
+		}
+		h := &hosts[i]
+		h.Connected, h.Active, h.QueueDepth = true, st.Active, st.QueueDepth
+		h.Protocol = "report"
+		if st.Protocol == gobt.HIDPPROTOCOLBOOT {
+			h.Protocol = "boot"
+		}
+	}
+	return hosts, nil
+}
+
+// Resolves host by address, or by paired name ignoring case
+func (s *Service) resolve(host string) (bluetooth.Addr, error) {
+	if addr, err := bluetooth.ParseAddr(host); err == nil {
+		return addr, nil
+	}
+	hosts, err := s.hosts()
+	if err != nil {
+		return bluetooth.ADDRANY, err
+	}
+	for _, h := range hosts {
+		if h.Name != "" && strings.EqualFold(h.Name, host) {
+			return bluetooth.ParseAddr(h.Addr)
+		}
+	}
+	return bluetooth.ADDRANY, &ControlError{msg: "unknown host " + host}
+}
+
+func (s *Service) Status(_ Empty, reply *Status) error {
+	hosts, err := s.hosts()
+	if err != nil {
+		return err
+	}
+	st := Status{}
+	for _, h := range hosts {
+		if h.Connected {
+			st.Connected++
+		}
+		if h.Paired {
+			st.Paired++
+		}
+		if h.Active {
+			st.Active = h.Addr
+		}
+	}
+	d := s.profile.Devices()
+	st.Keyboards, st.Mice = d.Count()
+	st.Local, st.Grabbing = d.Local(), d.Grabbing()
+	*reply = st
+	return nil
+}
+
+// Makes connected host receive input
+func (s *Service) Switch(args HostArgs, _ *Empty) error {
+	addr, err := s.resolve(args.Host)
+	if err != nil {
+		return err
+	}
+	return s.profile.SwitchTo(addr)
+}
+
+func (s *Service) Disconnect(args HostArgs, _ *Empty) error {
+	addr, err := s.resolve(args.Host)
+	if err != nil {
+		return err
+	}
+	return s.profile.Disconnect(addr)
+}
+
+// Makes the adapter discoverable so that a new host can pair; it stops after the discoverable timeout
+func (s *Service) Pair(_ Empty, _ *Empty) error {
+	if s.adapter == nil {
+		return &ControlError{msg: "no adapter"}
+	}
+	return s.adapter.SetDiscoverable(true)
+}
+
+// Disconnects host and removes its pairing
+func (s *Service) Unpair(args HostArgs, _ *Empty) error {
+	if s.adapter == nil {
+		return &ControlError{msg: "no adapter"}
+	}
+	addr, err := s.resolve(args.Host)
+	if err != nil {
+		return err
+	}
+	// host may be paired without being connected
+	s.profile.Disconnect(addr)
+	return s.adapter.RemoveDevice(addr)
+}
+
+// Local input devices including disabled ones
+func (s *Service) Devices(_ Empty, reply *[]Device) error {
+	*reply = nil
+	for _, st := range s.profile.Devices().List() {
+		*reply = append(*reply, Device{Path: st.Path, Name: st.Name, Kind: st.Kind.String(), Enabled: st.Enabled})
+	}
+	return nil
+}
+
+// Enables or disables forwarding of a device
+func (s *Service) SetDevice(args DeviceArgs, _ *Empty) error {
+	return s.profile.Devices().SetEnabled(args.Path, args.Enabled)
+}
+
+// Switches between forwarding input to hosts and keeping it local; same as the escape hotkey
+func (s *Service) ToggleGrab(_ Empty, reply *GrabReply) error {
+	d := s.profile.Devices()
+	d.SetLocal(!d.Local())
+	*reply = GrabReply{Local: d.Local(), Grabbing: d.Grabbing()}
+	return nil
+}
+
+// Types text on the active host; returns when typed
+func (s *Service) Type(args TypeArgs, _ *Empty) error {
+	return s.profile.Type(args.Text)
+}
+
+// Taps chord on the active host
+func (s *Service) Chord(args ChordArgs, _ *Empty) error {
+	c, err := hid.ParseChord(args.Chord)
+	if err != nil {
+		return err
+	}
+	return s.profile.SendChord(c)
+}
+
+type ControlError struct {
+	msg string
+}
+
+func (e *ControlError) Error() string {
+	return "ControlError: " + e.msg
+}
@@ -0,0 +1,150 @@
+package control
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/potch8228/gobt"
+	"github.com/potch8228/gobt/bluetooth"
+	"github.com/potch8228/gobt/hid"
+)
+
+type fakeProfile struct {
+	hosts   []gobt.HostStatus
+	devices *gobt.Devices
+	active  bluetooth.Addr
+	typed   string
+	chord   hid.Chord
+}
+
+func (p *fakeProfile) Hosts() []gobt.HostStatus {
+	return p.hosts
+}
+
+func (p *fakeProfile) SwitchTo(addr bluetooth.Addr) error {
+	p.active = addr
+	return nil
+}
+
+func (p *fakeProfile) Disconnect(addr bluetooth.Addr) error {
+	return nil
+}
+
+func (p *fakeProfile) Type(text string) error {
+	p.typed = text
+	return nil
+}
+
+func (p *fakeProfile) SendChord(c hid.Chord) error {
+	p.chord = c
+	return nil
+}
+
+func (p *fakeProfile) Devices() *gobt.Devices {
+	return p.devices
+}
+
+type discardSink struct{}
+
+func (discardSink) Send(hid.Report) error {
+	return nil
+}
+
+type fakeAdapter struct {
+	paired       []gobt.PairedDevice
+	discoverable bool
+	removed      []bluetooth.Addr
+}
+
+func (a *fakeAdapter) PairedDevices() ([]gobt.PairedDevice, error) {
+	return a.paired, nil
+}
+
+func (a *fakeAdapter) SetDiscoverable(on bool) error {
+	a.discoverable = on
+	return nil
+}
+
+func (a *fakeAdapter) RemoveDevice(addr bluetooth.Addr) error {
+	a.removed = append(a.removed, addr)
+	return nil
+}
+
+func mustAddr(t *testing.T, s string) bluetooth.Addr {
+	a, err := bluetooth.ParseAddr(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestControl(t *testing.T) {
+	phone, laptop := mustAddr(t, "00:11:22:33:44:55"), mustAddr(t, "66:77:88:99:AA:BB")
+	p := &fakeProfile{
+		hosts:   []gobt.HostStatus{{Addr: laptop, Active: true, Protocol: gobt.HIDPPROTOCOLBOOT}},
+		devices: gobt.NewDevices(discardSink{}, hid.KeyboardConfig{}),
+	}
+	path := filepath.Join(t.TempDir(), "control.sock")
+	a := &fakeAdapter{paired: []gobt.PairedDevice{{Addr: phone, Name: "Phone"}}}
+	s, err := Listen(path, p, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	c, err := Dial(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	hosts, err := c.Hosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Host{
+		{Addr: phone.String(), Name: "Phone", Paired: true},
+		{Addr: laptop.String(), Connected: true, Active: true, Protocol: "boot"},
+	}
+	if len(hosts) != len(want) {
+		t.Fatalf("hosts = %+v; want %+v", hosts, want)
+	}
+	for i := range want {
+		if hosts[i] != want[i] {
+			t.Errorf("host %d = %+v; want %+v", i, hosts[i], want[i])
+		}
+	}
+
+	if err := c.Switch("phone"); err != nil || p.active != phone {
+		t.Errorf("switching by name: %v, active %v", err, p.active)
+	}
+	if err := c.Switch("tablet"); err == nil {
+		t.Error("switched to unknown host")
+	}
+	if err := c.Type("hello"); err != nil || p.typed != "hello" {
+		t.Errorf("typing: %v, typed %q", err, p.typed)
+	}
+	if err := c.Chord("KEY_LEFTCTRL+KEY_C"); err != nil || len(p.chord) != 2 {
+		t.Errorf("chord: %v, sent %v", err, p.chord)
+	}
+	if err := c.SetDevice("/dev/input/event9", false); err == nil {
+		t.Error("disabled unknown device")
+	}
+	if g, err := c.ToggleGrab(); err != nil || !g.Local {
+		t.Errorf("toggling grab: %v, %+v", err, g)
+	}
+
+	if st, err := c.Status(); err != nil || st.Active != laptop.String() || st.Connected != 1 || st.Paired != 1 {
+		t.Errorf("status: %v, %+v", err, st)
+	}
+	if err := c.Pair(); err != nil || !a.discoverable {
+		t.Errorf("pairing: %v, discoverable %v", err, a.discoverable)
+	}
+	if err := c.Unpair("Phone"); err != nil || len(a.removed) != 1 || a.removed[0] != phone {
+		t.Errorf("unpairing: %v, removed %v", err, a.removed)
+	}
+
+	if _, err := Listen(path, p, nil); err == nil {
+		t.Error("second server listened on the same socket")
+	}
+}
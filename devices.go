@@ -0,0 +1,439 @@
+package gobt
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gvalkov/golang-evdev"
+	"github.com/potch8228/gobt/hid"
+	btlog "github.com/potch8228/gobt/log"
+)
+
+const (
+	INPUTDIR  = "/dev/input"
+	KEYMAPDIR = "/etc/gobt/keymaps"
+
+	// Keys held this long without any key event are released(see hid.KeyboardConfig.MaxHold)
+	DEFAULTMAXHOLD = 2 * time.Minute
+)
+
+// Local input devices forwarded to the sink; shared by every connected host
+// Devices are added and removed as they are plugged and unplugged
+type Devices struct {
+	mu     sync.Mutex
+	sink   hid.Sink
+	kbdcfg hid.KeyboardConfig
+	// Merges reports of every device; each device sends to its own source
+	agg *hid.Aggregator
+
+	kbds  map[string]*hid.Keyboard
+	mses  map[string]*hid.Mouse
+	rules hid.DeviceRules
+	// Devices kept local by SetEnabled; not opened until enabled again
+	disabled map[string]DeviceStatus
+
+	grab      GrabConfig
+	connected bool
+	// Set by the escape hotkey; input stays on the local machine
+	local int32
+
+	// Watched for evdev nodes; INPUTDIR unless set
+	dir string
+	mon *hid.Monitor
+}
+
+type GrabConfig struct {
+	// Grabs devices exclusively while any host is connected
+	Enable bool
+	// Toggles between forwarding(grabbed) and local use(released)
+	EscapeHotkey hid.Chord
+	// Releases every key and button on hosts; recovers keys stuck down
+	ReleaseHotkey hid.Chord
+}
+
+func DefaultGrabConfig() GrabConfig {
+	return GrabConfig{
+		Enable:        true,
+		EscapeHotkey:  hid.Chord{evdev.KEY_LEFTCTRL, evdev.KEY_LEFTALT, evdev.KEY_G},
+		ReleaseHotkey: hid.Chord{evdev.KEY_LEFTCTRL, evdev.KEY_LEFTALT, evdev.KEY_ESC},
+	}
+}
+
+func NewDevices(sink hid.Sink, kbdcfg hid.KeyboardConfig) *Devices {
+	d := &Devices{
+		sink:   sink,
+		kbdcfg: kbdcfg,
+		kbds:   make(map[string]*hid.Keyboard),
+		mses:   make(map[string]*hid.Mouse),
+
+		disabled: make(map[string]DeviceStatus),
+		dir:      INPUTDIR,
+	}
+	d.agg = hid.NewAggregator(d)
+	return d
+}
+
+// Keyboards and mice attached
+func (d *Devices) Count() (kbds, mses int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.kbds), len(d.mses)
+}
+
+// Forwards device reports unless input is kept local by the escape hotkey
+func (d *Devices) Send(rep hid.Report) error {
+	if atomic.LoadInt32(&d.local) != 0 {
+		return nil
+	}
+	return d.sink.Send(rep)
+}
+
+// Adds input merged with local devices; e.g. macros, so that their reports keep keys held on keyboards
+func (d *Devices) Source() *hid.AggregatorSource {
+	return d.agg.Source()
+}
+
+// Applies grab configuration and registers the escape hotkey
+func (d *Devices) ConfigureGrab(cfg GrabConfig) {
+	d.mu.Lock()
+	d.grab = cfg
+	d.applyGrabLocked()
+	d.mu.Unlock()
+
+	if len(cfg.EscapeHotkey) > 0 {
+		d.kbdcfg.Hotkeys.Register(cfg.EscapeHotkey, d.toggleLocal)
+	}
+	if len(cfg.ReleaseHotkey) > 0 {
+		d.kbdcfg.Hotkeys.Register(cfg.ReleaseHotkey, d.ReleaseAll)
+	}
+}
+
+// Releases every key and button on hosts; keyboards forget keys held so far
+func (d *Devices) ReleaseAll() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, kbd := range d.kbds {
+		kbd.ReleaseAll()
+	}
+	d.agg.Release()
+	btlog.Debug("Devices: released every key")
+}
+
+// Tells whether any host is connected; devices are grabbed only while connected
+func (d *Devices) SetConnected(connected bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.connected == connected {
+		return
+	}
+	d.connected = connected
+	if !connected {
+		atomic.StoreInt32(&d.local, 0)
+	}
+	d.applyGrabLocked()
+}
+
+func (d *Devices) toggleLocal() {
+	d.SetLocal(!d.Local())
+}
+
+// Keeps input on the local machine(true) or forwards it to hosts(false); same as the escape hotkey
+func (d *Devices) SetLocal(local bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if local == (atomic.LoadInt32(&d.local) != 0) {
+		return
+	}
+
+	if local {
+		for _, rep := range hid.ReleaseReports() {
+			d.sink.Send(rep)
+		}
+		atomic.StoreInt32(&d.local, 1)
+		btlog.Debug("Devices: keeping input local")
+	} else {
+		atomic.StoreInt32(&d.local, 0)
+		btlog.Debug("Devices: forwarding input to hosts")
+	}
+	d.applyGrabLocked()
+}
+
+// Reports whether input is kept on the local machine
+func (d *Devices) Local() bool {
+	return atomic.LoadInt32(&d.local) != 0
+}
+
+// Reports whether devices are grabbed; i.e. input goes to hosts only
+func (d *Devices) Grabbing() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.grabbingLocked()
+}
+
+func (d *Devices) grabbingLocked() bool {
+	return d.grab.Enable && d.connected && atomic.LoadInt32(&d.local) == 0
+}
+
+func (d *Devices) applyGrabLocked() {
+	grab := d.grabbingLocked()
+	for p, kbd := range d.kbds {
+		if err := kbd.SetGrab(grab); err != nil {
+			btlog.Debug("Devices: failure on grabbing keyboard", p, grab, err)
+		}
+	}
+	for p, mse := range d.mses {
+		if err := mse.SetGrab(grab); err != nil {
+			btlog.Debug("Devices: failure on grabbing mouse", p, grab, err)
+		}
+	}
+}
+
+// Opens present keyboards and mice and starts watching hotplug
+func (d *Devices) Start() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.mon != nil {
+		return nil
+	}
+
+	mon, err := hid.NewMonitor(d.dir)
+	if err != nil {
+		return err
+	}
+	d.mon = mon
+
+	go d.watch(mon)
+	return nil
+}
+
+// Sets directory watched for input devices; applied on the next Start
+func (d *Devices) SetInputDir(dir string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dir = dir
+}
+
+// Replaces layer configuration; nil disables layers. Applied to keyboards added afterwards
+func (d *Devices) SetLayers(cfg *hid.LayerConfig) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.kbdcfg.Layers = cfg
+}
+
+// Forwards keys without HID usage as vendor usages; applied to keyboards added afterwards
+func (d *Devices) SetVendorKeys(enable bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.kbdcfg.VendorKeys = enable
+}
+
+// Sets max hold time of keys; zero disables it. Applied to keyboards added afterwards
+func (d *Devices) SetMaxHold(max time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.kbdcfg.MaxHold = max
+}
+
+// Replaces device selection rules; applied to devices added afterwards
+func (d *Devices) SetRules(rules hid.DeviceRules) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.rules = rules
+}
+
+// Local input device as listed by List
+type DeviceStatus struct {
+	Path    string
+	Name    string
+	Kind    hid.DeviceKind
+	Enabled bool
+}
+
+// Attached devices including disabled ones, ordered by path
+func (d *Devices) List() []DeviceStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var ds []DeviceStatus
+	for p, kbd := range d.kbds {
+		ds = append(ds, DeviceStatus{Path: p, Name: kbd.Name(), Kind: hid.DEVKEYBOARD, Enabled: true})
+	}
+	for p, mse := range d.mses {
+		ds = append(ds, DeviceStatus{Path: p, Name: mse.Name(), Kind: hid.DEVMOUSE, Enabled: true})
+	}
+	for _, st := range d.disabled {
+		ds = append(ds, st)
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i].Path < ds[j].Path })
+	return ds
+}
+
+// Forwards device at path(true), or closes it and leaves it to the local machine(false)
+// Keys held on the device are released on hosts
+func (d *Devices) SetEnabled(path string, enabled bool) error {
+	d.mu.Lock()
+	if enabled {
+		_, ok := d.disabled[path]
+		delete(d.disabled, path)
+		d.mu.Unlock()
+		if !ok {
+			return nil
+		}
+		d.add(path)
+		return nil
+	}
+	defer d.mu.Unlock()
+
+	if kbd, ok := d.kbds[path]; ok {
+		d.disabled[path] = DeviceStatus{Path: path, Name: kbd.Name(), Kind: hid.DEVKEYBOARD}
+		kbd.StopProcess()
+		delete(d.kbds, path)
+	} else if mse, ok := d.mses[path]; ok {
+		d.disabled[path] = DeviceStatus{Path: path, Name: mse.Name(), Kind: hid.DEVMOUSE}
+		mse.StopProcess()
+		delete(d.mses, path)
+	} else if _, ok := d.disabled[path]; !ok {
+		return &DevicesError{msg: "no such device", path: path}
+	}
+	btlog.Debug("Devices: disabled", path)
+	return nil
+}
+
+func (d *Devices) Stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.mon == nil {
+		return
+	}
+	d.mon.Close()
+	d.mon = nil
+
+	for p, kbd := range d.kbds {
+		kbd.StopProcess()
+		delete(d.kbds, p)
+	}
+
+	for p, mse := range d.mses {
+		mse.StopProcess()
+		delete(d.mses, p)
+	}
+
+	btlog.Debug("Stopped HIDevices")
+}
+
+func (d *Devices) watch(mon *hid.Monitor) {
+	for ev := range mon.Events() {
+		if ev.Added {
+			d.add(ev.Path)
+		} else {
+			d.remove(ev.Path)
+		}
+	}
+}
+
+func (d *Devices) add(path string) {
+	info, err := hid.ReadDeviceInfo(path)
+	if err != nil {
+		// udev may not have finished setting up the node yet
+		time.Sleep(100 * time.Millisecond)
+		if info, err = hid.ReadDeviceInfo(path); err != nil {
+			btlog.Debug("Reading device info failed", path, err)
+			return
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.disabled[path]; d.mon == nil || ok {
+		return
+	}
+
+	switch kind := d.rules.Classify(info); kind {
+	case hid.DEVKEYBOARD:
+		if _, ok := d.kbds[path]; ok {
+			return
+		}
+		src := d.agg.Source()
+		kbd, err := hid.NewKeyboard(path, src, d.kbdcfg)
+		if err != nil {
+			src.Close()
+			btlog.Debug("New Keyboard Initialization failed", path, err)
+			return
+		}
+		if d.grabbingLocked() {
+			kbd.SetGrab(true)
+		}
+		d.kbds[path] = kbd
+		go d.forgetKeyboard(kbd, src)
+		btlog.Debug("Keyboard added", path, info.Name, info.Phys)
+	case hid.DEVMOUSE:
+		if _, ok := d.mses[path]; ok {
+			return
+		}
+		src := d.agg.Source()
+		mse, err := hid.NewMouse(path, src)
+		if err != nil {
+			src.Close()
+			btlog.Debug("New Mouse Initialization failed", path, err)
+			return
+		}
+		if d.grabbingLocked() {
+			mse.SetGrab(true)
+		}
+		d.mses[path] = mse
+		go d.forgetMouse(mse, src)
+		btlog.Debug("Mouse added", path, info.Name, info.Phys)
+	default:
+		btlog.Debug("Device ignored", path, info.Name, kind)
+	}
+}
+
+func (d *Devices) remove(path string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.disabled, path)
+	if kbd, ok := d.kbds[path]; ok {
+		kbd.StopProcess()
+		delete(d.kbds, path)
+		btlog.Debug("Keyboard removed", path)
+	}
+	if mse, ok := d.mses[path]; ok {
+		mse.StopProcess()
+		delete(d.mses, path)
+		btlog.Debug("Mouse removed", path)
+	}
+}
+
+// Devices stop by themselves when they disappear mid-read
+// Keys held only by the stopped device are released on hosts
+func (d *Devices) forgetKeyboard(kbd *hid.Keyboard, src *hid.AggregatorSource) {
+	<-kbd.Done()
+	src.Close()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.kbds[kbd.Path()] == kbd {
+		delete(d.kbds, kbd.Path())
+	}
+}
+
+func (d *Devices) forgetMouse(mse *hid.Mouse, src *hid.AggregatorSource) {
+	<-mse.Done()
+	src.Close()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.mses[mse.Path()] == mse {
+		delete(d.mses, mse.Path())
+	}
+}
+
+type DevicesError struct {
+	msg  string
+	path string
+}
+
+func (e *DevicesError) Error() string {
+	return fmt.Sprintf("DevicesError: '%s' path: %s", e.msg, e.path)
+}
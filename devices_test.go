@@ -0,0 +1,135 @@
+package gobt
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gvalkov/golang-evdev"
+	"github.com/potch8228/gobt/hid"
+)
+
+// Records reports sent to hosts
+type recordSink struct {
+	mu   sync.Mutex
+	reps []hid.Report
+}
+
+func (s *recordSink) Send(r hid.Report) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r.Data = append([]byte(nil), r.Data...)
+	s.reps = append(s.reps, r)
+	return nil
+}
+
+func (s *recordSink) data() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var d [][]byte
+	for _, r := range s.reps {
+		d = append(d, r.Data)
+	}
+	return d
+}
+
+func kbdState(mods byte, keys ...byte) []byte {
+	b := []byte{0xA1, 0x02, mods, 0x00, 0, 0, 0, 0, 0, 0}
+	copy(b[4:], keys)
+	return b
+}
+
+// Macro reports keep Shift held on a keyboard, and the keyboard state stays after the macro
+func TestDevicesMacroMerged(t *testing.T) {
+	sink := &recordSink{}
+	d := NewDevices(sink, hid.KeyboardConfig{Hotkeys: hid.NewHotkeys()})
+	kbd := d.Source()
+	kbd.Send(hid.Report{Type: hid.REPORTKEYBOARD, Data: kbdState(hid.MODLSHIFT)})
+
+	m := hid.NewMacros(d.Source())
+	m.SetDelay(0)
+	m.Play([]hid.MacroStep{{Kind: hid.MACROPRESS, Code: evdev.KEY_A}, {Kind: hid.MACRORELEASE, Code: evdev.KEY_A}})
+	m.Wait()
+
+	want := [][]byte{kbdState(hid.MODLSHIFT), kbdState(hid.MODLSHIFT, 0x04), kbdState(hid.MODLSHIFT)}
+	got := sink.data()
+	if len(got) != len(want) {
+		t.Fatalf("got % x; want % x", got, want)
+	}
+	for i := range want {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Errorf("report %d = % x; want % x", i, got[i], want[i])
+		}
+	}
+}
+
+func grabbing(d *Devices) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.grabbingLocked()
+}
+
+func local(d *Devices) bool {
+	return atomic.LoadInt32(&d.local) != 0
+}
+
+func TestDevicesGrabEscape(t *testing.T) {
+	sink := &recordSink{}
+	d := NewDevices(sink, hid.KeyboardConfig{Hotkeys: hid.NewHotkeys()})
+	d.ConfigureGrab(DefaultGrabConfig())
+
+	if grabbing(d) {
+		t.Error("grabbing without hosts")
+	}
+	d.SetConnected(true)
+	if !grabbing(d) {
+		t.Error("not grabbing with a host connected")
+	}
+
+	// escape keeps input local after releasing keys on hosts
+	d.toggleLocal()
+	if !local(d) || grabbing(d) {
+		t.Errorf("local %v grabbing %v after escape", local(d), grabbing(d))
+	}
+	got := sink.data()
+	if len(got) != len(hid.ReleaseReports()) {
+		t.Fatalf("got % x on escape; want release reports", got)
+	}
+	for i, rep := range hid.ReleaseReports() {
+		if !bytes.Equal(got[i], rep.Data) {
+			t.Errorf("report %d = % x; want % x", i, got[i], rep.Data)
+		}
+	}
+	d.Send(hid.Report{Type: hid.REPORTKEYBOARD, Data: []byte{0xA1, 0x02, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00}})
+	if n := len(sink.data()); n != len(hid.ReleaseReports()) {
+		t.Error("local input forwarded")
+	}
+
+	d.toggleLocal()
+	if local(d) || !grabbing(d) {
+		t.Errorf("local %v grabbing %v after second escape", local(d), grabbing(d))
+	}
+	d.Send(hid.Report{Type: hid.REPORTKEYBOARD, Data: []byte{0xA1, 0x02, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00}})
+	if n := len(sink.data()); n != len(hid.ReleaseReports())+1 {
+		t.Error("input not forwarded")
+	}
+
+	// the last host leaving ends local mode as well
+	d.toggleLocal()
+	d.SetConnected(false)
+	if local(d) || grabbing(d) {
+		t.Errorf("local %v grabbing %v without hosts", local(d), grabbing(d))
+	}
+}
+
+func TestDevicesGrabDisabled(t *testing.T) {
+	d := NewDevices(&recordSink{}, hid.KeyboardConfig{Hotkeys: hid.NewHotkeys()})
+	cfg := DefaultGrabConfig()
+	cfg.Enable = false
+	d.ConfigureGrab(cfg)
+	d.SetConnected(true)
+	if grabbing(d) {
+		t.Error("grabbing while disabled")
+	}
+}
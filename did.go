@@ -0,0 +1,40 @@
+package gobt
+
+import (
+	"golang.org/x/sys/unix"
+
+	"github.com/godbus/dbus"
+	btlog "github.com/potch8228/gobt/log"
+)
+
+// org.bluez.Profile1 which only publishes the Device ID (PnP Information) record
+// No connection is expected on this profile
+type DeviceIDProfile struct {
+	path dbus.ObjectPath
+}
+
+func NewDeviceIDProfile(path string) *DeviceIDProfile {
+	return &DeviceIDProfile{
+		path: (dbus.ObjectPath)(path),
+	}
+}
+
+func (p *DeviceIDProfile) Path() dbus.ObjectPath {
+	return p.path
+}
+
+func (p *DeviceIDProfile) Release() *dbus.Error {
+	btlog.Debug("DeviceID Release")
+	return nil
+}
+
+func (p *DeviceIDProfile) NewConnection(dev dbus.ObjectPath, fd dbus.UnixFD, fdProps map[string]dbus.Variant) *dbus.Error {
+	btlog.Debug("DeviceID NewConnection; closing", dev, fd)
+	unix.Close(int(fd))
+	return nil
+}
+
+func (p *DeviceIDProfile) RequestDisconnection(dev dbus.ObjectPath) *dbus.Error {
+	btlog.Debug("DeviceID RequestDisconnection", dev)
+	return nil
+}
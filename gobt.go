@@ -1,9 +1,12 @@
 package gobt
 
 import (
-	"path/filepath"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/godbus/dbus"
 	"github.com/potch8228/gobt/bluetooth"
 	"github.com/potch8228/gobt/hid"
 	btlog "github.com/potch8228/gobt/log"
@@ -17,36 +20,76 @@ const (
 
 const (
 	HIDPHEADERTRANSMASK = 0xf0
+	HIDPHEADERPARAMMASK = 0x0f
 
 	HIDPTRANSHANDSHAKE   = 0x00
-	HIDPTRANSSETPROTOCOL = 0x60
+	HIDPTRANSHIDCONTROL  = 0x10
+	HIDPTRANSGETREPORT   = 0x40
+	HIDPTRANSSETREPORT   = 0x50
+	HIDPTRANSGETPROTOCOL = 0x60
+	HIDPTRANSSETPROTOCOL = 0x70
+	HIDPTRANSGETIDLE     = 0x80
+	HIDPTRANSSETIDLE     = 0x90
 	HIDPTRANSDATA        = 0xa0
+	HIDPTRANSDATC        = 0xb0
 
-	HIDPHSHKSUCCESSFUL = 0x00
-	HIDPHSHKERRUNKNOWN = 0x0e
+	HIDPCTRLVIRTUALCABLEUNPLUG = 0x05
+
+	// GET_REPORT parameter; the size field follows the report ID when set
+	HIDPGETREPORTSIZE   = 0x08
+	HIDPREPORTTYPEMASK  = 0x03
+	HIDPREPORTTYPEINPUT = 0x01
+
+	HIDPPROTOCOLBOOT   = 0x00
+	HIDPPROTOCOLREPORT = 0x01
+
+	HIDPHSHKSUCCESSFUL         = 0x00
+	HIDPHSHKERRINVALIDREPORTID = 0x02
+	HIDPHSHKERRUNSUPPORTED     = 0x03
+	HIDPHSHKERRINVALIDPARAM    = 0x04
+	HIDPHSHKERRUNKNOWN         = 0x0e
 )
 
+type GoBtError struct {
+	msg  string
+	addr bluetooth.Addr
+}
+
+func (e *GoBtError) Error() string {
+	return fmt.Sprintf("GoBtError: '%s' host: %s", e.msg, e.addr)
+}
+
 type GoBt struct {
-	kbds  []*hid.Keyboard
-	mses  []*hid.Mouse
+	dev  dbus.ObjectPath
+	addr bluetooth.Addr
+
 	sintr *bluetooth.Bluetooth
 	sctrl *bluetooth.Bluetooth
 
-	cctl chan GoBtPollState
+	// Paces reports written on sintr
+	sched *Scheduler
+	// HIDPPROTOCOLBOOT or HIDPPROTOCOLREPORT; set by the host
+	protocol int32
+	since    time.Time
+
+	cctl  chan GoBtPollState
+	close sync.Once
 }
 
-func NewGoBt(sintr, sctrl *bluetooth.Bluetooth) *GoBt {
+// Creates connection to host; latency of its reports is recorded in stats unless nil
+func NewGoBt(dev dbus.ObjectPath, addr bluetooth.Addr, sintr, sctrl *bluetooth.Bluetooth, stats *LatencyStats) *GoBt {
 	gobt := GoBt{
-		sintr: sintr,
-		sctrl: sctrl,
-		cctl:  make(chan GoBtPollState, 2),
+		dev:      dev,
+		addr:     addr,
+		sintr:    sintr,
+		sctrl:    sctrl,
+		sched:    NewScheduler(sintr, DEFAULTREPORTINTERVAL),
+		protocol: HIDPPROTOCOLREPORT,
+		since:    time.Now(),
+		cctl:     make(chan GoBtPollState, 2),
 	}
 
-	kbdPs, _ := filepath.Glob("/dev/input/by-path/*event-kbd")
-	gobt.registerKeyboardPaths(kbdPs)
-
-	msePs, _ := filepath.Glob("/dev/input/by-path/*event-mouse")
-	gobt.registerMousePaths(msePs)
+	gobt.sched.Measure(stats, addr.String())
 
 	btlog.Debug("Sending hello on ctrl channel")
 	if _, err := gobt.sctrl.Write([]byte{0xa1, 0x13, 0x03}); err != nil {
@@ -60,6 +103,7 @@ func NewGoBt(sintr, sctrl *bluetooth.Bluetooth) *GoBt {
 	time.Sleep(1 * time.Second)
 
 	go gobt.startProcessCtrlEvent()
+	go gobt.sched.Run()
 	return &gobt
 }
 
@@ -78,68 +122,166 @@ func (gb *GoBt) startProcessCtrlEvent() {
 				return
 			}
 
-			hsk := []byte{HIDPTRANSHANDSHAKE}
-			msgTyp := r[0] & HIDPHEADERTRANSMASK
-
-			switch {
-			case (msgTyp & HIDPTRANSSETPROTOCOL) != 0:
-				btlog.Debug("GoBt.procesCtrlEvent: handshake set protocol")
-				hsk[0] |= HIDPHSHKSUCCESSFUL
-				if _, err := gb.sctrl.Write(hsk); err != nil {
-					btlog.Debug("GoBt.procesCtrlEvent: handshake set protocol: failure on reply")
-				}
-			case (msgTyp & HIDPTRANSDATA) != 0:
-				btlog.Debug("GoBt.procesCtrlEvent: handshake data")
-			default:
-				btlog.Debug("GoBt.procesCtrlEvent: unknown handshake message")
-				hsk[0] |= HIDPHSHKERRUNKNOWN
-				gb.sctrl.Write(hsk)
-			}
+			gb.handleCtrl(r[:d])
 		}
 	}
 }
 
-func (gb *GoBt) registerKeyboardPaths(ps []string) {
-	kbds := make([]*hid.Keyboard, len(ps))
-	var err error
-	for i, p := range ps {
-		kbds[i], err = hid.NewKeyboard(p, gb.sintr)
-		if err != nil {
-			btlog.Debug("New Keyboard Initialization failed", err, i)
+// Answers a request of the host on the control channel
+func (gb *GoBt) handleCtrl(msg []byte) {
+	param := msg[0] & HIDPHEADERPARAMMASK
+	switch msg[0] & HIDPHEADERTRANSMASK {
+	case HIDPTRANSHIDCONTROL:
+		btlog.Debug("GoBt.handleCtrl: control", param)
+		if param == HIDPCTRLVIRTUALCABLEUNPLUG {
+			gb.Close()
+		}
+	case HIDPTRANSGETREPORT:
+		btlog.Debug("GoBt.handleCtrl: get report", param)
+		gb.replyCtrl(gb.currentReport(param, msg[1:]))
+	case HIDPTRANSSETREPORT:
+		// output reports(e.g. keyboard LEDs) are accepted and ignored
+		btlog.Debug("GoBt.handleCtrl: set report", param)
+		gb.replyCtrl([]byte{HIDPTRANSHANDSHAKE | HIDPHSHKSUCCESSFUL})
+	case HIDPTRANSGETPROTOCOL:
+		btlog.Debug("GoBt.handleCtrl: get protocol")
+		gb.replyCtrl([]byte{HIDPTRANSDATA, gb.Protocol()})
+	case HIDPTRANSSETPROTOCOL:
+		btlog.Debug("GoBt.handleCtrl: set protocol", param)
+		atomic.StoreInt32(&gb.protocol, int32(param&0x01))
+		gb.replyCtrl([]byte{HIDPTRANSHANDSHAKE | HIDPHSHKSUCCESSFUL})
+	case HIDPTRANSGETIDLE:
+		btlog.Debug("GoBt.handleCtrl: get idle")
+		gb.replyCtrl([]byte{HIDPTRANSDATA, byte(gb.sched.Idle() / IDLERATEUNIT)})
+	case HIDPTRANSSETIDLE:
+		if len(msg) < 2 {
+			gb.replyCtrl([]byte{HIDPTRANSHANDSHAKE | HIDPHSHKERRINVALIDPARAM})
+			return
 		}
+		btlog.Debug("GoBt.handleCtrl: set idle", msg[1])
+		gb.sched.SetIdle(time.Duration(msg[1]) * IDLERATEUNIT)
+		gb.replyCtrl([]byte{HIDPTRANSHANDSHAKE | HIDPHSHKSUCCESSFUL})
+	case HIDPTRANSDATA, HIDPTRANSDATC:
+		// reports on the control channel are deprecated and need no reply
+		btlog.Debug("GoBt.handleCtrl: data")
+	default:
+		btlog.Debug("GoBt.handleCtrl: unsupported request", msg[0])
+		gb.replyCtrl([]byte{HIDPTRANSHANDSHAKE | HIDPHSHKERRUNSUPPORTED})
 	}
-	gb.kbds = kbds
 }
 
-func (gb *GoBt) registerMousePaths(ps []string) {
-	mses := make([]*hid.Mouse, len(ps))
-	var err error
-	for i, p := range ps {
-		mses[i], err = hid.NewMouse(p, gb.sintr)
-		if err != nil {
-			btlog.Debug("New Mouse Initialization failed", err, i)
-		}
+func (gb *GoBt) replyCtrl(b []byte) {
+	if b[0]&HIDPHEADERTRANSMASK == HIDPTRANSHANDSHAKE {
+		handshakes.With(handshakeName(b[0] & HIDPHEADERPARAMMASK)).Inc()
+	}
+	if _, err := gb.sctrl.Write(b); err != nil {
+		btlog.Debug("GoBt.handleCtrl: failure on reply", gb.addr, err)
 	}
-	gb.mses = mses
 }
 
-func (gb *GoBt) Close() {
-	for _, kbd := range gb.kbds {
-		kbd.StopProcess()
+// Reply to GET_REPORT; the input report last sent with the requested ID, or a handshake error
+func (gb *GoBt) currentReport(param byte, args []byte) []byte {
+	if param&HIDPREPORTTYPEMASK != HIDPREPORTTYPEINPUT {
+		return []byte{HIDPTRANSHANDSHAKE | HIDPHSHKERRUNSUPPORTED}
+	}
+	if len(args) < 1 {
+		return []byte{HIDPTRANSHANDSHAKE | HIDPHSHKERRINVALIDPARAM}
 	}
 
-	for _, mse := range gb.mses {
-		mse.StopProcess()
+	boot := gb.Protocol() == HIDPPROTOCOLBOOT
+	for _, rep := range hid.ReleaseReports() {
+		data := gb.sched.Last(rep.Type)
+		if len(data) == 0 {
+			data = rep.Data
+			if boot {
+				data = rep.Boot()
+			}
+		}
+		if len(data) > 1 && data[1] == args[0] {
+			if param&HIDPGETREPORTSIZE != 0 && len(args) >= 3 {
+				if size := int(args[1]) | int(args[2])<<8; size+1 < len(data) {
+					data = data[:size+1]
+				}
+			}
+			return data
+		}
+	}
+	return []byte{HIDPTRANSHANDSHAKE | HIDPHSHKERRINVALIDREPORTID}
+}
+
+// BlueZ device object of the host
+func (gb *GoBt) Device() dbus.ObjectPath {
+	return gb.dev
+}
+
+// Address of the host
+func (gb *GoBt) Addr() bluetooth.Addr {
+	return gb.addr
+}
+
+// Protocol selected by the host; HIDPPROTOCOLBOOT or HIDPPROTOCOLREPORT
+func (gb *GoBt) Protocol() byte {
+	return byte(atomic.LoadInt32(&gb.protocol))
+}
+
+// Closed when the connection is closed
+func (gb *GoBt) Done() <-chan GoBtPollState {
+	return gb.cctl
+}
+
+// Queues report to be written on the interrupt channel; converted to boot format in boot protocol
+// Mouse reports are merged or dropped when the host does not keep up; keyboard reports never are
+func (gb *GoBt) Send(rep hid.Report) error {
+	if gb.Protocol() == HIDPPROTOCOLBOOT {
+		if rep.Data = rep.Boot(); rep.Data == nil {
+			return nil
+		}
+	}
+	if err := gb.sched.Send(rep); err != nil {
+		btlog.Debug("Host is stalled; dropping report", gb.addr, rep.Type)
+		return &GoBtError{msg: err.Error(), addr: gb.addr}
 	}
+	return nil
+}
 
-	btlog.Debug("Stopped HIDevices")
+// Reports waiting to be written on the interrupt channel
+func (gb *GoBt) QueueDepth() int {
+	return gb.sched.Depth()
+}
 
-	btlog.Debug("Trying to Stop GoBt evevnt loop")
-	gb.cctl <- STOP
+// State of a connected host
+type HostStatus struct {
+	Addr   bluetooth.Addr
+	Device dbus.ObjectPath
+	// Receives input; set by HidProfile.Hosts
+	Active     bool
+	Protocol   byte
+	Idle       time.Duration
+	QueueDepth int
+	// Time the host connected
+	Since time.Time
+}
+
+func (gb *GoBt) Status() HostStatus {
+	return HostStatus{
+		Addr:       gb.addr,
+		Device:     gb.dev,
+		Protocol:   gb.Protocol(),
+		Idle:       gb.sched.Idle(),
+		QueueDepth: gb.QueueDepth(),
+		Since:      gb.since,
+	}
+}
+
+// Closes both channels of the host; safe to call more than once
+func (gb *GoBt) Close() {
+	gb.close.Do(func() {
+		btlog.Debug("Trying to Stop GoBt evevnt loop")
+		close(gb.cctl)
+		gb.sched.Close()
 
-	btlog.Debug("Trying to Destory Objects")
-	gb.kbds = nil
-	gb.mses = nil
-	gb.sintr = nil
-	gb.sctrl = nil
+		btlog.Debug("Closing channels", gb.addr)
+		gb.sintr.Close()
+		gb.sctrl.Close()
+	})
 }
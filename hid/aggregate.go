@@ -0,0 +1,177 @@
+package hid
+
+import (
+	"sync"
+	"time"
+)
+
+// Usage reported in every key slot when more keys are held than a report can carry
+const USAGEERRORROLLOVER = 0x01
+
+// Merges reports of several keyboards and mice into the state of one keyboard and one mouse
+// Holding Shift on one keyboard and typing on another sends shifted keys
+type Aggregator struct {
+	mu      sync.Mutex
+	sink    Sink
+	sources []*AggregatorSource
+
+	kbd []byte
+	mse []byte
+}
+
+func NewAggregator(sink Sink) *Aggregator {
+	return &Aggregator{
+		sink: sink,
+		kbd:  []byte{0xA1, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		mse:  []byte{0xA1, 0x01, 0x00, 0x00, 0x00, 0x00},
+	}
+}
+
+// Input of one device; Send merges its reports with the other sources
+type AggregatorSource struct {
+	agg    *Aggregator
+	closed bool
+
+	// Last keyboard report and mouse buttons of the device
+	kbd     [10]byte
+	buttons byte
+}
+
+// Adds source for a device
+func (a *Aggregator) Source() *AggregatorSource {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s := &AggregatorSource{agg: a}
+	a.sources = append(a.sources, s)
+	return s
+}
+
+// Sends merged state of every source
+func (s *AggregatorSource) Send(r Report) error {
+	a := s.agg
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+
+	switch {
+	case r.Type == REPORTKEYBOARD && len(r.Data) == len(s.kbd):
+		copy(s.kbd[:], r.Data)
+		return a.sendKeyboardLocked(r.Time, r.Device)
+	case r.Type == REPORTMOUSE && len(r.Data) == len(a.mse):
+		s.buttons = r.Data[2]
+		copy(a.mse, r.Data)
+		a.mse[2] = a.buttonsLocked()
+		return a.sink.Send(Report{Type: REPORTMOUSE, Data: a.mse, Time: r.Time, Device: r.Device})
+	}
+	return a.sink.Send(r)
+}
+
+// Removes source; keys and buttons it held are released unless other sources hold them
+func (s *AggregatorSource) Close() error {
+	a := s.agg
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	for i, src := range a.sources {
+		if src == s {
+			a.sources = append(a.sources[:i], a.sources[i+1:]...)
+			break
+		}
+	}
+
+	var rerr error
+	if s.holding() {
+		rerr = a.sendKeyboardLocked(time.Time{}, "")
+	}
+	if s.buttons != 0 {
+		a.mse[3], a.mse[4], a.mse[5] = 0x00, 0x00, 0x00
+		a.mse[2] = a.buttonsLocked()
+		if err := a.sink.Send(Report{Type: REPORTMOUSE, Data: a.mse}); err != nil {
+			rerr = err
+		}
+	}
+	return rerr
+}
+
+func (s *AggregatorSource) holding() bool {
+	for _, b := range s.kbd[2:] {
+		if b != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Forgets keys and buttons held by every source and sends release reports
+func (a *Aggregator) Release() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, s := range a.sources {
+		s.kbd = [10]byte{}
+		s.buttons = 0
+	}
+
+	var rerr error
+	for _, rep := range ReleaseReports() {
+		if err := a.sink.Send(rep); err != nil {
+			rerr = err
+		}
+	}
+	return rerr
+}
+
+// Sends merged keyboard state caused by event of device at t
+func (a *Aggregator) sendKeyboardLocked(t time.Time, device string) error {
+	var mods byte
+	keys := a.kbd[4:]
+	for i := range keys {
+		keys[i] = 0x00
+	}
+
+	n, rollover := 0, false
+	for _, s := range a.sources {
+		mods |= s.kbd[2]
+		if rollover {
+			continue
+		}
+	merge:
+		for _, k := range s.kbd[4:] {
+			if k == 0x00 {
+				continue
+			}
+			for _, held := range keys[:n] {
+				if held == k {
+					continue merge
+				}
+			}
+			if n == len(keys) {
+				rollover = true
+				break
+			}
+			keys[n] = k
+			n++
+		}
+	}
+	if rollover {
+		// hosts keep keys already down while every slot reports ErrorRollOver
+		for i := range keys {
+			keys[i] = USAGEERRORROLLOVER
+		}
+	}
+
+	a.kbd[2] = mods
+	return a.sink.Send(Report{Type: REPORTKEYBOARD, Data: a.kbd, Time: t, Device: device})
+}
+
+func (a *Aggregator) buttonsLocked() byte {
+	var b byte
+	for _, s := range a.sources {
+		b |= s.buttons
+	}
+	return b
+}
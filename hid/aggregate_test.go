@@ -0,0 +1,92 @@
+package hid
+
+import (
+	"bytes"
+	"testing"
+)
+
+func mouseReport(buttons byte, x int8) []byte {
+	return []byte{0xA1, 0x01, buttons, byte(x), 0x00, 0x00}
+}
+
+func (s *testSink) last() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.reps) == 0 {
+		return nil
+	}
+	return s.reps[len(s.reps)-1]
+}
+
+func TestAggregatorKeyboards(t *testing.T) {
+	sink := &testSink{}
+	agg := NewAggregator(sink)
+	k1, k2 := agg.Source(), agg.Source()
+
+	steps := []struct {
+		src  *AggregatorSource
+		data []byte
+		want []byte
+	}{
+		{k1, kbdReport(MODLSHIFT), kbdReport(MODLSHIFT)},
+		{k2, kbdReport(0, 0x04), kbdReport(MODLSHIFT, 0x04)},
+		{k1, kbdReport(MODLSHIFT, 0x04, 0x05), kbdReport(MODLSHIFT, 0x04, 0x05)},
+		{k2, kbdReport(MODRCTRL, 0x06, 0x07, 0x08, 0x09), kbdReport(MODLSHIFT|MODRCTRL, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09)},
+		{k1, kbdReport(MODLSHIFT, 0x04, 0x05, 0x0a), kbdReport(MODLSHIFT|MODRCTRL, 1, 1, 1, 1, 1, 1)},
+		{k1, kbdReport(0), kbdReport(MODRCTRL, 0x06, 0x07, 0x08, 0x09)},
+	}
+	for i, st := range steps {
+		st.src.Send(Report{Type: REPORTKEYBOARD, Data: st.data})
+		if got := sink.last(); !bytes.Equal(got, st.want) {
+			t.Errorf("step %d: got % x; want % x", i, got, st.want)
+		}
+	}
+
+	k2.Close()
+	if got := sink.last(); !bytes.Equal(got, kbdReport(0)) {
+		t.Errorf("closing source sent % x", got)
+	}
+	n := len(sink.reps)
+	k2.Send(Report{Type: REPORTKEYBOARD, Data: kbdReport(0, 0x04)})
+	if len(sink.reps) != n {
+		t.Error("closed source sent a report")
+	}
+}
+
+func TestAggregatorMice(t *testing.T) {
+	sink := &testSink{}
+	agg := NewAggregator(sink)
+	m1, m2 := agg.Source(), agg.Source()
+
+	m1.Send(Report{Type: REPORTMOUSE, Data: mouseReport(0x01, 0)})
+	m2.Send(Report{Type: REPORTMOUSE, Data: mouseReport(0x00, 5)})
+	if got := sink.last(); !bytes.Equal(got, mouseReport(0x01, 5)) {
+		t.Errorf("got % x; want left button held while moving", got)
+	}
+
+	m1.Close()
+	if got := sink.last(); !bytes.Equal(got, mouseReport(0x00, 0)) {
+		t.Errorf("closing source sent % x", got)
+	}
+
+	m2.Send(Report{Type: REPORTMOUSE, Data: mouseReport(0x02, 0)})
+	agg.Release()
+	m2.Send(Report{Type: REPORTMOUSE, Data: mouseReport(0x00, 1)})
+	if got := sink.last(); !bytes.Equal(got, mouseReport(0x00, 1)) {
+		t.Errorf("got % x after release", got)
+	}
+}
+
+func TestAggregatorAllocs(t *testing.T) {
+	agg := NewAggregator(discardSink{})
+	k1, k2 := agg.Source(), agg.Source()
+	k1.Send(Report{Type: REPORTKEYBOARD, Data: kbdReport(MODLSHIFT)})
+	down, up := Report{Type: REPORTKEYBOARD, Data: kbdReport(0, 0x04)}, Report{Type: REPORTKEYBOARD, Data: kbdReport(0)}
+	allocs := testing.AllocsPerRun(100, func() {
+		k2.Send(down)
+		k2.Send(up)
+	})
+	if allocs != 0 {
+		t.Errorf("Send allocates %v times per key", allocs)
+	}
+}
@@ -0,0 +1,76 @@
+package hid
+
+// HID Report Descriptor announced in the SDP record (attribute 0x0206)
+// Report ID 1 is the mouse, Report ID 2 is the keyboard.
+// Report ID 3 carries evdev codes of keys without a Keyboard page usage(see KeyboardConfig.VendorKeys)
+// See Mouse and Keyboard for the matching report structures
+var ReportDescriptor = []byte{
+	0x05, 0x01, // Usage Page (Generic Desktop)
+	0x09, 0x02, // Usage (Mouse)
+	0xa1, 0x01, // Collection (Application)
+	0x85, 0x01, //   Report ID (1)
+	0x09, 0x01, //   Usage (Pointer)
+	0xa1, 0x00, //   Collection (Physical)
+	0x05, 0x09, //     Usage Page (Button)
+	0x19, 0x01, //     Usage Minimum (1)
+	0x29, 0x03, //     Usage Maximum (3)
+	0x15, 0x00, //     Logical Minimum (0)
+	0x25, 0x01, //     Logical Maximum (1)
+	0x75, 0x01, //     Report Size (1)
+	0x95, 0x03, //     Report Count (3)
+	0x81, 0x02, //     Input (Data, Variable, Absolute)
+	0x75, 0x05, //     Report Size (5)
+	0x95, 0x01, //     Report Count (1)
+	0x81, 0x01, //     Input (Constant)
+	0x05, 0x01, //     Usage Page (Generic Desktop)
+	0x09, 0x30, //     Usage (X)
+	0x09, 0x31, //     Usage (Y)
+	0x15, 0x81, //     Logical Minimum (-127)
+	0x25, 0x7f, //     Logical Maximum (127)
+	0x75, 0x08, //     Report Size (8)
+	0x95, 0x02, //     Report Count (2)
+	0x81, 0x06, //     Input (Data, Variable, Relative)
+	0x09, 0x38, //     Usage (Wheel)
+	0x15, 0x81, //     Logical Minimum (-127)
+	0x25, 0x7f, //     Logical Maximum (127)
+	0x75, 0x08, //     Report Size (8)
+	0x95, 0x01, //     Report Count (1)
+	0x81, 0x06, //     Input (Data, Variable, Relative)
+	0xc0,       //   End Collection
+	0xc0,       // End Collection
+	0x05, 0x01, // Usage Page (Generic Desktop)
+	0x09, 0x06, // Usage (Keyboard)
+	0xa1, 0x01, // Collection (Application)
+	0x85, 0x02, //   Report ID (2)
+	0xa1, 0x00, //   Collection (Physical)
+	0x05, 0x07, //     Usage Page (Keyboard)
+	0x19, 0xe0, //     Usage Minimum (Left Control)
+	0x29, 0xe7, //     Usage Maximum (Right GUI)
+	0x15, 0x00, //     Logical Minimum (0)
+	0x25, 0x01, //     Logical Maximum (1)
+	0x75, 0x01, //     Report Size (1)
+	0x95, 0x08, //     Report Count (8)
+	0x81, 0x02, //     Input (Data, Variable, Absolute)
+	0x95, 0x08, //     Report Count (8)
+	0x75, 0x08, //     Report Size (8)
+	0x15, 0x00, //     Logical Minimum (0)
+	0x26, 0xff, 0x00, //     Logical Maximum (255)
+	0x05, 0x07, //     Usage Page (Keyboard)
+	0x19, 0x00, //     Usage Minimum (0)
+	0x29, 0xff, //     Usage Maximum (255)
+	0x81, 0x00, //     Input (Data, Array)
+	0xc0,             //   End Collection
+	0xc0,             // End Collection
+	0x06, 0x00, 0xff, // Usage Page (Vendor Defined 0xFF00)
+	0x09, 0x01, // Usage (Vendor Usage 1)
+	0xa1, 0x01, // Collection (Application)
+	0x85, 0x03, //   Report ID (3)
+	0x19, 0x01, //   Usage Minimum (1)
+	0x2a, 0xff, 0x02, //   Usage Maximum (767)
+	0x15, 0x01, //   Logical Minimum (1)
+	0x26, 0xff, 0x02, //   Logical Maximum (767)
+	0x75, 0x10, //   Report Size (16)
+	0x95, 0x01, //   Report Count (1)
+	0x81, 0x00, //   Input (Data, Array)
+	0xc0, // End Collection
+}
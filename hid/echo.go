@@ -0,0 +1,80 @@
+package hid
+
+import "strings"
+
+// Follows keyboard reports sent to a host and tells what the host types with its layout
+// Used to show what the host sees while debugging
+type Echo struct {
+	layout Layout
+	prev   [6]byte
+	// Pending dead key; zero when none
+	dead rune
+}
+
+func NewEcho(layout Layout) *Echo {
+	if layout == nil {
+		layout = LayoutUS
+	}
+	return &Echo{layout: layout}
+}
+
+// Text typed by keys newly pressed in keyboard report data
+// Keys typing no character are shown by name; e.g. <KEY_F1> or <KEY_LEFTCTRL+KEY_C>
+func (e *Echo) Feed(data []byte) string {
+	if len(data) != 10 || data[1] != 0x02 {
+		return ""
+	}
+	mods := data[2]
+
+	var out []rune
+	for _, k := range data[4:10] {
+		if k == 0 || e.pressed(k) {
+			continue
+		}
+
+		sym, ok := e.layout.Char(Usage(k), mods)
+		switch {
+		case !ok:
+			if e.dead != 0 {
+				out = append(out, e.dead)
+				e.dead = 0
+			}
+			out = append(out, []rune(keyLabel(Usage(k), mods))...)
+		case sym.Dead && e.dead == 0:
+			e.dead = sym.Rune
+		case e.dead != 0:
+			if c, ok := compose(e.dead, sym.Rune); ok && !sym.Dead {
+				out = append(out, c)
+			} else {
+				out = append(out, e.dead, sym.Rune)
+			}
+			e.dead = 0
+		default:
+			out = append(out, sym.Rune)
+		}
+	}
+
+	copy(e.prev[:], data[4:10])
+	return string(out)
+}
+
+func (e *Echo) pressed(k byte) bool {
+	for _, p := range e.prev {
+		if p == k {
+			return true
+		}
+	}
+	return false
+}
+
+// Names usage pressed with mods like <KEY_LEFTCTRL+KEY_C>
+func keyLabel(usage Usage, mods byte) string {
+	var parts []string
+	for u := USAGEMODMIN; u <= USAGEMODMAX; u++ {
+		if mods&u.ModBit() != 0 {
+			parts = append(parts, u.String())
+		}
+	}
+	parts = append(parts, usage.String())
+	return "<" + strings.Join(parts, "+") + ">"
+}
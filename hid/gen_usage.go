@@ -0,0 +1,158 @@
+//go:build ignore
+// +build ignore
+
+// Generates usage_table.go from the keyboard usage map(hid_keyboard) of the
+// kernel's drivers/hid/hid-input.c and key names of linux/input-event-codes.h
+//
+//	go run gen_usage.go -src linux/drivers/hid/hid-input.c -version v6.1
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	src     = flag.String("src", "hid-input.c", "path to the kernel's drivers/hid/hid-input.c")
+	codes   = flag.String("codes", "/usr/include/linux/input-event-codes.h", "path to linux/input-event-codes.h")
+	version = flag.String("version", "", "kernel version the sources are from(e.g. v6.1)")
+	out     = flag.String("o", "usage_table.go", "output file")
+)
+
+var (
+	tableRe  = regexp.MustCompile(`(?s)hid_keyboard\[256\]\s*=\s*\{(.*?)\};`)
+	defineRe = regexp.MustCompile(`^#define\s+(KEY_\w+)\s+(0x[0-9a-fA-F]+|\d+)`)
+)
+
+// Parses evdev key code of every usage; 0 is unmapped
+func parseUsages(path string) ([256]uint16, error) {
+	var usages [256]uint16
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return usages, err
+	}
+	m := tableRe.FindSubmatch(b)
+	if m == nil {
+		return usages, fmt.Errorf("%s: hid_keyboard not found", path)
+	}
+
+	n := 0
+	for _, f := range strings.Split(string(m[1]), ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if n == len(usages) {
+			return usages, fmt.Errorf("%s: hid_keyboard has more than 256 entries", path)
+		}
+		if f != "unk" {
+			code, err := strconv.ParseUint(f, 0, 16)
+			if err != nil {
+				return usages, fmt.Errorf("%s: bad entry %q of usage 0x%02x", path, f, n)
+			}
+			usages[n] = uint16(code)
+		}
+		n++
+	}
+	if n != len(usages) {
+		return usages, fmt.Errorf("%s: hid_keyboard has %d entries", path, n)
+	}
+	return usages, nil
+}
+
+// Parses first name of every key code; aliases defined by name are skipped
+func parseNames(path string) (map[uint16]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	names := make(map[uint16]string)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		m := defineRe.FindStringSubmatch(sc.Text())
+		if m == nil {
+			continue
+		}
+		code, err := strconv.ParseUint(m[2], 0, 16)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := names[uint16(code)]; !ok {
+			names[uint16(code)] = m[1]
+		}
+	}
+	return names, sc.Err()
+}
+
+func main() {
+	flag.Parse()
+	if *version == "" {
+		log.Fatal("-version is required")
+	}
+
+	usages, err := parseUsages(*src)
+	if err != nil {
+		log.Fatal(err)
+	}
+	names, err := parseNames(*codes)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// several usages can produce the same key(e.g. Mute at 0x7f and 0xef);
+	// the lowest wins so keys are sent as standard usages where there is one
+	var max uint16
+	keys := make(map[uint16]int)
+	for u, code := range usages {
+		if code == 0 {
+			continue
+		}
+		if _, ok := keys[code]; !ok {
+			keys[code] = u
+		}
+		if code > max {
+			max = code
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by gen_usage.go from Linux %s drivers/hid/hid-input.c; DO NOT EDIT.\n\n", *version)
+	fmt.Fprintf(&buf, "package hid\n\n")
+	fmt.Fprintf(&buf, "// Kernel version keyUsages and usageKeys are generated from\n")
+	fmt.Fprintf(&buf, "const USAGETABLEVERSION = %q\n\n", *version)
+	fmt.Fprintf(&buf, "// HID usage(Keyboard page) of evdev key code; 0 is unmapped\n")
+	fmt.Fprintf(&buf, "var keyUsages = [%d]Usage{\n", max+1)
+	for code := uint16(0); code <= max; code++ {
+		if u, ok := keys[code]; ok {
+			fmt.Fprintf(&buf, "\t%d: 0x%02x, // %s\n", code, u, names[code])
+		}
+	}
+	fmt.Fprintf(&buf, "}\n\n")
+	fmt.Fprintf(&buf, "// evdev key code of HID usage(Keyboard page); 0 is unmapped\n")
+	fmt.Fprintf(&buf, "var usageKeys = [256]uint16{\n")
+	for u, code := range usages {
+		if code != 0 {
+			fmt.Fprintf(&buf, "\t0x%02x: %d, // %s\n", u, code, names[code])
+		}
+	}
+	fmt.Fprintf(&buf, "}\n")
+
+	b, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(*out, b, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
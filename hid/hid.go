@@ -3,9 +3,10 @@ package hid
 import (
 	"fmt"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/gvalkov/golang-evdev"
-	"github.com/potch8228/gobt/bluetooth"
 	btlog "github.com/potch8228/gobt/log"
 )
 
@@ -50,140 +51,395 @@ Keyboard HID Report structure
 ]
 */
 type Keyboard struct {
+	path  string
 	dev   *evdev.InputDevice
 	state []byte
-	evlp  bool
 	ctl   chan DeviceEventCtrl
 	intr  chan *evdev.InputEvent
-	sintr *bluetooth.Bluetooth
+	sink  Sink
+	stop  sync.Once
+
+	hotkeys   *Hotkeys
+	pressed   [KEYCNT]bool
+	swallowed [KEYCNT]bool
+
+	remapper *Remapper
+	// Key codes sent for keys being held
+	mapped [KEYCNT]uint16
+
+	proc   *Processor
+	macros *Macros
+
+	// Keys without HID usage already logged
+	unmapped [KEYCNT]bool
+	// Vendor report forwarding keys without HID usage; nil when disabled
+	vendor []byte
+
+	// Requests releasing every key from other goroutines
+	release chan struct{}
+	// Fires when keys are held without any key event for maxHold; nil when disabled
+	maxHold  time.Duration
+	holdTime *time.Timer
 }
 
-func NewKeyboard(path string, sintr *bluetooth.Bluetooth) (*Keyboard, error) {
+// Processing shared by keyboards; nil fields disable it
+type KeyboardConfig struct {
+	Hotkeys  *Hotkeys
+	Remapper *Remapper
+	// Each keyboard runs its own processor with these layers
+	Layers *LayerConfig
+	Macros *Macros
+	// Forwards keys without HID usage as vendor usages(report ID 3)
+	VendorKeys bool
+	// Releases every key when keys are held this long without any key event(autorepeat included)
+	// Zero disables it
+	MaxHold time.Duration
+}
+
+func NewKeyboard(path string, sink Sink, cfg KeyboardConfig) (*Keyboard, error) {
+	dev, err := evdev.Open(path)
+	if err != nil {
+		btlog.Debug("Failure on Opening Keyboard: ", path)
+		return nil, err
+	}
+
+	k := newKeyboard(path, dev, sink, cfg)
+	go k.startProcess()
+
+	return k, nil
+}
+
+func newKeyboard(path string, dev *evdev.InputDevice, sink Sink, cfg KeyboardConfig) *Keyboard {
 	k := new(Keyboard)
 
-	k.evlp = false
+	k.path = path
+	k.dev = dev
 	k.state = make([]byte, 10)
-	for i, _ := range k.state {
-		k.state[i] = 0x00
-	}
 	k.state[0] = 0xA1
 	k.state[1] = 0x02
 
-	var err error
-	k.dev, err = evdev.Open(path)
-	if err != nil {
-		btlog.Debug("Failure on Opening Keyboard: ", path)
-		return nil, err
+	k.sink = sink
+	k.hotkeys = cfg.Hotkeys
+	k.remapper = cfg.Remapper
+	k.macros = cfg.Macros
+	if cfg.Layers != nil {
+		k.proc = NewProcessor(*cfg.Layers)
+	}
+	if cfg.VendorKeys {
+		k.vendor = []byte{0xA1, 0x03, 0x00, 0x00}
 	}
-	k.sintr = sintr
 
-	k.ctl = make(chan DeviceEventCtrl, 1)
-	k.intr = make(chan *evdev.InputEvent, 10)
+	if cfg.MaxHold > 0 {
+		k.maxHold = cfg.MaxHold
+		k.holdTime = time.NewTimer(cfg.MaxHold)
+		k.holdTime.Stop()
+	}
 
-	k.evlp = true
-	go k.startProcess()
+	k.ctl = make(chan DeviceEventCtrl)
+	k.intr = make(chan *evdev.InputEvent, 10)
+	k.release = make(chan struct{}, 1)
 
-	return k, nil
+	return k
 }
 
 func (k *Keyboard) startProcess() {
 	go k.pollEvent()
+	k.loop()
+}
 
-	for k.evlp {
+func (k *Keyboard) loop() {
+	var tick <-chan time.Time
+	var hold <-chan time.Time
+	if k.holdTime != nil {
+		hold = k.holdTime.C
+	}
+
+	for {
 		select {
+		case <-k.ctl:
+			btlog.Debug("Stopping Keyboard Event loop")
+			k.releaseAll()
+			return
 		case ev := <-k.intr:
-			btlog.Debug("Keyboard Event detected", ev)
-			if err := k.changeState(ev); err != nil {
-				btlog.Debug("Failure on keyboard changeState", err)
-				k.StopProcess()
-				break
+			if btlog.Enabled() {
+				btlog.Debug("Keyboard Event detected", ev)
 			}
-			k.send()
+			// autorepeat only tells that keys are still held
+			if evdev.KeyEventState(ev.Value) != evdev.KeyHold {
+				k.changeState(ev)
+			}
+			k.watchHold()
+		case now := <-tick:
+			k.applyKeys(k.proc.Tick(now))
+			k.watchHold()
+		case <-k.release:
+			k.releaseAll()
+			k.watchHold()
+		case <-hold:
+			btlog.Debug("Keyboard: keys held too long; releasing", k.path)
+			k.releaseAll()
 		}
+		tick = k.deadline()
 	}
+}
 
-	k.evlp = false
-	close(k.ctl)
-	close(k.intr)
-	btlog.Debug("Stopping Keyboard Event loop")
+// Releases every key on the sink and forgets held keys; safe to call from any goroutine
+// Keys still physically down are sent again only when pressed again
+func (k *Keyboard) ReleaseAll() {
+	select {
+	case k.release <- struct{}{}:
+	default:
+		// already requested
+	}
 }
 
+func (k *Keyboard) releaseAll() {
+	if k.proc != nil {
+		k.proc.Reset()
+	}
+	k.mapped = [KEYCNT]uint16{}
+	for i := 2; i < len(k.state); i++ {
+		k.state[i] = 0x00
+	}
+	k.send(time.Time{})
+
+	if k.vendor != nil && (k.vendor[2] != 0 || k.vendor[3] != 0) {
+		k.vendor[2], k.vendor[3] = 0x00, 0x00
+		reportCounters[REPORTVENDOR].Inc()
+		if err := k.sink.Send(Report{Type: REPORTVENDOR, Data: k.vendor}); err != nil {
+			btlog.Debug("Failure on Sending Vendor Key")
+		}
+	}
+}
+
+// Reports whether the host sees any key held
+func (k *Keyboard) holding() bool {
+	for _, b := range k.state[2:] {
+		if b != 0 {
+			return true
+		}
+	}
+	return k.vendor != nil && (k.vendor[2] != 0 || k.vendor[3] != 0)
+}
+
+// Restarts max hold timer while keys are held
+func (k *Keyboard) watchHold() {
+	if k.holdTime == nil {
+		return
+	}
+	if !k.holdTime.Stop() {
+		select {
+		case <-k.holdTime.C:
+		default:
+		}
+	}
+	if k.holding() {
+		k.holdTime.Reset(k.maxHold)
+	}
+}
+
+// Fires when the layer processor has a pending decision
+func (k *Keyboard) deadline() <-chan time.Time {
+	if k.proc == nil {
+		return nil
+	}
+	d, ok := k.proc.Deadline()
+	if !ok {
+		return nil
+	}
+	return time.After(time.Until(d))
+}
+
+// Stops event loops and closes the device; safe to call more than once
 func (k *Keyboard) StopProcess() {
-	k.ctl <- DEVEVCBQUIT
-	k.evlp = false
+	k.stop.Do(func() {
+		close(k.ctl)
+		k.dev.File.Close()
+	})
+}
+
+// Closed when the keyboard is stopped; e.g. the device is unplugged
+func (k *Keyboard) Done() <-chan DeviceEventCtrl {
+	return k.ctl
+}
+
+func (k *Keyboard) Path() string {
+	return k.path
+}
+
+// evdev name of the device
+func (k *Keyboard) Name() string {
+	return k.dev.Name
+}
+
+// Grabs(true) or releases(false) the device exclusively; grabbed keys do not reach the local console
+func (k *Keyboard) SetGrab(grab bool) error {
+	if grab {
+		return k.dev.Grab()
+	}
+	return k.dev.Release()
 }
 
 func (k *Keyboard) pollEvent() {
 	for {
-		select {
-		case <-k.ctl:
-			btlog.Debug("Quitting Keyboard Poller")
+		input, err := k.dev.ReadOne()
+		if err != nil {
+			btlog.Debug("Error on reading keyboard event", k.path, err)
+			k.StopProcess()
 			return
-		default:
-			input, err := k.dev.ReadOne()
-			if err != nil {
-				btlog.Debug("Error on reading keyboard event", err)
-				k.StopProcess()
-				return
-			}
+		}
 
-			if input.Type == evdev.EV_KEY && evdev.KeyEventState(input.Value) <= evdev.KeyDown {
-				k.intr <- input
+		if input.Type == evdev.EV_KEY {
+			select {
+			case k.intr <- input:
+			case <-k.ctl:
+				btlog.Debug("Quitting Keyboard Poller")
+				return
 			}
 		}
 	}
 }
 
-func (k *Keyboard) send() {
-	log.Printf("Current Keyboard State: %v", k.state)
-	if _, err := k.sintr.Write(k.state); err != nil {
+// Sends keyboard state caused by event at t
+func (k *Keyboard) send(t time.Time) {
+	if btlog.Enabled() {
+		btlog.Debug(fmt.Sprintf("Current Keyboard State: %v", k.state))
+	}
+	reportCounters[REPORTKEYBOARD].Inc()
+	if err := k.sink.Send(Report{Type: REPORTKEYBOARD, Data: k.state, Time: t, Device: k.dev.Name}); err != nil {
 		btlog.Debug("Failure on Sending Keyboard State")
+	}
+}
+
+// Handles key event; runs without allocating unless debug output is enabled
+func (k *Keyboard) changeState(ev *evdev.InputEvent) {
+	code, down := ev.Code, evdev.KeyEventState(ev.Value) == evdev.KeyDown
+	t := eventTime(ev)
+	if k.handleHotkeys(code, down, t) {
 		return
 	}
 
-	btlog.Debug("Sending Keyboard State Done")
-}
+	if code == evdev.KEY_ESC && down {
+		k.macros.Interrupt()
+	}
 
-func (k *Keyboard) changeState(ev *evdev.InputEvent) error {
-	kev := evdev.NewKeyEvent(ev)
-	raw := evdev.KEY[int(kev.Scancode)]
+	in := KeyInput{Code: k.remap(code, down), Down: down, Time: t}
+	if k.proc == nil {
+		k.applyKey(in)
+		return
+	}
+	k.applyKeys(k.proc.Process(in))
+}
 
-	key, mkey := Convert(raw)
-	kev.Keycode = uint16(key)
+func (k *Keyboard) applyKeys(ins []KeyInput) {
+	for _, in := range ins {
+		k.applyKey(in)
+	}
+}
 
-	var err error = nil
-	switch mkey {
+// Updates report with key transition and sends it
+func (k *Keyboard) applyKey(in KeyInput) {
+	u, kind := Lookup(in.Code)
+	switch kind {
 	case MOD:
-		err = k.updateModifiers(kev)
+		k.updateModifiers(u, in.Down)
 	case FUNC:
-		k.updateStates(kev)
+		k.updateStates(u, in.Down)
+	default:
+		k.applyUnmapped(in.Code, in.Down)
+		return
 	}
 
-	return err
+	k.send(in.Time)
+	// after the host has the key, so that expansions follow it
+	if kind == FUNC {
+		if in.Down {
+			k.macros.Observe(in.Code, k.state[2])
+		} else {
+			k.macros.Released(in.Code)
+		}
+	}
 }
 
-func (k *Keyboard) updateModifiers(kev *evdev.KeyEvent) error {
-	if kev.Keycode > 8 { // length of 8 bits
-		return &DeviceError{msg: "bitpos(kev.Keycode) > 8", method: "updateModifiers()"}
+// Logs key without HID usage once and forwards it in the vendor report when enabled
+func (k *Keyboard) applyUnmapped(code uint16, down bool) {
+	if int(code) < KEYCNT && !k.unmapped[code] {
+		k.unmapped[code] = true
+		log.Printf("Keyboard %s: %s(%d) has no HID usage", k.dev.Name, evdev.KEY[int(code)], code)
+	}
+	if k.vendor == nil {
+		return
 	}
 
-	switch kev.State {
-	case evdev.KeyDown:
-		k.state[2] |= byte(1 << kev.Keycode)
-	case evdev.KeyUp:
-		k.state[2] &= byte(^(1 << kev.Keycode))
+	held := uint16(k.vendor[2]) | uint16(k.vendor[3])<<8
+	switch {
+	case down:
+		held = code
+	case held == code:
+		held = 0
+	default:
+		return
+	}
+	k.vendor[2], k.vendor[3] = byte(held), byte(held>>8)
+	reportCounters[REPORTVENDOR].Inc()
+	if err := k.sink.Send(Report{Type: REPORTVENDOR, Data: k.vendor, Device: k.dev.Name}); err != nil {
+		btlog.Debug("Failure on Sending Vendor Key")
+	}
+}
+
+// Tracks pressed keys and reports whether the event belongs to a hotkey
+func (k *Keyboard) handleHotkeys(code uint16, down bool, t time.Time) bool {
+	if int(code) >= KEYCNT {
+		return false
 	}
 
-	return nil
+	k.pressed[code] = down
+	if down {
+		// released keys pass unless their press was captured, so keys held on hosts get released
+		if k.hotkeys.captured(code) || k.hotkeys.trigger(&k.pressed, code, t) {
+			k.swallowed[code] = true
+			return true
+		}
+	} else if k.swallowed[code] {
+		k.swallowed[code] = false
+		return true
+	}
+	return false
+}
+
+// Remaps key; releases send the code chosen on press so switching host profiles does not leave keys stuck
+func (k *Keyboard) remap(code uint16, down bool) uint16 {
+	if int(code) >= KEYCNT {
+		return k.remapper.Map(k.dev.Name, code)
+	}
+
+	if down {
+		k.mapped[code] = k.remapper.Map(k.dev.Name, code)
+		return k.mapped[code]
+	}
+	to := k.mapped[code]
+	if to == 0 {
+		to = k.remapper.Map(k.dev.Name, code)
+	}
+	k.mapped[code] = 0
+	return to
 }
 
-func (k *Keyboard) updateStates(kev *evdev.KeyEvent) {
+func (k *Keyboard) updateModifiers(u Usage, down bool) {
+	if down {
+		k.state[2] |= u.ModBit()
+	} else {
+		k.state[2] &^= u.ModBit()
+	}
+}
+
+func (k *Keyboard) updateStates(u Usage, down bool) {
 	for i := 4; i < len(k.state); i++ {
 		switch {
-		case kev.State == evdev.KeyUp && byte(kev.Keycode) == k.state[i]:
+		case !down && byte(u) == k.state[i]:
 			k.state[i] = 0x00
-		case kev.State == evdev.KeyDown && k.state[i] == 0x00:
-			k.state[i] = byte(kev.Keycode)
+		case down && k.state[i] == 0x00:
+			k.state[i] = byte(u)
 			return
 		}
 	}
@@ -211,18 +467,19 @@ Mouse HID Report structure
 ]
 */
 type Mouse struct {
+	path  string
 	dev   *evdev.InputDevice
 	state []byte
-	evlp  bool
 	ctl   chan DeviceEventCtrl
 	intr  chan *evdev.InputEvent
-	sintr *bluetooth.Bluetooth
+	sink  Sink
+	stop  sync.Once
 }
 
-func NewMouse(path string, sintr *bluetooth.Bluetooth) (*Mouse, error) {
+func NewMouse(path string, sink Sink) (*Mouse, error) {
 	m := new(Mouse)
 
-	m.evlp = false
+	m.path = path
 	m.state = make([]byte, 6)
 	for i, _ := range m.state {
 		m.state[i] = 0x00
@@ -235,12 +492,11 @@ func NewMouse(path string, sintr *bluetooth.Bluetooth) (*Mouse, error) {
 	if err != nil {
 		return nil, err
 	}
-	m.sintr = sintr
+	m.sink = sink
 
-	m.ctl = make(chan DeviceEventCtrl, 1)
+	m.ctl = make(chan DeviceEventCtrl)
 	m.intr = make(chan *evdev.InputEvent, 10)
 
-	m.evlp = true
 	go m.startProcess()
 
 	return m, nil
@@ -249,53 +505,76 @@ func NewMouse(path string, sintr *bluetooth.Bluetooth) (*Mouse, error) {
 func (m *Mouse) startProcess() {
 	go m.pollEvent()
 
-	for m.evlp {
+	for {
 		select {
+		case <-m.ctl:
+			btlog.Debug("Stopping Mouse Event Loop")
+			return
 		case ev := <-m.intr:
 			m.changeState(ev)
-			m.send()
+			m.send(eventTime(ev))
 		}
 	}
-
-	close(m.ctl)
-	close(m.intr)
-	btlog.Debug("Stopping Mouse Event Loop")
 }
 
+// Stops event loops and closes the device; safe to call more than once
 func (m *Mouse) StopProcess() {
-	m.ctl <- DEVEVCBQUIT
-	m.evlp = false
+	m.stop.Do(func() {
+		close(m.ctl)
+		m.dev.File.Close()
+	})
+}
+
+// Closed when the mouse is stopped; e.g. the device is unplugged
+func (m *Mouse) Done() <-chan DeviceEventCtrl {
+	return m.ctl
+}
+
+func (m *Mouse) Path() string {
+	return m.path
+}
+
+// evdev name of the device
+func (m *Mouse) Name() string {
+	return m.dev.Name
+}
+
+// Grabs(true) or releases(false) the device exclusively
+func (m *Mouse) SetGrab(grab bool) error {
+	if grab {
+		return m.dev.Grab()
+	}
+	return m.dev.Release()
 }
 
 func (m *Mouse) pollEvent() {
 	for {
-		select {
-		case <-m.ctl:
-			btlog.Debug("Quitting Mouse Poller")
+		input, err := m.dev.ReadOne()
+		if err != nil {
+			btlog.Debug("Error on reading mouse event", m.path, err)
+			m.StopProcess()
 			return
-		default:
-			input, err := m.dev.ReadOne()
-			if err != nil {
-				btlog.Debug("Error on reading mouse event", err)
-				m.StopProcess()
-				return
-			}
+		}
 
-			switch input.Type {
-			case evdev.EV_ABS:
-				fallthrough
-			case evdev.EV_REL:
-				fallthrough
-			case evdev.EV_KEY:
-				m.intr <- input
+		switch input.Type {
+		case evdev.EV_ABS, evdev.EV_REL, evdev.EV_KEY:
+			select {
+			case m.intr <- input:
+			case <-m.ctl:
+				btlog.Debug("Quitting Mouse Poller")
+				return
 			}
 		}
 	}
 }
 
-func (m *Mouse) send() {
-	log.Printf("Current Mouse State: %v", m.state)
-	if _, err := m.sintr.Write(m.state); err != nil {
+// Sends mouse state caused by event at t
+func (m *Mouse) send(t time.Time) {
+	if btlog.Enabled() {
+		btlog.Debug(fmt.Sprintf("Current Mouse State: %v", m.state))
+	}
+	reportCounters[REPORTMOUSE].Inc()
+	if err := m.sink.Send(Report{Type: REPORTMOUSE, Data: m.state, Time: t, Device: m.dev.Name}); err != nil {
 		btlog.Debug("Failure on Sending Mouse State")
 	}
 	btlog.Debug("Sending Mouse State Done")
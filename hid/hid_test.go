@@ -0,0 +1,174 @@
+package hid
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/gvalkov/golang-evdev"
+)
+
+type discardSink struct{}
+
+func (discardSink) Send(r Report) error {
+	return nil
+}
+
+func keyEvent(code uint16, down bool) *evdev.InputEvent {
+	ev := &evdev.InputEvent{Type: evdev.EV_KEY, Code: code}
+	if down {
+		ev.Value = int32(evdev.KeyDown)
+	}
+	return ev
+}
+
+func testKeyboard(sink Sink, cfg KeyboardConfig) *Keyboard {
+	return newKeyboard("test", &evdev.InputDevice{Name: "test"}, sink, cfg)
+}
+
+// Keyboard with every processing stage enabled
+func fullKeyboard(sink Sink) *Keyboard {
+	hk := NewHotkeys()
+	hk.Register(Chord{evdev.KEY_LEFTCTRL, evdev.KEY_LEFTALT, evdev.KEY_F12}, func() {})
+	km := NewKeymap()
+	km.Global[evdev.KEY_CAPSLOCK] = evdev.KEY_LEFTCTRL
+	cfg := DefaultLayerConfig()
+	return testKeyboard(sink, KeyboardConfig{Hotkeys: hk, Remapper: NewRemapper(km), Layers: &cfg})
+}
+
+func TestKeyboardChangeState(t *testing.T) {
+	sink := &testSink{}
+	k := testKeyboard(sink, KeyboardConfig{})
+	k.changeState(keyEvent(evdev.KEY_LEFTSHIFT, true))
+	k.changeState(keyEvent(evdev.KEY_A, true))
+	k.changeState(keyEvent(evdev.KEY_A, false))
+	k.changeState(keyEvent(evdev.KEY_LEFTSHIFT, false))
+
+	want := [][]byte{kbdReport(MODLSHIFT), kbdReport(MODLSHIFT, 0x04), kbdReport(MODLSHIFT), kbdReport(0)}
+	if len(sink.reps) != len(want) {
+		t.Fatalf("got %d reports; want %d", len(sink.reps), len(want))
+	}
+	for i := range want {
+		if !bytes.Equal(sink.reps[i], want[i]) {
+			t.Errorf("report %d = % x; want % x", i, sink.reps[i], want[i])
+		}
+	}
+}
+
+func TestKeyboardVendorKeys(t *testing.T) {
+	sink := &testSink{}
+	k := testKeyboard(sink, KeyboardConfig{})
+	k.changeState(keyEvent(evdev.KEY_BRIGHTNESSUP, true))
+	k.changeState(keyEvent(evdev.KEY_BRIGHTNESSUP, false))
+	if len(sink.reps) != 0 {
+		t.Errorf("unmapped key sent % x", sink.reps)
+	}
+
+	sink = &testSink{}
+	k = testKeyboard(sink, KeyboardConfig{VendorKeys: true})
+	k.changeState(keyEvent(evdev.KEY_BRIGHTNESSUP, true))
+	k.changeState(keyEvent(evdev.KEY_BRIGHTNESSUP, false))
+	want := [][]byte{{0xA1, 0x03, evdev.KEY_BRIGHTNESSUP, 0x00}, {0xA1, 0x03, 0x00, 0x00}}
+	if len(sink.reps) != len(want) {
+		t.Fatalf("got %d reports; want %d", len(sink.reps), len(want))
+	}
+	for i := range want {
+		if !bytes.Equal(sink.reps[i], want[i]) {
+			t.Errorf("report %d = % x; want % x", i, sink.reps[i], want[i])
+		}
+	}
+	if !k.unmapped[evdev.KEY_BRIGHTNESSUP] {
+		t.Error("unmapped key is not recorded")
+	}
+}
+
+// Waits until sink received report want as its last report
+func waitReport(t *testing.T, sink *testSink, want []byte) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		sink.mu.Lock()
+		n := len(sink.reps)
+		ok := n > 0 && bytes.Equal(sink.reps[n-1], want)
+		sink.mu.Unlock()
+		if ok {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("report % x was not sent; got % x", want, sink.reps)
+}
+
+func TestKeyboardMaxHold(t *testing.T) {
+	sink := &testSink{}
+	k := testKeyboard(sink, KeyboardConfig{MaxHold: 50 * time.Millisecond})
+	go k.loop()
+	defer k.StopProcess()
+
+	k.intr <- keyEvent(evdev.KEY_LEFTSHIFT, true)
+	k.intr <- keyEvent(evdev.KEY_A, true)
+	waitReport(t, sink, kbdReport(MODLSHIFT, 0x04))
+
+	// autorepeat keeps keys held
+	for i := 0; i < 5; i++ {
+		time.Sleep(20 * time.Millisecond)
+		k.intr <- &evdev.InputEvent{Type: evdev.EV_KEY, Code: evdev.KEY_A, Value: int32(evdev.KeyHold)}
+	}
+	sink.mu.Lock()
+	n := len(sink.reps)
+	sink.mu.Unlock()
+	if n != 2 {
+		t.Fatalf("keys were released while repeating: % x", sink.reps)
+	}
+
+	waitReport(t, sink, kbdReport(0))
+}
+
+func TestKeyboardReleaseAll(t *testing.T) {
+	sink := &testSink{}
+	k := testKeyboard(sink, KeyboardConfig{})
+	go k.loop()
+
+	k.intr <- keyEvent(evdev.KEY_LEFTCTRL, true)
+	waitReport(t, sink, kbdReport(MODLCTRL))
+	k.ReleaseAll()
+	waitReport(t, sink, kbdReport(0))
+
+	// the released key is not sent again by a later key
+	k.intr <- keyEvent(evdev.KEY_B, true)
+	waitReport(t, sink, kbdReport(0, 0x05))
+
+	k.StopProcess()
+	waitReport(t, sink, kbdReport(0))
+}
+
+func TestKeyboardAllocs(t *testing.T) {
+	k := fullKeyboard(discardSink{})
+	down, up := keyEvent(evdev.KEY_A, true), keyEvent(evdev.KEY_A, false)
+	allocs := testing.AllocsPerRun(100, func() {
+		k.changeState(down)
+		k.changeState(up)
+	})
+	if allocs != 0 {
+		t.Errorf("changeState allocates %v times per key", allocs)
+	}
+}
+
+func BenchmarkKeyboardChangeState(b *testing.B) {
+	k := testKeyboard(discardSink{}, KeyboardConfig{})
+	down, up := keyEvent(evdev.KEY_A, true), keyEvent(evdev.KEY_A, false)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		k.changeState(down)
+		k.changeState(up)
+	}
+}
+
+func BenchmarkKeyboardChangeStateFull(b *testing.B) {
+	k := fullKeyboard(discardSink{})
+	down, up := keyEvent(evdev.KEY_A, true), keyEvent(evdev.KEY_A, false)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		k.changeState(down)
+		k.changeState(up)
+	}
+}
@@ -0,0 +1,228 @@
+package hid
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gvalkov/golang-evdev"
+)
+
+// Combination of evdev key codes which has to be held at once
+type Chord []uint16
+
+type hotkey struct {
+	chord Chord
+	fn    func()
+}
+
+// Key which has to be tapped taps times within interval
+type tapHotkey struct {
+	code     uint16
+	taps     int
+	interval time.Duration
+	fn       func()
+
+	count int
+	last  time.Time
+}
+
+// Hotkeys shared by keyboards
+// Key event which completes a registered chord is not forwarded to the host
+// Tapped keys are forwarded as usual
+type Hotkeys struct {
+	mu      sync.Mutex
+	hotkeys []hotkey
+	taps    []*tapHotkey
+	// Receives key presses instead of hosts while set
+	capture chan<- uint16
+}
+
+func NewHotkeys() *Hotkeys {
+	return &Hotkeys{}
+}
+
+// Forgets every registered hotkey; e.g. before registering them again on configuration reload
+func (h *Hotkeys) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hotkeys = nil
+	h.taps = nil
+}
+
+// Registers fn to be called when chord is pressed
+// fn is called on its own goroutine
+func (h *Hotkeys) Register(c Chord, fn func()) {
+	if len(c) == 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hotkeys = append(h.hotkeys, hotkey{chord: c, fn: fn})
+}
+
+// Registers fn to be called when code is tapped taps times within interval
+// e.g. double tap of Scroll Lock
+func (h *Hotkeys) RegisterTap(code uint16, taps int, interval time.Duration, fn func()) {
+	if taps < 1 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.taps = append(h.taps, &tapHotkey{code: code, taps: taps, interval: interval, fn: fn})
+}
+
+// Passes key presses of every keyboard to keys instead of hosts until release is called
+// e.g. passkey typed while keyboards are grabbed. Presses are dropped when keys is full
+func (h *Hotkeys) Capture(keys chan<- uint16) (release func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.capture = keys
+	return func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.capture == keys {
+			h.capture = nil
+		}
+	}
+}
+
+// Reports whether key press of code is captured
+func (h *Hotkeys) captured(code uint16) bool {
+	if h == nil {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.capture == nil {
+		return false
+	}
+	select {
+	case h.capture <- code:
+	default:
+	}
+	return true
+}
+
+// Reports whether pressing code at t along with already pressed keys completes a chord
+func (h *Hotkeys) trigger(pressed *[KEYCNT]bool, code uint16, t time.Time) bool {
+	if h == nil {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, tk := range h.taps {
+		if tk.tap(code, t) {
+			go tk.fn()
+		}
+	}
+
+	fired := false
+	for _, hk := range h.hotkeys {
+		if hk.chord.complete(pressed, code) {
+			go hk.fn()
+			fired = true
+		}
+	}
+	return fired
+}
+
+func (tk *tapHotkey) tap(code uint16, t time.Time) bool {
+	if code != tk.code {
+		tk.count = 0
+		return false
+	}
+
+	if tk.count == 0 || t.Sub(tk.last) > tk.interval {
+		tk.count = 0
+	}
+	tk.count++
+	tk.last = t
+
+	if tk.count < tk.taps {
+		return false
+	}
+	tk.count = 0
+	return true
+}
+
+func (c Chord) complete(pressed *[KEYCNT]bool, code uint16) bool {
+	last := false
+	for _, k := range c {
+		switch {
+		case k == code:
+			last = true
+		case int(k) >= len(pressed) || !pressed[k]:
+			return false
+		}
+	}
+	return last
+}
+
+// Parses chord such as "KEY_LEFTCTRL+KEY_LEFTALT+KEY_D"; "KEY_" prefix can be omitted
+func ParseChord(s string) (Chord, error) {
+	var c Chord
+	for _, n := range strings.Split(s, "+") {
+		code, ok := KeyCode(n)
+		if !ok {
+			return nil, &DeviceError{msg: "unknown key name " + n, method: "ParseChord()"}
+		}
+		c = append(c, code)
+	}
+	return c, nil
+}
+
+// Length of per keyboard pressed keys table(KEY_CNT in linux/input-event-codes.h)
+const KEYCNT = 0x300
+
+var btnCodes = map[string]uint16{
+	"BTN_LEFT":    evdev.BTN_LEFT,
+	"BTN_RIGHT":   evdev.BTN_RIGHT,
+	"BTN_MIDDLE":  evdev.BTN_MIDDLE,
+	"BTN_SIDE":    evdev.BTN_SIDE,
+	"BTN_EXTRA":   evdev.BTN_EXTRA,
+	"BTN_FORWARD": evdev.BTN_FORWARD,
+	"BTN_BACK":    evdev.BTN_BACK,
+	"BTN_TASK":    evdev.BTN_TASK,
+}
+
+var (
+	keyCodesOnce sync.Once
+	keyCodes     map[string]uint16
+)
+
+// Resolves evdev key code by its name; "KEY_" prefix can be omitted
+func KeyCode(name string) (uint16, bool) {
+	keyCodesOnce.Do(func() {
+		keyCodes = make(map[string]uint16, len(evdev.KEY))
+		for code, n := range evdev.KEY {
+			keyCodes[n] = uint16(code)
+		}
+		// button codes aliased by other names in evdev.KEY(e.g. BTN_MOUSE)
+		for n, code := range btnCodes {
+			keyCodes[n] = code
+		}
+	})
+
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if code, ok := keyCodes[name]; ok {
+		return code, true
+	}
+	code, ok := keyCodes["KEY_"+name]
+	return code, ok
+}
+
+// Name of evdev key code such as "KEY_A"; KeyCode(KeyName(code)) gives code back
+func KeyName(code uint16) string {
+	for n, c := range btnCodes {
+		if c == code {
+			return n
+		}
+	}
+	if n, ok := evdev.KEY[int(code)]; ok {
+		return n
+	}
+	return fmt.Sprintf("0x%x", code)
+}
@@ -0,0 +1,86 @@
+package hid
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gvalkov/golang-evdev"
+)
+
+func key(k *Keyboard, code uint16, down bool, t time.Time) bool {
+	return k.handleHotkeys(code, down, t)
+}
+
+func fired(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	case <-time.After(time.Second):
+		return false
+	}
+}
+
+func TestHotkeyChord(t *testing.T) {
+	h := NewHotkeys()
+	c := make(chan struct{}, 1)
+	h.Register(Chord{evdev.KEY_LEFTCTRL, evdev.KEY_LEFTALT, evdev.KEY_1}, func() { c <- struct{}{} })
+	k := &Keyboard{hotkeys: h}
+	now := time.Now()
+
+	// keys of the chord pressed before its last key are forwarded
+	if key(k, evdev.KEY_LEFTCTRL, true, now) || key(k, evdev.KEY_LEFTALT, true, now) {
+		t.Error("modifier of chord swallowed")
+	}
+	if !key(k, evdev.KEY_1, true, now) {
+		t.Error("key completing chord forwarded")
+	}
+	if !fired(c) {
+		t.Fatal("chord did not fire")
+	}
+	if !key(k, evdev.KEY_1, false, now) {
+		t.Error("release of swallowed key forwarded")
+	}
+	if key(k, evdev.KEY_LEFTALT, false, now) {
+		t.Error("release of modifier swallowed")
+	}
+
+	// incomplete chord is an ordinary key
+	if key(k, evdev.KEY_1, true, now) || key(k, evdev.KEY_1, false, now) {
+		t.Error("key outside chord swallowed")
+	}
+}
+
+func TestHotkeyTap(t *testing.T) {
+	h := NewHotkeys()
+	c := make(chan struct{}, 1)
+	interval := 100 * time.Millisecond
+	h.RegisterTap(evdev.KEY_SCROLLLOCK, 2, interval, func() { c <- struct{}{} })
+	k := &Keyboard{hotkeys: h}
+	now := time.Now()
+
+	tap := func(code uint16, t time.Time) bool {
+		return key(k, code, true, t) || key(k, code, false, t)
+	}
+
+	// too slow
+	tap(evdev.KEY_SCROLLLOCK, now)
+	now = now.Add(2 * interval)
+	tap(evdev.KEY_SCROLLLOCK, now)
+	// interrupted by another key
+	tap(evdev.KEY_A, now)
+	now = now.Add(interval / 2)
+	tap(evdev.KEY_SCROLLLOCK, now)
+	select {
+	case <-c:
+		t.Fatal("tap hotkey fired")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	now = now.Add(interval / 2)
+	if tap(evdev.KEY_SCROLLLOCK, now) {
+		t.Error("tapped key swallowed")
+	}
+	if !fired(c) {
+		t.Error("tap hotkey did not fire")
+	}
+}
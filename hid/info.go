@@ -0,0 +1,102 @@
+package hid
+
+import (
+	"bytes"
+	"unsafe"
+
+	"github.com/gvalkov/golang-evdev"
+
+	"golang.org/x/sys/unix"
+)
+
+type DeviceKind byte
+
+const (
+	DEVNONE DeviceKind = iota
+	DEVKEYBOARD
+	DEVMOUSE
+)
+
+func (k DeviceKind) String() string {
+	switch k {
+	case DEVKEYBOARD:
+		return "keyboard"
+	case DEVMOUSE:
+		return "mouse"
+	}
+	return "none"
+}
+
+// Identity and capabilities of an evdev device
+type DeviceInfo struct {
+	Path    string
+	Name    string
+	Phys    string
+	Uniq    string
+	Bus     uint16
+	Vendor  uint16
+	Product uint16
+	Version uint16
+
+	caps map[int]map[int]bool
+}
+
+// Opens device at path and reads its identity and capabilities
+func ReadDeviceInfo(path string) (*DeviceInfo, error) {
+	dev, err := evdev.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer dev.File.Close()
+
+	info := &DeviceInfo{
+		Path:    path,
+		Name:    dev.Name,
+		Phys:    dev.Phys,
+		Uniq:    readUniq(dev),
+		Bus:     dev.Bustype,
+		Vendor:  dev.Vendor,
+		Product: dev.Product,
+		Version: dev.Version,
+		caps:    make(map[int]map[int]bool),
+	}
+	for typ, codes := range dev.Capabilities {
+		cs := make(map[int]bool, len(codes))
+		for _, c := range codes {
+			cs[c.Code] = true
+		}
+		info.caps[typ.Type] = cs
+	}
+	return info, nil
+}
+
+// Reports whether device can emit event typ with code
+func (di *DeviceInfo) HasCapability(typ, code int) bool {
+	return di.caps[typ][code]
+}
+
+// Guesses device kind from its capabilities
+func (di *DeviceInfo) Kind() DeviceKind {
+	switch {
+	case di.HasCapability(evdev.EV_KEY, evdev.KEY_A) && di.HasCapability(evdev.EV_KEY, evdev.KEY_Z) && di.HasCapability(evdev.EV_KEY, evdev.KEY_SPACE):
+		return DEVKEYBOARD
+	case di.HasCapability(evdev.EV_REL, evdev.REL_X) && di.HasCapability(evdev.EV_REL, evdev.REL_Y) && di.HasCapability(evdev.EV_KEY, evdev.BTN_LEFT):
+		return DEVMOUSE
+	}
+	return DEVNONE
+}
+
+// EVIOCGUNIQ(len); _IOC(_IOC_READ, 'E', 0x08, len)
+func eviocguniq(l int) uintptr {
+	return uintptr(2<<30 | l<<16 | 'E'<<8 | 0x08)
+}
+
+// Reads unique identifier(e.g. serial number or BD_ADDR); empty when the device has none
+func readUniq(dev *evdev.InputDevice) string {
+	buf := make([]byte, 256)
+	_, _, err := unix.Syscall(unix.SYS_IOCTL, dev.File.Fd(), eviocguniq(len(buf)), uintptr(unsafe.Pointer(&buf[0])))
+	if err != 0 {
+		return ""
+	}
+	return string(bytes.TrimRight(buf, "\x00"))
+}
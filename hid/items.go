@@ -0,0 +1,229 @@
+package hid
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Item types of report descriptor items
+const (
+	ITEMMAIN   = 0
+	ITEMGLOBAL = 1
+	ITEMLOCAL  = 2
+	// Long items(prefix 0xfe) are not used by HID 1.11 devices and are kept undecoded
+	ITEMLONG = 3
+)
+
+// Item of a report descriptor
+type Item struct {
+	Offset int
+	Type   byte
+	Tag    byte
+	// Raw bytes of the item including its prefix
+	Raw []byte
+	// Data bytes; little endian
+	Data []byte
+}
+
+// Data as unsigned value
+func (it Item) Uint() uint32 {
+	var v uint32
+	for i, b := range it.Data {
+		v |= uint32(b) << (8 * uint(i))
+	}
+	return v
+}
+
+// Data as two's complement value; used by logical and physical extents
+func (it Item) Int() int32 {
+	v := it.Uint()
+	switch len(it.Data) {
+	case 1:
+		return int32(int8(v))
+	case 2:
+		return int32(int16(v))
+	}
+	return int32(v)
+}
+
+// Splits report descriptor into items
+func ParseItems(desc []byte) ([]Item, error) {
+	var items []Item
+	for off := 0; off < len(desc); {
+		prefix := desc[off]
+		if prefix == 0xfe {
+			if off+2 >= len(desc) {
+				return items, &DescriptorError{msg: "truncated long item", offset: off}
+			}
+			n := 3 + int(desc[off+1])
+			if off+n > len(desc) {
+				return items, &DescriptorError{msg: "truncated long item", offset: off}
+			}
+			items = append(items, Item{Offset: off, Type: ITEMLONG, Tag: desc[off+2], Raw: desc[off : off+n], Data: desc[off+3 : off+n]})
+			off += n
+			continue
+		}
+
+		size := int(prefix & 0x03)
+		if size == 3 {
+			size = 4
+		}
+		if off+1+size > len(desc) {
+			return items, &DescriptorError{msg: "truncated item", offset: off}
+		}
+		items = append(items, Item{
+			Offset: off,
+			Type:   (prefix >> 2) & 0x03,
+			Tag:    prefix >> 4,
+			Raw:    desc[off : off+1+size],
+			Data:   desc[off+1 : off+1+size],
+		})
+		off += 1 + size
+	}
+	return items, nil
+}
+
+var mainTags = map[byte]string{0x8: "Input", 0x9: "Output", 0xa: "Collection", 0xb: "Feature", 0xc: "End Collection"}
+
+var globalTags = map[byte]string{
+	0x0: "Usage Page", 0x1: "Logical Minimum", 0x2: "Logical Maximum", 0x3: "Physical Minimum",
+	0x4: "Physical Maximum", 0x5: "Unit Exponent", 0x6: "Unit", 0x7: "Report Size",
+	0x8: "Report ID", 0x9: "Report Count", 0xa: "Push", 0xb: "Pop",
+}
+
+var localTags = map[byte]string{
+	0x0: "Usage", 0x1: "Usage Minimum", 0x2: "Usage Maximum", 0x3: "Designator Index",
+	0x4: "Designator Minimum", 0x5: "Designator Maximum", 0x7: "String Index",
+	0x8: "String Minimum", 0x9: "String Maximum", 0xa: "Delimiter",
+}
+
+var usagePages = map[uint32]string{
+	0x01: "Generic Desktop", 0x07: "Keyboard", 0x08: "LEDs", 0x09: "Button", 0x0c: "Consumer",
+}
+
+var desktopUsages = map[uint32]string{
+	0x01: "Pointer", 0x02: "Mouse", 0x04: "Joystick", 0x05: "Game Pad", 0x06: "Keyboard", 0x07: "Keypad",
+	0x30: "X", 0x31: "Y", 0x32: "Z", 0x38: "Wheel",
+}
+
+var collections = map[uint32]string{0x00: "Physical", 0x01: "Application", 0x02: "Logical", 0x03: "Report"}
+
+// Writes items one per line with raw bytes and meaning, indented by collection
+//
+//	05 01         Usage Page (Generic Desktop)
+//	a1 01         Collection (Application)
+//	85 01           Report ID (1)
+func FormatItems(w io.Writer, items []Item) error {
+	depth := 0
+	var page uint32
+	for _, it := range items {
+		if it.Type == ITEMMAIN && it.Tag == 0xc && depth > 0 {
+			depth--
+		}
+		if it.Type == ITEMGLOBAL && it.Tag == 0x0 {
+			page = it.Uint()
+		}
+		if _, err := fmt.Fprintf(w, "%-14s%s%s\n", fmt.Sprintf("% x", it.Raw), strings.Repeat("  ", depth), describeItem(it, page)); err != nil {
+			return err
+		}
+		if it.Type == ITEMMAIN && it.Tag == 0xa {
+			depth++
+		}
+	}
+	return nil
+}
+
+// Name and value of item; page is the current usage page for usages
+func describeItem(it Item, page uint32) string {
+	var names map[byte]string
+	switch it.Type {
+	case ITEMMAIN:
+		names = mainTags
+	case ITEMGLOBAL:
+		names = globalTags
+	case ITEMLOCAL:
+		names = localTags
+	default:
+		return fmt.Sprintf("Long Item (tag 0x%02x, %d bytes)", it.Tag, len(it.Data))
+	}
+	name, ok := names[it.Tag]
+	if !ok {
+		return fmt.Sprintf("Reserved (type %d, tag 0x%x, 0x%x)", it.Type, it.Tag, it.Uint())
+	}
+	if len(it.Data) == 0 {
+		return name
+	}
+
+	v := it.Uint()
+	var desc string
+	switch {
+	case it.Type == ITEMMAIN && it.Tag == 0xa:
+		desc = collections[v]
+	case it.Type == ITEMMAIN:
+		desc = mainFlags(v)
+	case it.Type == ITEMGLOBAL && it.Tag == 0x0:
+		desc = usagePages[v]
+		if v >= 0xff00 {
+			desc = fmt.Sprintf("Vendor Defined 0x%04X", v)
+		}
+	case it.Type == ITEMGLOBAL && (it.Tag >= 0x1 && it.Tag <= 0x5):
+		desc = fmt.Sprint(it.Int())
+	case it.Type == ITEMLOCAL && it.Tag <= 0x2:
+		desc = usageName(page, v)
+	}
+	if desc == "" {
+		desc = fmt.Sprint(v)
+	}
+	return name + " (" + desc + ")"
+}
+
+func usageName(page, v uint32) string {
+	switch {
+	case page == 0x01:
+		return desktopUsages[v]
+	case page == 0x07 && v >= uint32(USAGEMODMIN) && v <= uint32(USAGEMODMAX):
+		return Usage(v).String()
+	case page >= 0xff00:
+		return fmt.Sprintf("Vendor Usage %d", v)
+	}
+	return ""
+}
+
+// Flags of Input, Output and Feature items
+func mainFlags(v uint32) string {
+	bits := [][2]string{
+		{"Data", "Constant"},
+		{"Array", "Variable"},
+		{"Absolute", "Relative"},
+		{"No Wrap", "Wrap"},
+		{"Linear", "Non Linear"},
+		{"Preferred State", "No Preferred"},
+		{"No Null Position", "Null State"},
+		{"Non Volatile", "Volatile"},
+		{"Bit Field", "Buffered Bytes"},
+	}
+	var fs []string
+	for i, b := range bits {
+		set := v&(1<<uint(i)) != 0
+		// defaults past Absolute are left out
+		if i > 2 && !set {
+			continue
+		}
+		if set {
+			fs = append(fs, b[1])
+		} else {
+			fs = append(fs, b[0])
+		}
+	}
+	return strings.Join(fs, ", ")
+}
+
+type DescriptorError struct {
+	msg    string
+	offset int
+}
+
+func (e *DescriptorError) Error() string {
+	return fmt.Sprintf("DescriptorError: '%s' offset: %d", e.msg, e.offset)
+}
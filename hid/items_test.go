@@ -0,0 +1,45 @@
+package hid
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseItems(t *testing.T) {
+	items, err := ParseItems(ReportDescriptor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, depth := 0, 0
+	for _, it := range items {
+		n += len(it.Raw)
+		if it.Type == ITEMMAIN && it.Tag == 0xa {
+			depth++
+		}
+		if it.Type == ITEMMAIN && it.Tag == 0xc {
+			depth--
+		}
+	}
+	if n != len(ReportDescriptor) || depth != 0 {
+		t.Errorf("items cover %d of %d bytes; collection depth %d", n, len(ReportDescriptor), depth)
+	}
+
+	var b bytes.Buffer
+	FormatItems(&b, items)
+	for _, want := range []string{
+		"15 81             Logical Minimum (-127)\n",
+		"26 ff 00          Logical Maximum (255)\n",
+		"19 e0             Usage Minimum (KEY_LEFTCTRL)\n",
+		"06 00 ff      Usage Page (Vendor Defined 0xFF00)\n",
+		"81 06             Input (Data, Variable, Relative)\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("decoded descriptor lacks %q", want)
+		}
+	}
+
+	if _, err := ParseItems([]byte{0x05, 0x01, 0x26, 0xff}); err == nil {
+		t.Error("truncated item parsed")
+	}
+}
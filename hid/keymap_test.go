@@ -1,6 +1,10 @@
 package hid
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/gvalkov/golang-evdev"
+)
 
 func TestKeymapConvert(t *testing.T) {
 	if k, mk := Convert("KEY_A"); mk == MOD {
@@ -11,3 +15,69 @@ func TestKeymapConvert(t *testing.T) {
 		t.Error("KEY_RIGHTMETA is not a function key: got ", mk, k)
 	}
 }
+
+func TestUsageTableRoundTrip(t *testing.T) {
+	for code, u := range keyUsages {
+		if u == 0 {
+			continue
+		}
+		if c, ok := UsageCode(u); !ok || int(c) != code {
+			t.Errorf("usage %#02x of %s resolves to %d", byte(u), evdev.KEY[code], c)
+		}
+	}
+	for u, code := range usageKeys {
+		if code == 0 {
+			continue
+		}
+		back, kind := Lookup(code)
+		if kind == UNKNOWN {
+			t.Errorf("%s of usage %#02x has no usage", evdev.KEY[int(code)], u)
+			continue
+		}
+		if usageKeys[back] != code {
+			t.Errorf("%s of usage %#02x converts to usage %v", evdev.KEY[int(code)], u, back)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		code  uint16
+		usage Usage
+		kind  KeyKind
+	}{
+		{evdev.KEY_A, 0x04, FUNC},
+		{evdev.KEY_BACKSLASH, 0x31, FUNC},
+		{evdev.KEY_F24, 0x73, FUNC},
+		{evdev.KEY_MUTE, 0x7f, FUNC},
+		{evdev.KEY_RO, 0x87, FUNC},
+		{evdev.KEY_KATAKANAHIRAGANA, 0x88, FUNC},
+		{evdev.KEY_HANGEUL, 0x90, FUNC},
+		{evdev.KEY_KPLEFTPAREN, 0xb6, FUNC},
+		{evdev.KEY_PLAYPAUSE, 0xe8, FUNC},
+		{evdev.KEY_LEFTCTRL, 0xe0, MOD},
+		{evdev.KEY_RIGHTMETA, 0xe7, MOD},
+		{evdev.KEY_RESERVED, 0, UNKNOWN},
+		{evdev.BTN_LEFT, 0, UNKNOWN},
+		{KEYCNT + 1, 0, UNKNOWN},
+	}
+	for _, tt := range tests {
+		if u, kind := Lookup(tt.code); u != tt.usage || kind != tt.kind {
+			t.Errorf("Lookup(%d) = %v, %v; want %v, %v", tt.code, u, kind, tt.usage, tt.kind)
+		}
+	}
+
+	if bit := Usage(0xe5).ModBit(); bit != MODRSHIFT {
+		t.Errorf("ModBit of right shift = %#x", bit)
+	}
+	if k, mk := Convert("KEY_RIGHTMETA"); k != 7 || mk != MOD {
+		t.Errorf("Convert(KEY_RIGHTMETA) = %d, %v", k, mk)
+	}
+}
+
+func BenchmarkLookup(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Lookup(uint16(i % KEYCNT))
+	}
+}
@@ -0,0 +1,458 @@
+package hid
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gvalkov/golang-evdev"
+)
+
+const MAXLAYERS = 16
+
+type ActionKind byte
+
+const (
+	// Falls through to the next lower active layer
+	ACTTRANS ActionKind = iota
+	// Sends Code
+	ACTKEY
+	// Activates Layer while held
+	ACTMOMENTARY
+	// Toggles Layer on press
+	ACTTOGGLE
+	// Sends Code on tap, holds modifier Hold on hold
+	ACTMODTAP
+	// Sends Code on tap, activates Layer on hold
+	ACTLAYERTAP
+	// Modifier Code applied to the next key pressed after tapping
+	ACTONESHOT
+)
+
+type Action struct {
+	Kind  ActionKind
+	Code  uint16
+	Hold  uint16
+	Layer int
+}
+
+func (a Action) String() string {
+	switch a.Kind {
+	case ACTKEY:
+		return keyName(a.Code)
+	case ACTMOMENTARY:
+		return fmt.Sprintf("MO(%d)", a.Layer)
+	case ACTTOGGLE:
+		return fmt.Sprintf("TG(%d)", a.Layer)
+	case ACTMODTAP:
+		return fmt.Sprintf("MT(%s,%s)", keyName(a.Hold), keyName(a.Code))
+	case ACTLAYERTAP:
+		return fmt.Sprintf("LT(%d,%s)", a.Layer, keyName(a.Code))
+	case ACTONESHOT:
+		return fmt.Sprintf("OSM(%s)", keyName(a.Code))
+	}
+	return "TRNS"
+}
+
+func keyName(code uint16) string {
+	if n, ok := evdev.KEY[int(code)]; ok {
+		return n
+	}
+	return strconv.Itoa(int(code))
+}
+
+// Parses QMK-like action
+//
+//	KEY_A                      key
+//	TRNS or _                  falls through
+//	MO(1), TG(1)               momentary and toggled layer
+//	MT(KEY_LEFTSHIFT,KEY_SPACE) Shift on hold, Space on tap
+//	LT(1,KEY_ESC)              layer 1 on hold, Esc on tap
+//	OSM(KEY_LEFTSHIFT)         one-shot Shift
+func ParseAction(s string) (Action, error) {
+	s = strings.TrimSpace(s)
+	if s == "_" || strings.ToUpper(s) == "TRNS" {
+		return Action{Kind: ACTTRANS}, nil
+	}
+
+	open := strings.Index(s, "(")
+	if open < 0 {
+		code, ok := KeyCode(s)
+		if !ok {
+			return Action{}, fmt.Errorf("unknown key %q", s)
+		}
+		return Action{Kind: ACTKEY, Code: code}, nil
+	}
+	if !strings.HasSuffix(s, ")") {
+		return Action{}, fmt.Errorf("unterminated action %q", s)
+	}
+
+	fn := strings.ToUpper(strings.TrimSpace(s[:open]))
+	args := strings.Split(s[open+1:len(s)-1], ",")
+	for i := range args {
+		args[i] = strings.TrimSpace(args[i])
+	}
+
+	var a Action
+	var err error
+	switch {
+	case fn == "MO" && len(args) == 1:
+		a.Kind = ACTMOMENTARY
+		a.Layer, err = parseLayer(args[0])
+	case fn == "TG" && len(args) == 1:
+		a.Kind = ACTTOGGLE
+		a.Layer, err = parseLayer(args[0])
+	case fn == "MT" && len(args) == 2:
+		a.Kind = ACTMODTAP
+		if a.Hold, err = parseModifier(args[0]); err == nil {
+			a.Code, err = parseKey(args[1])
+		}
+	case fn == "LT" && len(args) == 2:
+		a.Kind = ACTLAYERTAP
+		if a.Layer, err = parseLayer(args[0]); err == nil {
+			a.Code, err = parseKey(args[1])
+		}
+	case fn == "OSM" && len(args) == 1:
+		a.Kind = ACTONESHOT
+		a.Code, err = parseModifier(args[0])
+	default:
+		err = fmt.Errorf("unknown action %q", s)
+	}
+	return a, err
+}
+
+func parseLayer(s string) (int, error) {
+	l, err := strconv.Atoi(s)
+	if err != nil || l < 0 || l >= MAXLAYERS {
+		return 0, fmt.Errorf("layer must be 0..%d: %q", MAXLAYERS-1, s)
+	}
+	return l, nil
+}
+
+func parseKey(s string) (uint16, error) {
+	code, ok := KeyCode(s)
+	if !ok {
+		return 0, fmt.Errorf("unknown key %q", s)
+	}
+	return code, nil
+}
+
+func parseModifier(s string) (uint16, error) {
+	code, err := parseKey(s)
+	if err == nil && !isModifier(code) {
+		err = fmt.Errorf("not a modifier %q", s)
+	}
+	return code, err
+}
+
+func isModifier(code uint16) bool {
+	switch code {
+	case evdev.KEY_LEFTCTRL, evdev.KEY_LEFTSHIFT, evdev.KEY_LEFTALT, evdev.KEY_LEFTMETA,
+		evdev.KEY_RIGHTCTRL, evdev.KEY_RIGHTSHIFT, evdev.KEY_RIGHTALT, evdev.KEY_RIGHTMETA:
+		return true
+	}
+	return false
+}
+
+// Actions keyed by evdev key code; keys not in a layer fall through
+type Layer map[uint16]Action
+
+type LayerConfig struct {
+	// Layer 0 is the base layer and always active
+	Layers map[int]Layer
+
+	// Dual-role keys held longer than this act as hold
+	TappingTerm time.Duration
+	// Dual-role keys act as hold when another key is pressed and released while held
+	PermissiveHold bool
+	// Armed one-shot modifiers are dropped after this; zero keeps them until used
+	OneShotTimeout time.Duration
+}
+
+func DefaultLayerConfig() LayerConfig {
+	return LayerConfig{
+		Layers:         make(map[int]Layer),
+		TappingTerm:    200 * time.Millisecond,
+		PermissiveHold: true,
+		OneShotTimeout: 3 * time.Second,
+	}
+}
+
+// Key transition before and after layer processing
+type KeyInput struct {
+	Code uint16
+	Down bool
+	Time time.Time
+}
+
+// Timed key processing stage resolving layers, dual-role keys and one-shot modifiers
+// Not safe for concurrent use; each keyboard has its own
+type Processor struct {
+	cfg    LayerConfig
+	layers [MAXLAYERS]Layer
+
+	momentary [MAXLAYERS]int
+	toggled   [MAXLAYERS]bool
+
+	// Action resolved on press; ACTTRANS when not held
+	held [KEYCNT]Action
+
+	// Dual-role key waiting for tap or hold decision; later keys are queued meanwhile
+	pending  bool
+	pendCode uint16
+	pendAct  Action
+	pendTime time.Time
+	queue    []KeyInput
+
+	// One-shot modifier held down; used becomes true when another key is pressed meanwhile
+	osmCode uint16
+	osmUsed bool
+	// Armed one-shot modifier and the key it is wrapped around
+	armed     uint16
+	armedTime time.Time
+	wrapped   uint16
+	wrapMod   uint16
+
+	now time.Time
+	out []KeyInput
+}
+
+func NewProcessor(cfg LayerConfig) *Processor {
+	p := &Processor{
+		cfg:   cfg,
+		queue: make([]KeyInput, 0, 16),
+		out:   make([]KeyInput, 0, 16),
+	}
+	for l, layer := range cfg.Layers {
+		if l >= 0 && l < MAXLAYERS {
+			p.layers[l] = layer
+		}
+	}
+	return p
+}
+
+// Processes key transition; returned slice is reused by the next call
+func (p *Processor) Process(ev KeyInput) []KeyInput {
+	p.out = p.out[:0]
+	p.now = ev.Time
+	p.expireOneShot(ev.Time)
+	p.feed(ev)
+	return p.out
+}
+
+// Resolves pending dual-role key and one-shot timeout at now
+func (p *Processor) Tick(now time.Time) []KeyInput {
+	p.out = p.out[:0]
+	p.now = now
+	if p.pending && now.Sub(p.pendTime) >= p.cfg.TappingTerm {
+		p.resolve(true)
+	}
+	p.expireOneShot(now)
+	return p.out
+}
+
+// Time Tick should be called at; false when nothing is waiting
+func (p *Processor) Deadline() (time.Time, bool) {
+	switch {
+	case p.pending:
+		return p.pendTime.Add(p.cfg.TappingTerm), true
+	case p.armed != 0 && p.cfg.OneShotTimeout > 0:
+		return p.armedTime.Add(p.cfg.OneShotTimeout), true
+	}
+	return time.Time{}, false
+}
+
+// Forgets held keys, momentary layers, pending dual-role keys and one-shot modifiers
+// Toggled layers stay active; used after every key is released on the host
+func (p *Processor) Reset() {
+	p.momentary = [MAXLAYERS]int{}
+	p.held = [KEYCNT]Action{}
+	p.pending = false
+	p.queue = p.queue[:0]
+	p.osmCode, p.osmUsed = 0, false
+	p.armed, p.wrapped, p.wrapMod = 0, 0, 0
+}
+
+// Active layers as bits; bit 0(base layer) is always set
+func (p *Processor) ActiveLayers() uint32 {
+	bits := uint32(1)
+	for l := 1; l < MAXLAYERS; l++ {
+		if p.active(l) {
+			bits |= 1 << uint(l)
+		}
+	}
+	return bits
+}
+
+func (p *Processor) active(l int) bool {
+	return l == 0 || p.momentary[l] > 0 || p.toggled[l]
+}
+
+func (p *Processor) lookup(code uint16) Action {
+	for l := MAXLAYERS - 1; l >= 0; l-- {
+		if !p.active(l) {
+			continue
+		}
+		if a, ok := p.layers[l][code]; ok && a.Kind != ACTTRANS {
+			return a
+		}
+	}
+	return Action{Kind: ACTKEY, Code: code}
+}
+
+func (p *Processor) feed(ev KeyInput) {
+	if p.pending {
+		p.queue = append(p.queue, ev)
+		p.decide()
+		return
+	}
+	p.handle(ev)
+}
+
+// Decides pending dual-role key from queued events
+func (p *Processor) decide() {
+	for i, ev := range p.queue {
+		switch {
+		case ev.Time.Sub(p.pendTime) >= p.cfg.TappingTerm:
+			p.resolve(true)
+			return
+		case ev.Code == p.pendCode && !ev.Down:
+			p.resolve(false)
+			return
+		case p.cfg.PermissiveHold && !ev.Down && p.pressedInQueue(ev.Code, i):
+			p.resolve(true)
+			return
+		}
+	}
+}
+
+func (p *Processor) pressedInQueue(code uint16, before int) bool {
+	for _, ev := range p.queue[:before] {
+		if ev.Code == code && ev.Down {
+			return true
+		}
+	}
+	return false
+}
+
+// Applies pending dual-role key as hold or tap and replays queued events
+func (p *Processor) resolve(hold bool) {
+	p.pending = false
+	code, act := p.pendCode, p.pendAct
+
+	switch {
+	case !hold:
+		p.held[code] = Action{Kind: ACTKEY, Code: act.Code}
+		p.press(act.Code)
+	case act.Kind == ACTMODTAP:
+		p.held[code] = Action{Kind: ACTKEY, Code: act.Hold}
+		p.press(act.Hold)
+	case act.Kind == ACTLAYERTAP:
+		p.held[code] = Action{Kind: ACTMOMENTARY, Layer: act.Layer}
+		p.momentary[act.Layer]++
+	}
+
+	// a queued dual-role key may become pending again
+	for len(p.queue) > 0 && !p.pending {
+		ev := p.queue[0]
+		n := copy(p.queue, p.queue[1:])
+		p.queue = p.queue[:n]
+		p.handle(ev)
+	}
+	if p.pending {
+		p.decide()
+	}
+}
+
+func (p *Processor) handle(ev KeyInput) {
+	code := ev.Code
+	if int(code) >= KEYCNT {
+		p.emit(code, ev.Down)
+		return
+	}
+
+	if !ev.Down {
+		act := p.held[code]
+		p.held[code] = Action{}
+		switch act.Kind {
+		case ACTKEY:
+			p.release(act.Code)
+		case ACTMOMENTARY:
+			if p.momentary[act.Layer] > 0 {
+				p.momentary[act.Layer]--
+			}
+		case ACTONESHOT:
+			p.releaseOneShot(act.Code, ev.Time)
+		case ACTTRANS:
+			// pressed before processing started
+			p.emit(code, false)
+		}
+		return
+	}
+
+	act := p.lookup(code)
+	switch act.Kind {
+	case ACTKEY:
+		p.held[code] = act
+		p.press(act.Code)
+	case ACTMOMENTARY:
+		p.held[code] = act
+		p.momentary[act.Layer]++
+	case ACTTOGGLE:
+		p.held[code] = act
+		p.toggled[act.Layer] = !p.toggled[act.Layer]
+	case ACTMODTAP, ACTLAYERTAP:
+		p.pending = true
+		p.pendCode = code
+		p.pendAct = act
+		p.pendTime = ev.Time
+	case ACTONESHOT:
+		p.held[code] = act
+		p.osmCode = act.Code
+		p.osmUsed = false
+		p.emit(act.Code, true)
+	}
+}
+
+func (p *Processor) press(code uint16) {
+	if p.osmCode != 0 {
+		p.osmUsed = true
+	}
+	if p.armed != 0 && !isModifier(code) {
+		p.wrapMod = p.armed
+		p.wrapped = code
+		p.armed = 0
+		p.emit(p.wrapMod, true)
+	}
+	p.emit(code, true)
+}
+
+func (p *Processor) release(code uint16) {
+	p.emit(code, false)
+	if p.wrapped == code && p.wrapMod != 0 {
+		p.emit(p.wrapMod, false)
+		p.wrapMod = 0
+		p.wrapped = 0
+	}
+}
+
+// Tapped one-shot modifier is armed for the next key; used as a plain modifier otherwise
+func (p *Processor) releaseOneShot(code uint16, t time.Time) {
+	p.emit(code, false)
+	if p.osmCode == code && !p.osmUsed {
+		p.armed = code
+		p.armedTime = t
+	}
+	p.osmCode = 0
+}
+
+func (p *Processor) expireOneShot(now time.Time) {
+	if p.armed != 0 && p.cfg.OneShotTimeout > 0 && now.Sub(p.armedTime) >= p.cfg.OneShotTimeout {
+		p.armed = 0
+	}
+}
+
+func (p *Processor) emit(code uint16, down bool) {
+	p.out = append(p.out, KeyInput{Code: code, Down: down, Time: p.now})
+}
@@ -0,0 +1,172 @@
+package hid
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gvalkov/golang-evdev"
+)
+
+var t0 = time.Unix(1500000000, 0)
+
+func at(ms int) time.Time {
+	return t0.Add(time.Duration(ms) * time.Millisecond)
+}
+
+type keyStep struct {
+	code uint16
+	down bool
+	ms   int
+}
+
+func testProcessor() *Processor {
+	cfg := DefaultLayerConfig()
+	cfg.Layers[0] = Layer{
+		evdev.KEY_SPACE:     {Kind: ACTMODTAP, Hold: evdev.KEY_LEFTSHIFT, Code: evdev.KEY_SPACE},
+		evdev.KEY_CAPSLOCK:  {Kind: ACTLAYERTAP, Layer: 1, Code: evdev.KEY_ESC},
+		evdev.KEY_RIGHTALT:  {Kind: ACTMOMENTARY, Layer: 1},
+		evdev.KEY_F12:       {Kind: ACTTOGGLE, Layer: 1},
+		evdev.KEY_LEFTSHIFT: {Kind: ACTONESHOT, Code: evdev.KEY_LEFTSHIFT},
+	}
+	cfg.Layers[1] = Layer{
+		evdev.KEY_H: {Kind: ACTKEY, Code: evdev.KEY_LEFT},
+	}
+	return NewProcessor(cfg)
+}
+
+// Feeds steps and returns output as (code, down) pairs
+func run(p *Processor, steps []keyStep) []keyStep {
+	var out []keyStep
+	for _, s := range steps {
+		var ins []KeyInput
+		if s.code == 0 {
+			ins = p.Tick(at(s.ms))
+		} else {
+			ins = p.Process(KeyInput{Code: s.code, Down: s.down, Time: at(s.ms)})
+		}
+		for _, in := range ins {
+			out = append(out, keyStep{code: in.Code, down: in.Down})
+		}
+	}
+	return out
+}
+
+func TestProcessorTapHold(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []keyStep
+		want  []keyStep
+	}{
+		{
+			"tap",
+			[]keyStep{{evdev.KEY_SPACE, true, 0}, {evdev.KEY_SPACE, false, 100}},
+			[]keyStep{{evdev.KEY_SPACE, true, 0}, {evdev.KEY_SPACE, false, 0}},
+		},
+		{
+			"hold by tick",
+			[]keyStep{{evdev.KEY_SPACE, true, 0}, {0, false, 200}, {evdev.KEY_SPACE, false, 300}},
+			[]keyStep{{evdev.KEY_LEFTSHIFT, true, 0}, {evdev.KEY_LEFTSHIFT, false, 0}},
+		},
+		{
+			"hold by later event",
+			[]keyStep{{evdev.KEY_SPACE, true, 0}, {evdev.KEY_A, true, 250}, {evdev.KEY_A, false, 260}, {evdev.KEY_SPACE, false, 300}},
+			[]keyStep{{evdev.KEY_LEFTSHIFT, true, 0}, {evdev.KEY_A, true, 0}, {evdev.KEY_A, false, 0}, {evdev.KEY_LEFTSHIFT, false, 0}},
+		},
+		{
+			"permissive hold",
+			[]keyStep{{evdev.KEY_SPACE, true, 0}, {evdev.KEY_A, true, 50}, {evdev.KEY_A, false, 80}, {evdev.KEY_SPACE, false, 100}},
+			[]keyStep{{evdev.KEY_LEFTSHIFT, true, 0}, {evdev.KEY_A, true, 0}, {evdev.KEY_A, false, 0}, {evdev.KEY_LEFTSHIFT, false, 0}},
+		},
+		{
+			"rolling tap",
+			[]keyStep{{evdev.KEY_SPACE, true, 0}, {evdev.KEY_A, true, 50}, {evdev.KEY_SPACE, false, 80}, {evdev.KEY_A, false, 100}},
+			[]keyStep{{evdev.KEY_SPACE, true, 0}, {evdev.KEY_A, true, 0}, {evdev.KEY_SPACE, false, 0}, {evdev.KEY_A, false, 0}},
+		},
+		{
+			"layer tap hold",
+			[]keyStep{{evdev.KEY_CAPSLOCK, true, 0}, {0, false, 250}, {evdev.KEY_H, true, 300}, {evdev.KEY_CAPSLOCK, false, 350}, {evdev.KEY_H, false, 400}},
+			[]keyStep{{evdev.KEY_LEFT, true, 0}, {evdev.KEY_LEFT, false, 0}},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := run(testProcessor(), tt.steps); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProcessorLayers(t *testing.T) {
+	p := testProcessor()
+	got := run(p, []keyStep{
+		{evdev.KEY_RIGHTALT, true, 0}, {evdev.KEY_H, true, 10}, {evdev.KEY_RIGHTALT, false, 20}, {evdev.KEY_H, false, 30},
+		{evdev.KEY_H, true, 40}, {evdev.KEY_H, false, 50},
+		{evdev.KEY_F12, true, 60}, {evdev.KEY_F12, false, 70}, {evdev.KEY_H, true, 80}, {evdev.KEY_H, false, 90},
+	})
+	want := []keyStep{
+		// released with the key resolved on press though the layer is gone
+		{evdev.KEY_LEFT, true, 0}, {evdev.KEY_LEFT, false, 0},
+		{evdev.KEY_H, true, 0}, {evdev.KEY_H, false, 0},
+		{evdev.KEY_LEFT, true, 0}, {evdev.KEY_LEFT, false, 0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if p.ActiveLayers() != 0x3 {
+		t.Errorf("Toggled layer is not active: %b", p.ActiveLayers())
+	}
+}
+
+func TestProcessorOneShot(t *testing.T) {
+	p := testProcessor()
+	got := run(p, []keyStep{
+		{evdev.KEY_LEFTSHIFT, true, 0}, {evdev.KEY_LEFTSHIFT, false, 10},
+		{evdev.KEY_A, true, 500}, {evdev.KEY_A, false, 510},
+		{evdev.KEY_B, true, 520}, {evdev.KEY_B, false, 530},
+	})
+	want := []keyStep{
+		{evdev.KEY_LEFTSHIFT, true, 0}, {evdev.KEY_LEFTSHIFT, false, 0},
+		{evdev.KEY_LEFTSHIFT, true, 0}, {evdev.KEY_A, true, 0}, {evdev.KEY_A, false, 0}, {evdev.KEY_LEFTSHIFT, false, 0},
+		{evdev.KEY_B, true, 0}, {evdev.KEY_B, false, 0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// expires
+	got = run(p, []keyStep{
+		{evdev.KEY_LEFTSHIFT, true, 1000}, {evdev.KEY_LEFTSHIFT, false, 1010},
+		{0, false, 5000}, {evdev.KEY_A, true, 5010}, {evdev.KEY_A, false, 5020},
+	})
+	want = []keyStep{
+		{evdev.KEY_LEFTSHIFT, true, 0}, {evdev.KEY_LEFTSHIFT, false, 0},
+		{evdev.KEY_A, true, 0}, {evdev.KEY_A, false, 0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expired one-shot: got %v, want %v", got, want)
+	}
+}
+
+func TestParseAction(t *testing.T) {
+	for s, want := range map[string]Action{
+		"KEY_A":                        {Kind: ACTKEY, Code: evdev.KEY_A},
+		"_":                            {Kind: ACTTRANS},
+		"MO(2)":                        {Kind: ACTMOMENTARY, Layer: 2},
+		"tg(3)":                        {Kind: ACTTOGGLE, Layer: 3},
+		"MT(KEY_LEFTSHIFT, KEY_SPACE)": {Kind: ACTMODTAP, Hold: evdev.KEY_LEFTSHIFT, Code: evdev.KEY_SPACE},
+		"LT(1,KEY_ESC)":                {Kind: ACTLAYERTAP, Layer: 1, Code: evdev.KEY_ESC},
+		"OSM(KEY_RIGHTCTRL)":           {Kind: ACTONESHOT, Code: evdev.KEY_RIGHTCTRL},
+	} {
+		got, err := ParseAction(s)
+		if err != nil || got != want {
+			t.Errorf("ParseAction(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+
+	for _, s := range []string{"MO(16)", "MT(KEY_A,KEY_B)", "OSM(KEY_A", "XX(1)"} {
+		if _, err := ParseAction(s); err == nil {
+			t.Error("Invalid action is accepted: ", s)
+		}
+	}
+}
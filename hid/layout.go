@@ -0,0 +1,270 @@
+package hid
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gvalkov/golang-evdev"
+)
+
+// Modifier bits of the keyboard report
+const (
+	MODLCTRL  byte = 0x01
+	MODLSHIFT byte = 0x02
+	MODLALT   byte = 0x04
+	MODLMETA  byte = 0x08
+	MODRCTRL  byte = 0x10
+	MODRSHIFT byte = 0x20
+	MODRALT   byte = 0x40
+	MODRMETA  byte = 0x80
+)
+
+// Key pressed with modifiers to produce a character
+type Stroke struct {
+	Code uint16
+	Mods byte
+}
+
+// HID usage of the key
+func (s Stroke) Usage() Usage {
+	u, _ := Lookup(s.Code)
+	return u
+}
+
+// Character a key produces on the host; dead keys compose with the next key
+type KeySym struct {
+	Rune rune
+	Dead bool
+}
+
+// Host keyboard layout; tells keys typing characters and characters typed by keys
+// Tables are derived from xkeyboard-config symbols of the same name
+type Layout interface {
+	Name() string
+	// Strokes typing r in order; false when r cannot be typed
+	Strokes(r rune) ([]Stroke, bool)
+	// Character typed by usage with modifier bits mods; false for keys typing nothing(e.g. F1 or Ctrl+C)
+	Char(usage Usage, mods byte) (KeySym, bool)
+}
+
+type tableLayout struct {
+	name  string
+	runes map[rune][]Stroke
+	deads map[Stroke]rune
+
+	once sync.Once
+	syms map[Stroke]KeySym
+}
+
+func (l *tableLayout) Name() string {
+	return l.name
+}
+
+func (l *tableLayout) Strokes(r rune) ([]Stroke, bool) {
+	s, ok := l.runes[r]
+	return s, ok
+}
+
+func (l *tableLayout) Char(usage Usage, mods byte) (KeySym, bool) {
+	if mods&^(MODLSHIFT|MODRSHIFT|MODRALT) != 0 {
+		return KeySym{}, false
+	}
+	code, ok := UsageCode(usage)
+	if !ok {
+		return KeySym{}, false
+	}
+
+	l.once.Do(l.index)
+	sym, ok := l.syms[Stroke{Code: code, Mods: typingMods(mods)}]
+	if !ok && mods != 0 {
+		// whitespace keys type the same with Shift or AltGr
+		sym, ok = l.syms[Stroke{Code: code}]
+		ok = ok && (sym.Rune == ' ' || sym.Rune == '\n' || sym.Rune == '\t')
+	}
+	return sym, ok
+}
+
+func (l *tableLayout) index() {
+	l.syms = make(map[Stroke]KeySym, len(l.runes))
+	for r, ss := range l.runes {
+		if len(ss) == 1 {
+			l.syms[ss[0]] = KeySym{Rune: r}
+		}
+	}
+	for s, accent := range l.deads {
+		l.syms[s] = KeySym{Rune: accent, Dead: true}
+	}
+}
+
+// Assigns characters of plain and shifted to codes in order; '\x00' leaves the key unassigned
+func (l *tableLayout) row(codes []uint16, plain, shifted string) {
+	l.keys(codes, 0, plain)
+	l.keys(codes, MODLSHIFT, shifted)
+}
+
+// Assigns characters typed with mods to codes in order
+func (l *tableLayout) keys(codes []uint16, mods byte, chars string) {
+	for i, r := range []rune(chars) {
+		if r != 0 {
+			l.runes[r] = []Stroke{{Code: codes[i], Mods: mods}}
+		}
+	}
+}
+
+// Character composed of dead key accent and base; space gives the accent itself
+func compose(accent, base rune) (rune, bool) {
+	if base == ' ' {
+		return accent, true
+	}
+	tbl := deadCompose[accent]
+	composed := []rune(tbl[1])
+	for i, b := range []rune(tbl[0]) {
+		if b == base {
+			return composed[i], true
+		}
+	}
+	return 0, false
+}
+
+// Base characters and what they compose into after dead keys
+var deadCompose = map[rune][2]string{
+	'^': {"aeiouAEIOU", "âêîôûÂÊÎÔÛ"},
+	'´': {"aeiouyAEIOUY", "áéíóúýÁÉÍÓÚÝ"},
+	'`': {"aeiouAEIOU", "àèìòùÀÈÌÒÙ"},
+	'¨': {"aeiouyAEIOU", "äëïöüÿÄËÏÖÜ"},
+	'~': {"anoANO", "ãñõÃÑÕ"},
+}
+
+// Assigns dead key typing accent; called after every other key is assigned
+// Characters typed directly are not replaced; the accent itself is typed with a following space
+func (l *tableLayout) dead(accent rune, s Stroke) {
+	l.deads[s] = accent
+	if _, ok := l.runes[accent]; !ok {
+		l.runes[accent] = []Stroke{s, {Code: evdev.KEY_SPACE}}
+	}
+
+	tbl := deadCompose[accent]
+	bases, composed := []rune(tbl[0]), []rune(tbl[1])
+	for i, c := range composed {
+		if _, ok := l.runes[c]; ok {
+			continue
+		}
+		if base, ok := l.runes[bases[i]]; ok && len(base) == 1 {
+			l.runes[c] = []Stroke{s, base[0]}
+		}
+	}
+}
+
+var (
+	rowNumber = []uint16{evdev.KEY_GRAVE, evdev.KEY_1, evdev.KEY_2, evdev.KEY_3, evdev.KEY_4, evdev.KEY_5, evdev.KEY_6,
+		evdev.KEY_7, evdev.KEY_8, evdev.KEY_9, evdev.KEY_0, evdev.KEY_MINUS, evdev.KEY_EQUAL}
+	rowTop = []uint16{evdev.KEY_Q, evdev.KEY_W, evdev.KEY_E, evdev.KEY_R, evdev.KEY_T, evdev.KEY_Y, evdev.KEY_U,
+		evdev.KEY_I, evdev.KEY_O, evdev.KEY_P, evdev.KEY_LEFTBRACE, evdev.KEY_RIGHTBRACE, evdev.KEY_BACKSLASH}
+	rowHome = []uint16{evdev.KEY_A, evdev.KEY_S, evdev.KEY_D, evdev.KEY_F, evdev.KEY_G, evdev.KEY_H, evdev.KEY_J,
+		evdev.KEY_K, evdev.KEY_L, evdev.KEY_SEMICOLON, evdev.KEY_APOSTROPHE, evdev.KEY_BACKSLASH}
+	rowBottom = []uint16{evdev.KEY_102ND, evdev.KEY_Z, evdev.KEY_X, evdev.KEY_C, evdev.KEY_V, evdev.KEY_B, evdev.KEY_N,
+		evdev.KEY_M, evdev.KEY_COMMA, evdev.KEY_DOT, evdev.KEY_SLASH, evdev.KEY_RO}
+)
+
+func newTableLayout(name string) *tableLayout {
+	l := &tableLayout{name: name, runes: make(map[rune][]Stroke), deads: make(map[Stroke]rune)}
+	l.runes[' '] = []Stroke{{Code: evdev.KEY_SPACE}}
+	l.runes['\n'] = []Stroke{{Code: evdev.KEY_ENTER}}
+	l.runes['\t'] = []Stroke{{Code: evdev.KEY_TAB}}
+	return l
+}
+
+func newUSLayout() Layout {
+	l := newTableLayout("us")
+	l.row(rowNumber, "`1234567890-=", "~!@#$%^&*()_+")
+	l.row(rowTop, "qwertyuiop[]\\", "QWERTYUIOP{}|")
+	l.row(rowHome, "asdfghjkl;'", "ASDFGHJKL:\"")
+	l.row(rowBottom, "\x00zxcvbnm,./", "\x00ZXCVBNM<>?")
+	return l
+}
+
+func newUKLayout() Layout {
+	l := newTableLayout("uk")
+	l.row(rowNumber, "`1234567890-=", "¬!\"£$%^&*()_+")
+	l.keys(rowNumber, MODRALT, "\x00\x00\x00\x00€")
+	l.row(rowTop, "qwertyuiop[]", "QWERTYUIOP{}")
+	l.row(rowHome, "asdfghjkl;'#", "ASDFGHJKL:@~")
+	l.row(rowBottom, "\\zxcvbnm,./", "|ZXCVBNM<>?")
+	return l
+}
+
+func newDELayout() Layout {
+	l := newTableLayout("de")
+	l.row(rowNumber, "\x001234567890ß\x00", "°!\"§$%&/()=?\x00")
+	l.keys(rowNumber, MODRALT, "\x00\x00²³\x00\x00\x00{[]}\\")
+	l.row(rowTop, "qwertzuiopü+", "QWERTZUIOPÜ*")
+	l.keys(rowTop, MODRALT, "@\x00€\x00\x00\x00\x00\x00\x00\x00\x00~")
+	l.row(rowHome, "asdfghjklöä#", "ASDFGHJKLÖÄ'")
+	l.row(rowBottom, "<yxcvbnm,.-", ">YXCVBNM;:_")
+	l.keys(rowBottom, MODRALT, "|\x00\x00\x00\x00\x00\x00µ")
+	l.dead('^', Stroke{Code: evdev.KEY_GRAVE})
+	l.dead('´', Stroke{Code: evdev.KEY_EQUAL})
+	l.dead('`', Stroke{Code: evdev.KEY_EQUAL, Mods: MODLSHIFT})
+	return l
+}
+
+func newFRLayout() Layout {
+	l := newTableLayout("fr")
+	l.row(rowNumber, "²&é\"'(-è_çà)=", "\x001234567890°+")
+	l.keys(rowNumber, MODRALT, "\x00\x00\x00#{[|\x00\\^@]}")
+	l.row(rowTop, "azertyuiop\x00$", "AZERTYUIOP\x00£")
+	l.keys(rowTop, MODRALT, "\x00\x00€")
+	l.row(rowHome, "qsdfghjklmù*", "QSDFGHJKLM%µ")
+	l.row(rowBottom, "<wxcvbn,;:!", ">WXCVBN?./§")
+	l.dead('^', Stroke{Code: evdev.KEY_LEFTBRACE})
+	l.dead('¨', Stroke{Code: evdev.KEY_LEFTBRACE, Mods: MODLSHIFT})
+	l.dead('~', Stroke{Code: evdev.KEY_2, Mods: MODRALT})
+	l.dead('`', Stroke{Code: evdev.KEY_7, Mods: MODRALT})
+	return l
+}
+
+func newJPLayout() Layout {
+	l := newTableLayout("jp")
+	l.row(rowNumber, "\x001234567890-^", "\x00!\"#$%&'()\x00=~")
+	l.row([]uint16{evdev.KEY_YEN}, "\\", "|")
+	l.row(rowTop, "qwertyuiop@[", "QWERTYUIOP`{")
+	l.row(rowHome, "asdfghjkl;:]", "ASDFGHJKL+*}")
+	l.row(rowBottom, "\x00zxcvbnm,./\\", "\x00ZXCVBNM<>?_")
+	return l
+}
+
+var LayoutUS = newUSLayout()
+
+var layouts = map[string]Layout{
+	"us": LayoutUS,
+	"uk": newUKLayout(),
+	"de": newDELayout(),
+	"fr": newFRLayout(),
+	"jp": newJPLayout(),
+}
+
+var layoutAliases = map[string]string{
+	"gb":  "uk",
+	"jis": "jp",
+}
+
+// Finds layout by name(e.g. "us", "uk", "de", "fr" or "jp")
+func LookupLayout(name string) (Layout, bool) {
+	name = strings.ToLower(name)
+	if a, ok := layoutAliases[name]; ok {
+		name = a
+	}
+	l, ok := layouts[name]
+	return l, ok
+}
+
+// Names of known layouts in order
+func LayoutNames() []string {
+	var ns []string
+	for n := range layouts {
+		ns = append(ns, n)
+	}
+	sort.Strings(ns)
+	return ns
+}
@@ -0,0 +1,42 @@
+package hid
+
+import "testing"
+
+func TestLayoutRoundTrip(t *testing.T) {
+	for _, n := range LayoutNames() {
+		l, _ := LookupLayout(n)
+		tl := l.(*tableLayout)
+		for r, ss := range tl.runes {
+			if len(ss) != 1 {
+				continue
+			}
+			sym, ok := l.Char(ss[0].Usage(), ss[0].Mods)
+			if !ok || sym.Rune != r || sym.Dead {
+				t.Errorf("%s: %q typed by %v reads back as %q, %v", n, r, ss[0], sym.Rune, ok)
+			}
+		}
+	}
+}
+
+func TestEcho(t *testing.T) {
+	de, _ := LookupLayout("de")
+	e := NewEcho(de)
+
+	var got string
+	for _, rep := range [][]byte{
+		kbdReport(MODLSHIFT, 0x0b), // H
+		kbdReport(0, 0x0b, 0x08),   // e while h is held
+		kbdReport(0),
+		kbdReport(0, 0x35), // dead ^
+		kbdReport(0),
+		kbdReport(0, 0x08), // e
+		kbdReport(0),
+		kbdReport(MODLCTRL, 0x06), // Ctrl+C
+	} {
+		got += e.Feed(rep)
+	}
+
+	if want := "Heê<KEY_LEFTCTRL+KEY_C>"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,482 @@
+package hid
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gvalkov/golang-evdev"
+	btlog "github.com/potch8228/gobt/log"
+)
+
+type MacroStepKind byte
+
+const (
+	MACROPRESS MacroStepKind = iota
+	MACRORELEASE
+	MACRODELAY
+	// Text typed with the host layout
+	MACROTEXT
+)
+
+type MacroStep struct {
+	Kind  MacroStepKind
+	Code  uint16
+	Delay time.Duration
+	Text  string
+}
+
+// Steps played when Trigger is pressed
+type Macro struct {
+	Trigger Chord
+	Steps   []MacroStep
+}
+
+// Parses comma separated macro steps
+//
+//	KEY_A                 tap
+//	KEY_LEFTCTRL+KEY_C    tap wrapped with modifiers held
+//	down(KEY_X), up(KEY_X)
+//	delay(100)            milliseconds
+//	"text\n"              typed with the host layout
+func ParseMacro(s string) ([]MacroStep, error) {
+	var steps []MacroStep
+	toks, err := splitSteps(s)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tok := range toks {
+		fn, arg := tok, ""
+		if open := strings.Index(tok, "("); open >= 0 && strings.HasSuffix(tok, ")") {
+			fn, arg = strings.ToLower(strings.TrimSpace(tok[:open])), strings.TrimSpace(tok[open+1:len(tok)-1])
+		}
+
+		switch {
+		case strings.HasPrefix(tok, `"`):
+			text, err := strconv.Unquote(tok)
+			if err != nil {
+				return nil, fmt.Errorf("invalid text %s", tok)
+			}
+			steps = append(steps, MacroStep{Kind: MACROTEXT, Text: text})
+		case fn == "down" || fn == "up":
+			code, err := parseKey(arg)
+			if err != nil {
+				return nil, err
+			}
+			kind := MACROPRESS
+			if fn == "up" {
+				kind = MACRORELEASE
+			}
+			steps = append(steps, MacroStep{Kind: kind, Code: code})
+		case fn == "delay":
+			ms, err := strconv.Atoi(arg)
+			if err != nil || ms < 0 {
+				return nil, fmt.Errorf("invalid delay %q", arg)
+			}
+			steps = append(steps, MacroStep{Kind: MACRODELAY, Delay: time.Duration(ms) * time.Millisecond})
+		default:
+			c, err := ParseChord(tok)
+			if err != nil {
+				return nil, fmt.Errorf("unknown macro step %q", tok)
+			}
+			for _, code := range c {
+				steps = append(steps, MacroStep{Kind: MACROPRESS, Code: code})
+			}
+			for i := len(c) - 1; i >= 0; i-- {
+				steps = append(steps, MacroStep{Kind: MACRORELEASE, Code: c[i]})
+			}
+		}
+	}
+	return steps, nil
+}
+
+// Splits s on commas outside double quotes
+func splitSteps(s string) ([]string, error) {
+	var toks []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if quoted {
+				i++
+			}
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				toks = append(toks, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated text in %q", s)
+	}
+	toks = append(toks, strings.TrimSpace(s[start:]))
+
+	for _, tok := range toks {
+		if tok == "" {
+			return nil, fmt.Errorf("empty macro step in %q", s)
+		}
+	}
+	return toks, nil
+}
+
+// Plays macros and expands abbreviations into keyboard reports
+// One macro runs at a time; starting another or pressing Esc interrupts it
+type Macros struct {
+	play   sync.Mutex
+	mu     sync.Mutex
+	sink   Sink
+	layout Layout
+	delay  time.Duration
+
+	cancel chan struct{}
+	done   chan struct{}
+
+	// Abbreviations and the strokes typing them with the current layout
+	abbrevs map[string]string
+	strokes map[string][]Stroke
+	maxlen  int
+	// Strokes typed since the last word boundary; not matched after overflow
+	word     []Stroke
+	overflow bool
+	// Expansion typed once boundary key is released
+	pending  []MacroStep
+	boundary uint16
+}
+
+func NewMacros(sink Sink) *Macros {
+	return &Macros{
+		sink:   sink,
+		layout: LayoutUS,
+		delay:  10 * time.Millisecond,
+	}
+}
+
+func (m *Macros) SetSink(sink Sink) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sink = sink
+}
+
+// Selects layout of the host; nil selects LayoutUS
+func (m *Macros) SetLayout(l Layout) {
+	if l == nil {
+		l = LayoutUS
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.layout = l
+	m.compileAbbrevsLocked()
+}
+
+func (m *Macros) Layout() Layout {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.layout
+}
+
+// Sets interval between reports; some hosts drop keys sent back to back
+func (m *Macros) SetDelay(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.delay = d
+}
+
+// Registers macro triggers as hotkeys
+func (m *Macros) Register(hotkeys *Hotkeys, macros []Macro) {
+	for _, mc := range macros {
+		steps := mc.Steps
+		hotkeys.Register(mc.Trigger, func() {
+			m.Play(steps)
+		})
+	}
+}
+
+// Replaces abbreviations expanded when typed followed by space, tab or enter
+func (m *Macros) SetAbbrevs(abbrevs map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.abbrevs = abbrevs
+	m.compileAbbrevsLocked()
+}
+
+func (m *Macros) compileAbbrevsLocked() {
+	m.strokes = make(map[string][]Stroke, len(m.abbrevs))
+	m.maxlen = 0
+	for a := range m.abbrevs {
+		var ss []Stroke
+		for _, r := range a {
+			s, ok := m.layout.Strokes(r)
+			if !ok {
+				btlog.Debug("Macros: abbreviation cannot be typed with layout", a, m.layout.Name())
+				ss = nil
+				break
+			}
+			for _, st := range s {
+				ss = append(ss, Stroke{Code: st.Code, Mods: typingMods(st.Mods)})
+			}
+		}
+		if len(ss) == 0 {
+			continue
+		}
+		m.strokes[a] = ss
+		if len(ss) > m.maxlen {
+			m.maxlen = len(ss)
+		}
+	}
+	m.word = make([]Stroke, 0, m.maxlen)
+	m.overflow = false
+}
+
+// Modifiers which change the typed character; left and right are not distinguished
+func typingMods(mods byte) byte {
+	var r byte
+	if mods&(MODLSHIFT|MODRSHIFT) != 0 {
+		r |= MODLSHIFT
+	}
+	if mods&MODRALT != 0 {
+		r |= MODRALT
+	}
+	return r
+}
+
+// Follows keys pressed on keyboards and expands abbreviations at word boundaries
+// Call after the press is sent; the expansion waits for Released of the boundary key
+func (m *Macros) Observe(code uint16, mods byte) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	if len(m.strokes) == 0 || isModifier(code) {
+		m.mu.Unlock()
+		return
+	}
+
+	switch code {
+	case evdev.KEY_BACKSPACE:
+		if len(m.word) > 0 && !m.overflow {
+			m.word = m.word[:len(m.word)-1]
+		}
+	case evdev.KEY_SPACE, evdev.KEY_ENTER, evdev.KEY_KPENTER, evdev.KEY_TAB:
+		abbr, ok := m.matchLocked()
+		m.word = m.word[:0]
+		m.overflow = false
+		if !ok {
+			break
+		}
+		steps := make([]MacroStep, 0, 2*len(abbr)+5)
+		// erase abbreviation and the boundary key, then type the expansion and the boundary key again
+		for i := 0; i <= len([]rune(abbr)); i++ {
+			steps = append(steps, MacroStep{Kind: MACROPRESS, Code: evdev.KEY_BACKSPACE}, MacroStep{Kind: MACRORELEASE, Code: evdev.KEY_BACKSPACE})
+		}
+		steps = append(steps,
+			MacroStep{Kind: MACROTEXT, Text: m.abbrevs[abbr]},
+			MacroStep{Kind: MACROPRESS, Code: code},
+			MacroStep{Kind: MACRORELEASE, Code: code})
+		// typed after the host got the boundary key released, so the expansion does not hold it
+		m.pending, m.boundary = steps, code
+		btlog.Debug("Macros: expanding abbreviation", abbr)
+		m.mu.Unlock()
+		return
+	default:
+		switch {
+		case mods&^(MODLSHIFT|MODRSHIFT|MODRALT) != 0:
+			// shortcut; not typing a word
+			m.word = m.word[:0]
+			m.overflow = true
+		case len(m.word) >= m.maxlen:
+			m.overflow = true
+		case !m.overflow:
+			m.word = append(m.word, Stroke{Code: code, Mods: typingMods(mods)})
+		}
+	}
+	m.mu.Unlock()
+}
+
+// Follows keys released on keyboards; call after the release is sent
+// Plays the expansion waiting for code
+func (m *Macros) Released(code uint16) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	steps := m.pending
+	if steps == nil || code != m.boundary {
+		m.mu.Unlock()
+		return
+	}
+	m.pending = nil
+	m.mu.Unlock()
+	m.Play(steps)
+}
+
+func (m *Macros) matchLocked() (string, bool) {
+	if m.overflow {
+		return "", false
+	}
+	for a, ss := range m.strokes {
+		if len(ss) != len(m.word) {
+			continue
+		}
+		match := true
+		for i := range ss {
+			if ss[i] != m.word[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return a, true
+		}
+	}
+	return "", false
+}
+
+// Types text with the host layout
+func (m *Macros) Type(text string) {
+	m.Play([]MacroStep{{Kind: MACROTEXT, Text: text}})
+}
+
+// Starts playing steps; a running macro is interrupted first
+func (m *Macros) Play(steps []MacroStep) {
+	m.play.Lock()
+	defer m.play.Unlock()
+	m.Interrupt()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cancel = make(chan struct{})
+	m.done = make(chan struct{})
+	go m.run(steps, m.sink, m.layout, m.delay, m.cancel, m.done)
+}
+
+// Stops running macro; keys it holds are released
+func (m *Macros) Interrupt() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	cancel, done := m.cancel, m.done
+	m.cancel, m.done = nil, nil
+	m.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	close(cancel)
+	<-done
+}
+
+// Waits for running macro to finish
+func (m *Macros) Wait() {
+	m.mu.Lock()
+	done := m.done
+	m.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+func (m *Macros) run(steps []MacroStep, sink Sink, layout Layout, delay time.Duration, cancel, done chan struct{}) {
+	defer close(done)
+
+	var rep macroReport
+	send := func(d time.Duration) bool {
+		if err := sink.Send(Report{Type: REPORTKEYBOARD, Data: rep.bytes()}); err != nil {
+			btlog.Debug("Macros: failure on sending report", err)
+		}
+		select {
+		case <-cancel:
+			return false
+		case <-time.After(d):
+			return true
+		}
+	}
+	defer func() {
+		if !rep.empty() {
+			rep = macroReport{}
+			sink.Send(Report{Type: REPORTKEYBOARD, Data: rep.bytes()})
+		}
+	}()
+
+	for _, st := range steps {
+		switch st.Kind {
+		case MACROPRESS, MACRORELEASE:
+			rep.set(st.Code, st.Kind == MACROPRESS)
+			if !send(delay) {
+				return
+			}
+		case MACRODELAY:
+			select {
+			case <-cancel:
+				return
+			case <-time.After(st.Delay):
+			}
+		case MACROTEXT:
+			for _, r := range st.Text {
+				strokes, ok := layout.Strokes(r)
+				if !ok {
+					btlog.Debug("Macros: character cannot be typed with layout", string(r), layout.Name())
+					continue
+				}
+				for _, s := range strokes {
+					held := rep.mods
+					rep.mods |= s.Mods
+					rep.set(s.Code, true)
+					if !send(delay) {
+						return
+					}
+					rep.set(s.Code, false)
+					rep.mods = held
+					if !send(delay) {
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+// Keyboard report state of a running macro
+type macroReport struct {
+	mods byte
+	keys [6]byte
+}
+
+func (r *macroReport) set(code uint16, down bool) {
+	key, kind := Lookup(code)
+	switch kind {
+	case MOD:
+		if down {
+			r.mods |= key.ModBit()
+		} else {
+			r.mods &^= key.ModBit()
+		}
+	case FUNC:
+		for i := range r.keys {
+			switch {
+			case !down && r.keys[i] == byte(key):
+				r.keys[i] = 0
+			case down && r.keys[i] == byte(key):
+				return
+			case down && r.keys[i] == 0:
+				r.keys[i] = byte(key)
+				return
+			}
+		}
+	}
+}
+
+func (r *macroReport) empty() bool {
+	return *r == macroReport{}
+}
+
+func (r *macroReport) bytes() []byte {
+	b := []byte{0xA1, 0x02, r.mods, 0x00}
+	return append(b, r.keys[:]...)
+}
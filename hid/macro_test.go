@@ -0,0 +1,147 @@
+package hid
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/gvalkov/golang-evdev"
+)
+
+type testSink struct {
+	mu   sync.Mutex
+	reps [][]byte
+}
+
+func (s *testSink) Send(r Report) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reps = append(s.reps, append([]byte(nil), r.Data...))
+	return nil
+}
+
+func kbdReport(mods byte, keys ...byte) []byte {
+	b := []byte{0xA1, 0x02, mods, 0x00, 0, 0, 0, 0, 0, 0}
+	copy(b[4:], keys)
+	return b
+}
+
+func TestParseMacro(t *testing.T) {
+	steps, err := ParseMacro(`KEY_LEFTCTRL+KEY_C, delay(50), "a, b", up(KEY_X)`)
+	if err != nil {
+		t.Fatal("ParseMacro failed", err)
+	}
+	want := []MacroStep{
+		{Kind: MACROPRESS, Code: evdev.KEY_LEFTCTRL},
+		{Kind: MACROPRESS, Code: evdev.KEY_C},
+		{Kind: MACRORELEASE, Code: evdev.KEY_C},
+		{Kind: MACRORELEASE, Code: evdev.KEY_LEFTCTRL},
+		{Kind: MACRODELAY, Delay: 50000000},
+		{Kind: MACROTEXT, Text: "a, b"},
+		{Kind: MACRORELEASE, Code: evdev.KEY_X},
+	}
+	if len(steps) != len(want) {
+		t.Fatalf("got %v, want %v", steps, want)
+	}
+	for i := range want {
+		if steps[i] != want[i] {
+			t.Errorf("step %d: got %v, want %v", i, steps[i], want[i])
+		}
+	}
+
+	for _, s := range []string{`"open`, "KEY_A,,KEY_B", "delay(x)", "KEY_NOSUCHKEY"} {
+		if _, err := ParseMacro(s); err == nil {
+			t.Error("Invalid macro is accepted: ", s)
+		}
+	}
+}
+
+func TestMacrosPlay(t *testing.T) {
+	sink := &testSink{}
+	m := NewMacros(sink)
+	m.SetDelay(0)
+
+	m.Play([]MacroStep{{Kind: MACROPRESS, Code: evdev.KEY_LEFTCTRL}, {Kind: MACROTEXT, Text: "A"}})
+	m.Wait()
+
+	want := [][]byte{
+		kbdReport(MODLCTRL),
+		kbdReport(MODLCTRL|MODLSHIFT, 0x04),
+		kbdReport(MODLCTRL),
+		// held keys are released when the macro ends
+		kbdReport(0),
+	}
+	if len(sink.reps) != len(want) {
+		t.Fatalf("got %v, want %v", sink.reps, want)
+	}
+	for i := range want {
+		if !bytes.Equal(sink.reps[i], want[i]) {
+			t.Errorf("report %d: got %v, want %v", i, sink.reps[i], want[i])
+		}
+	}
+}
+
+func TestMacrosAbbrev(t *testing.T) {
+	sink := &testSink{}
+	m := NewMacros(sink)
+	m.SetDelay(0)
+	m.SetAbbrevs(map[string]string{"Ok": "fine"})
+
+	m.Observe(evdev.KEY_O, MODLSHIFT)
+	m.Observe(evdev.KEY_K, 0)
+	m.Observe(evdev.KEY_SPACE, 0)
+	m.Wait()
+	if len(sink.reps) != 0 {
+		t.Fatal("Abbreviation is expanded while the boundary key is held: ", sink.reps)
+	}
+	m.Released(evdev.KEY_SPACE)
+	m.Wait()
+
+	// 3 backspaces, "fine" and space; pressed and released each
+	if len(sink.reps) != 2*(3+4+1) {
+		t.Fatalf("Abbreviation is not expanded: %v", sink.reps)
+	}
+	if !bytes.Equal(sink.reps[6], kbdReport(0, 0x09)) {
+		t.Error("Expansion is not typed: ", sink.reps[6])
+	}
+
+	sink.reps = nil
+	m.Observe(evdev.KEY_O, 0)
+	m.Observe(evdev.KEY_K, 0)
+	m.Observe(evdev.KEY_SPACE, 0)
+	m.Released(evdev.KEY_SPACE)
+	m.Wait()
+	if len(sink.reps) != 0 {
+		t.Error("Abbreviation is expanded with different case: ", sink.reps)
+	}
+}
+
+// Expansion follows the boundary key on the host
+func TestKeyboardAbbrevOrder(t *testing.T) {
+	sink := &testSink{}
+	m := NewMacros(sink)
+	m.SetDelay(0)
+	m.SetAbbrevs(map[string]string{"k": "ok"})
+	k := testKeyboard(sink, KeyboardConfig{Macros: m})
+
+	for _, code := range []uint16{evdev.KEY_K, evdev.KEY_SPACE} {
+		k.changeState(keyEvent(code, true))
+		k.changeState(keyEvent(code, false))
+	}
+	m.Wait()
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	want := [][]byte{
+		kbdReport(0, 0x0e), kbdReport(0), kbdReport(0, 0x2c), kbdReport(0),
+		kbdReport(0, 0x2a), kbdReport(0), kbdReport(0, 0x2a), kbdReport(0),
+	}
+	if len(sink.reps) < len(want) {
+		t.Fatalf("got %d reports: %v", len(sink.reps), sink.reps)
+	}
+	for i := range want {
+		if !bytes.Equal(sink.reps[i], want[i]) {
+			t.Errorf("report %d = % x; want % x", i, sink.reps[i], want[i])
+		}
+	}
+}
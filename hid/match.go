@@ -0,0 +1,142 @@
+package hid
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gvalkov/golang-evdev"
+)
+
+// Rule selecting evdev devices; zero values match anything
+// Name, Phys and Uniq are patterns where '*' matches any string(including '/') and '?' any character
+type DeviceRule struct {
+	Name    string
+	Phys    string
+	Uniq    string
+	Bus     uint16
+	Vendor  uint16
+	Product uint16
+
+	// Capability names the device must all have; e.g. "EV_REL", "KEY_A", "REL_WHEEL"
+	Capabilities []string
+
+	// Kind given to matched devices; DEVNONE excludes them
+	Kind DeviceKind
+}
+
+// Ordered rules; the first matching rule wins
+type DeviceRules []DeviceRule
+
+// Decides device kind by rules; guessed from capabilities when no rule matches
+func (rs DeviceRules) Classify(info *DeviceInfo) DeviceKind {
+	for _, r := range rs {
+		if r.Match(info) {
+			return r.Kind
+		}
+	}
+	return info.Kind()
+}
+
+func (r DeviceRule) Match(info *DeviceInfo) bool {
+	switch {
+	case !globMatch(r.Name, info.Name):
+		return false
+	case !globMatch(r.Phys, info.Phys):
+		return false
+	case !globMatch(r.Uniq, info.Uniq):
+		return false
+	case r.Bus != 0 && r.Bus != info.Bus:
+		return false
+	case r.Vendor != 0 && r.Vendor != info.Vendor:
+		return false
+	case r.Product != 0 && r.Product != info.Product:
+		return false
+	}
+
+	for _, c := range r.Capabilities {
+		typ, code, ok := ParseCapability(c)
+		if !ok {
+			return false
+		}
+		if code < 0 {
+			if len(info.caps[typ]) == 0 {
+				return false
+			}
+		} else if !info.HasCapability(typ, code) {
+			return false
+		}
+	}
+	return true
+}
+
+// Validates capability names
+func (r DeviceRule) Validate() error {
+	for _, c := range r.Capabilities {
+		if _, _, ok := ParseCapability(c); !ok {
+			return fmt.Errorf("unknown capability %q", c)
+		}
+	}
+	return nil
+}
+
+func globMatch(pattern, s string) bool {
+	if pattern == "" {
+		return true
+	}
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.Replace(expr, `\*`, ".*", -1)
+	expr = strings.Replace(expr, `\?`, ".", -1)
+	ok, err := regexp.MatchString("^"+expr+"$", s)
+	return err == nil && ok
+}
+
+var eventTypes = map[string]int{
+	"EV_KEY": evdev.EV_KEY,
+	"EV_REL": evdev.EV_REL,
+	"EV_ABS": evdev.EV_ABS,
+	"EV_MSC": evdev.EV_MSC,
+	"EV_SW":  evdev.EV_SW,
+	"EV_LED": evdev.EV_LED,
+	"EV_SND": evdev.EV_SND,
+	"EV_REP": evdev.EV_REP,
+}
+
+var relCodes = map[string]int{
+	"REL_X":      evdev.REL_X,
+	"REL_Y":      evdev.REL_Y,
+	"REL_Z":      evdev.REL_Z,
+	"REL_HWHEEL": evdev.REL_HWHEEL,
+	"REL_DIAL":   evdev.REL_DIAL,
+	"REL_WHEEL":  evdev.REL_WHEEL,
+}
+
+var absCodes = map[string]int{
+	"ABS_X":             evdev.ABS_X,
+	"ABS_Y":             evdev.ABS_Y,
+	"ABS_PRESSURE":      evdev.ABS_PRESSURE,
+	"ABS_MT_SLOT":       evdev.ABS_MT_SLOT,
+	"ABS_MT_POSITION_X": evdev.ABS_MT_POSITION_X,
+	"ABS_MT_POSITION_Y": evdev.ABS_MT_POSITION_Y,
+}
+
+// Resolves capability name to event type and code
+// Event type names(e.g. "EV_REL") give code -1 which stands for any code
+func ParseCapability(name string) (int, int, bool) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if typ, ok := eventTypes[name]; ok {
+		return typ, -1, true
+	}
+	if code, ok := relCodes[name]; ok {
+		return evdev.EV_REL, code, true
+	}
+	if code, ok := absCodes[name]; ok {
+		return evdev.EV_ABS, code, true
+	}
+	if strings.HasPrefix(name, "KEY_") || strings.HasPrefix(name, "BTN_") {
+		if code, ok := KeyCode(name); ok {
+			return evdev.EV_KEY, int(code), true
+		}
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,54 @@
+package hid
+
+import (
+	"testing"
+
+	"github.com/gvalkov/golang-evdev"
+)
+
+func testDeviceInfo() *DeviceInfo {
+	return &DeviceInfo{
+		Name:    "Yubico YubiKey OTP+FIDO+CCID",
+		Phys:    "usb-3f980000.usb-1.3/input0",
+		Bus:     0x03,
+		Vendor:  0x1050,
+		Product: 0x0407,
+		caps: map[int]map[int]bool{
+			evdev.EV_KEY: {evdev.KEY_A: true, evdev.KEY_Z: true, evdev.KEY_SPACE: true},
+		},
+	}
+}
+
+func TestDeviceRulesClassify(t *testing.T) {
+	info := testDeviceInfo()
+
+	if k := DeviceRules(nil).Classify(info); k != DEVKEYBOARD {
+		t.Error("Device should be guessed as keyboard: got ", k)
+	}
+
+	rules := DeviceRules{
+		{Vendor: 0x1050, Kind: DEVNONE},
+		{Name: "*", Kind: DEVKEYBOARD},
+	}
+	if k := rules.Classify(info); k != DEVNONE {
+		t.Error("Device should be excluded by vendor: got ", k)
+	}
+
+	rules = DeviceRules{
+		{Name: "Yubico*", Capabilities: []string{"EV_REL"}, Kind: DEVNONE},
+		{Phys: "usb-*/input?", Capabilities: []string{"KEY_A"}, Kind: DEVMOUSE},
+	}
+	if k := rules.Classify(info); k != DEVMOUSE {
+		t.Error("Second rule should match: got ", k)
+	}
+}
+
+func TestDeviceRuleValidate(t *testing.T) {
+	if err := (DeviceRule{Capabilities: []string{"KEY_NOSUCHKEY"}}).Validate(); err == nil {
+		t.Error("Unknown capability is accepted")
+	}
+
+	if err := (DeviceRule{Name: "*Keyboard*", Capabilities: []string{"EV_KEY", "REL_WHEEL", "BTN_LEFT"}}).Validate(); err != nil {
+		t.Error("Valid rule is rejected", err)
+	}
+}
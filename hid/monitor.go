@@ -0,0 +1,91 @@
+package hid
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	btlog "github.com/potch8228/gobt/log"
+
+	"golang.org/x/sys/unix"
+)
+
+type MonitorEvent struct {
+	Path  string
+	Added bool
+}
+
+// Watches evdev nodes(event*) being created and removed with inotify
+type Monitor struct {
+	dir    string
+	file   *os.File
+	events chan MonitorEvent
+}
+
+// Starts watching dir(usually /dev/input)
+// Nodes already existing are reported as added first
+func NewMonitor(dir string) (*Monitor, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		btlog.Debug("Failure on inotify init", err)
+		return nil, err
+	}
+
+	if _, err := unix.InotifyAddWatch(fd, dir, unix.IN_CREATE|unix.IN_DELETE|unix.IN_MOVED_TO|unix.IN_MOVED_FROM); err != nil {
+		unix.Close(fd)
+		btlog.Debug("Failure on inotify add watch", dir, err)
+		return nil, err
+	}
+
+	m := &Monitor{
+		dir:    dir,
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		events: make(chan MonitorEvent, 16),
+	}
+
+	ps, _ := filepath.Glob(filepath.Join(dir, "event*"))
+	go m.run(ps)
+	return m, nil
+}
+
+// Receives device events; closed when the monitor is closed
+func (m *Monitor) Events() <-chan MonitorEvent {
+	return m.events
+}
+
+func (m *Monitor) Close() error {
+	return m.file.Close()
+}
+
+func (m *Monitor) run(initial []string) {
+	defer close(m.events)
+
+	for _, p := range initial {
+		m.events <- MonitorEvent{Path: p, Added: true}
+	}
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := m.file.Read(buf)
+		if err != nil {
+			btlog.Debug("Quitting device monitor", err)
+			return
+		}
+
+		for off := 0; off+unix.SizeofInotifyEvent <= n; {
+			ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			nameBuf := buf[off+unix.SizeofInotifyEvent : off+unix.SizeofInotifyEvent+int(ev.Len)]
+			off += unix.SizeofInotifyEvent + int(ev.Len)
+
+			name := string(bytes.TrimRight(nameBuf, "\x00"))
+			if !strings.HasPrefix(name, "event") {
+				continue
+			}
+
+			added := ev.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0
+			m.events <- MonitorEvent{Path: filepath.Join(m.dir, name), Added: added}
+		}
+	}
+}
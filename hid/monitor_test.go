@@ -0,0 +1,76 @@
+package hid
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func nextMonitorEvent(t *testing.T, m *Monitor) MonitorEvent {
+	select {
+	case ev, ok := <-m.Events():
+		if !ok {
+			t.Fatal("monitor closed")
+		}
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("no monitor event")
+	}
+	return MonitorEvent{}
+}
+
+func TestMonitor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gobt-monitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	touch := func(name string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	touch("event0")
+	touch("js0")
+
+	m, err := NewMonitor(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nodes other than event* are ignored
+	touch("mouse0")
+	touch("event1")
+	os.Rename(filepath.Join(dir, "event1"), filepath.Join(dir, "event2"))
+	os.Remove(filepath.Join(dir, "event0"))
+
+	for _, want := range []MonitorEvent{
+		{filepath.Join(dir, "event0"), true},
+		{filepath.Join(dir, "event1"), true},
+		{filepath.Join(dir, "event1"), false},
+		{filepath.Join(dir, "event2"), true},
+		{filepath.Join(dir, "event0"), false},
+	} {
+		if ev := nextMonitorEvent(t, m); ev != want {
+			t.Errorf("event %+v; want %+v", ev, want)
+		}
+	}
+
+	m.Close()
+	select {
+	case ev, ok := <-m.Events():
+		if ok {
+			t.Errorf("event %+v after close", ev)
+		}
+	case <-time.After(time.Second):
+		t.Error("events not closed")
+	}
+}
+
+func TestMonitorMissingDir(t *testing.T) {
+	if _, err := NewMonitor("/nonexistent/input"); err == nil {
+		t.Error("no error")
+	}
+}
@@ -0,0 +1,101 @@
+package hid
+
+import (
+	"time"
+
+	"github.com/gvalkov/golang-evdev"
+	btlog "github.com/potch8228/gobt/log"
+)
+
+var digitKeys = map[uint16]byte{
+	evdev.KEY_0: '0', evdev.KEY_KP0: '0',
+	evdev.KEY_1: '1', evdev.KEY_KP1: '1',
+	evdev.KEY_2: '2', evdev.KEY_KP2: '2',
+	evdev.KEY_3: '3', evdev.KEY_KP3: '3',
+	evdev.KEY_4: '4', evdev.KEY_KP4: '4',
+	evdev.KEY_5: '5', evdev.KEY_KP5: '5',
+	evdev.KEY_6: '6', evdev.KEY_KP6: '6',
+	evdev.KEY_7: '7', evdev.KEY_KP7: '7',
+	evdev.KEY_8: '8', evdev.KEY_KP8: '8',
+	evdev.KEY_9: '9', evdev.KEY_KP9: '9',
+}
+
+// Reads digits typed on local keyboards(paths) until Enter is pressed
+// Returns DeviceError when timed out or canceled
+func ReadDigits(paths []string, timeout time.Duration, cancel <-chan struct{}) (string, error) {
+	keys := make(chan uint16, 10)
+	done := make(chan struct{})
+	devs := make([]*evdev.InputDevice, 0, len(paths))
+	for _, p := range paths {
+		dev, err := evdev.Open(p)
+		if err != nil {
+			btlog.Debug("Failure on Opening Keyboard: ", p, err)
+			continue
+		}
+		devs = append(devs, dev)
+
+		go func(dev *evdev.InputDevice) {
+			for {
+				input, err := dev.ReadOne()
+				if err != nil {
+					return
+				}
+				if input.Type != evdev.EV_KEY || evdev.KeyEventState(input.Value) != evdev.KeyDown {
+					continue
+				}
+				select {
+				case keys <- input.Code:
+				case <-done:
+					return
+				}
+			}
+		}(dev)
+	}
+	defer func() {
+		close(done)
+		for _, dev := range devs {
+			dev.File.Close()
+		}
+	}()
+
+	if len(devs) == 0 {
+		return "", &DeviceError{msg: "no keyboard available", method: "ReadDigits()"}
+	}
+	return collectDigits(keys, timeout, cancel)
+}
+
+// Reads digits like ReadDigits from keyboards which share h; captured keys are not forwarded to hosts
+// Works while keyboards are grabbed
+func (h *Hotkeys) ReadDigits(timeout time.Duration, cancel <-chan struct{}) (string, error) {
+	keys := make(chan uint16, 10)
+	release := h.Capture(keys)
+	defer release()
+	return collectDigits(keys, timeout, cancel)
+}
+
+// Collects pressed digits until Enter
+func collectDigits(keys <-chan uint16, timeout time.Duration, cancel <-chan struct{}) (string, error) {
+	tm := time.After(timeout)
+	digits := make([]byte, 0, 6)
+	for {
+		select {
+		case code := <-keys:
+			switch code {
+			case evdev.KEY_ENTER, evdev.KEY_KPENTER:
+				return string(digits), nil
+			case evdev.KEY_BACKSPACE:
+				if len(digits) > 0 {
+					digits = digits[:len(digits)-1]
+				}
+			default:
+				if d, ok := digitKeys[code]; ok {
+					digits = append(digits, d)
+				}
+			}
+		case <-tm:
+			return "", &DeviceError{msg: "timed out", method: "ReadDigits()"}
+		case <-cancel:
+			return "", &DeviceError{msg: "canceled", method: "ReadDigits()"}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package hid
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/gvalkov/golang-evdev"
+)
+
+func TestHotkeysReadDigits(t *testing.T) {
+	sink := &testSink{}
+	hk := NewHotkeys()
+	k := testKeyboard(sink, KeyboardConfig{Hotkeys: hk})
+	// held on the host before pairing starts
+	k.changeState(keyEvent(evdev.KEY_A, true))
+
+	type result struct {
+		digits string
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		d, err := hk.ReadDigits(time.Second, nil)
+		done <- result{d, err}
+	}()
+	for deadline := time.Now().Add(time.Second); ; {
+		hk.mu.Lock()
+		capturing := hk.capture != nil
+		hk.mu.Unlock()
+		if capturing {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("keys not captured")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for _, code := range []uint16{evdev.KEY_1, evdev.KEY_KP2, evdev.KEY_9, evdev.KEY_BACKSPACE, evdev.KEY_3} {
+		k.changeState(keyEvent(code, true))
+		k.changeState(keyEvent(code, false))
+	}
+	k.changeState(keyEvent(evdev.KEY_A, false))
+	k.changeState(keyEvent(evdev.KEY_ENTER, true))
+
+	r := <-done
+	if r.err != nil || r.digits != "123" {
+		t.Errorf("ReadDigits = %q, %v; want 123", r.digits, r.err)
+	}
+	// Enter pressed while capturing is not released on the host either
+	k.changeState(keyEvent(evdev.KEY_ENTER, false))
+	k.changeState(keyEvent(evdev.KEY_B, true))
+
+	want := [][]byte{kbdReport(0, 0x04), kbdReport(0), kbdReport(0, 0x05)}
+	if len(sink.reps) != len(want) {
+		t.Fatalf("host got %d reports; want %d", len(sink.reps), len(want))
+	}
+	for i := range want {
+		if !bytes.Equal(sink.reps[i], want[i]) {
+			t.Errorf("report %d = % x; want % x", i, sink.reps[i], want[i])
+		}
+	}
+}
+
+func TestHotkeysReadDigitsTimeout(t *testing.T) {
+	hk := NewHotkeys()
+	if _, err := hk.ReadDigits(10*time.Millisecond, nil); err == nil {
+		t.Error("no error after timeout")
+	}
+	if hk.captured(evdev.KEY_1) {
+		t.Error("keys still captured after timeout")
+	}
+}
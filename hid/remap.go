@@ -0,0 +1,339 @@
+package hid
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Maps evdev key code to another evdev key code
+type Remap map[uint16]uint16
+
+// Remaps loaded from a keymap file
+//
+//	# applied to every keyboard
+//	KEY_CAPSLOCK = KEY_LEFTCTRL
+//
+//	[device "AT Translated Set 2 keyboard"]
+//	KEY_RIGHTALT = KEY_RIGHTMETA
+//
+//	[host "mac"]
+//	KEY_LEFTALT = KEY_LEFTMETA
+//	KEY_LEFTMETA = KEY_LEFTALT
+//
+//	# actions applied after remapping; see ParseAction
+//	[layer 0]
+//	KEY_CAPSLOCK = LT(1, KEY_ESC)
+//	[layer 1]
+//	KEY_H = KEY_LEFT
+//
+//	# trigger = steps; see ParseMacro
+//	[macros]
+//	KEY_LEFTCTRL+KEY_LEFTALT+KEY_M = "Best regards,\n", delay(100), KEY_LEFTCTRL+KEY_S
+//
+//	[abbrevs]
+//	btw = by the way
+type Keymap struct {
+	Global Remap
+	// Keyed by evdev device name
+	Devices map[string]Remap
+	// Profiles selected per target host
+	Hosts map[string]Remap
+	// Keyed by layer number
+	Layers map[int]Layer
+
+	Macros []Macro
+	// Abbreviation to its expansion
+	Abbrevs map[string]string
+}
+
+func NewKeymap() *Keymap {
+	return &Keymap{
+		Global:  make(Remap),
+		Devices: make(map[string]Remap),
+		Hosts:   make(map[string]Remap),
+		Layers:  make(map[int]Layer),
+		Abbrevs: make(map[string]string),
+	}
+}
+
+// Merges other into km; entries of other win
+func (km *Keymap) Merge(other *Keymap) {
+	mergeRemap(km.Global, other.Global)
+	for n, r := range other.Devices {
+		if km.Devices[n] == nil {
+			km.Devices[n] = make(Remap)
+		}
+		mergeRemap(km.Devices[n], r)
+	}
+	for n, r := range other.Hosts {
+		if km.Hosts[n] == nil {
+			km.Hosts[n] = make(Remap)
+		}
+		mergeRemap(km.Hosts[n], r)
+	}
+	for n, l := range other.Layers {
+		if km.Layers[n] == nil {
+			km.Layers[n] = make(Layer)
+		}
+		for k, a := range l {
+			km.Layers[n][k] = a
+		}
+	}
+	km.Macros = append(km.Macros, other.Macros...)
+	for a, t := range other.Abbrevs {
+		km.Abbrevs[a] = t
+	}
+}
+
+func mergeRemap(dst, src Remap) {
+	for k, v := range src {
+		dst[k] = v
+	}
+}
+
+type KeymapError struct {
+	file string
+	line int
+	msg  string
+}
+
+func (e *KeymapError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.file, e.line, e.msg)
+}
+
+func LoadKeymap(path string) (*Keymap, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseKeymap(f, path)
+}
+
+// Loads and merges every *.keymap file in dir in name order; missing dir gives empty keymap
+func LoadKeymapDir(dir string) (*Keymap, error) {
+	km := NewKeymap()
+	ps, err := filepath.Glob(filepath.Join(dir, "*.keymap"))
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range ps {
+		f, err := LoadKeymap(p)
+		if err != nil {
+			return nil, err
+		}
+		km.Merge(f)
+	}
+	return km, nil
+}
+
+// Parses keymap; name is used in error messages
+func ParseKeymap(r io.Reader, name string) (*Keymap, error) {
+	km := NewKeymap()
+	// section lines are added to; one of them is set
+	cur := km.Global
+	var layer Layer
+	var macros, abbrevs bool
+
+	sc := bufio.NewScanner(r)
+	for ln := 1; sc.Scan(); ln++ {
+		line := strings.TrimSpace(stripComment(sc.Text()))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, &KeymapError{file: name, line: ln, msg: "unterminated section header"}
+			}
+			sec := strings.TrimSpace(line[1 : len(line)-1])
+			kind, arg := sec, ""
+			if i := strings.IndexAny(sec, " \t"); i >= 0 {
+				kind = sec[:i]
+				arg = strings.TrimSpace(sec[i:])
+			}
+
+			cur, layer, macros, abbrevs = nil, nil, false, false
+			if kind == "layer" {
+				l, err := parseLayer(strings.Trim(arg, `"`))
+				if err != nil {
+					return nil, &KeymapError{file: name, line: ln, msg: err.Error()}
+				}
+				if km.Layers[l] == nil {
+					km.Layers[l] = make(Layer)
+				}
+				layer = km.Layers[l]
+				continue
+			}
+			if arg != "" {
+				var err error
+				if arg, err = strconv.Unquote(arg); err != nil {
+					return nil, &KeymapError{file: name, line: ln, msg: "section name must be quoted: " + sec}
+				}
+			}
+
+			switch {
+			case kind == "global" && arg == "":
+				cur = km.Global
+			case kind == "device" && arg != "":
+				if km.Devices[arg] == nil {
+					km.Devices[arg] = make(Remap)
+				}
+				cur = km.Devices[arg]
+			case kind == "host" && arg != "":
+				if km.Hosts[arg] == nil {
+					km.Hosts[arg] = make(Remap)
+				}
+				cur = km.Hosts[arg]
+			case kind == "macros" && arg == "":
+				macros = true
+			case kind == "abbrevs" && arg == "":
+				abbrevs = true
+			default:
+				return nil, &KeymapError{file: name, line: ln, msg: "unknown section: " + sec}
+			}
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return nil, &KeymapError{file: name, line: ln, msg: "expected FROM = TO"}
+		}
+		lhs, rhs := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+
+		switch {
+		case macros:
+			c, err := ParseChord(lhs)
+			if err != nil {
+				return nil, &KeymapError{file: name, line: ln, msg: "unknown key in trigger: " + lhs}
+			}
+			steps, err := ParseMacro(rhs)
+			if err != nil {
+				return nil, &KeymapError{file: name, line: ln, msg: err.Error()}
+			}
+			km.Macros = append(km.Macros, Macro{Trigger: c, Steps: steps})
+			continue
+		case abbrevs:
+			if strings.HasPrefix(rhs, `"`) {
+				var err error
+				if rhs, err = strconv.Unquote(rhs); err != nil {
+					return nil, &KeymapError{file: name, line: ln, msg: "invalid text: " + rhs}
+				}
+			}
+			if lhs == "" || strings.ContainsAny(lhs, " \t") {
+				return nil, &KeymapError{file: name, line: ln, msg: "abbreviation must be a single word: " + lhs}
+			}
+			km.Abbrevs[lhs] = rhs
+			continue
+		}
+
+		from, ok := KeyCode(lhs)
+		if !ok {
+			return nil, &KeymapError{file: name, line: ln, msg: "unknown key: " + lhs}
+		}
+		if layer != nil {
+			act, err := ParseAction(rhs)
+			if err != nil {
+				return nil, &KeymapError{file: name, line: ln, msg: err.Error()}
+			}
+			if _, dup := layer[from]; dup {
+				return nil, &KeymapError{file: name, line: ln, msg: "key assigned twice: " + lhs}
+			}
+			layer[from] = act
+			continue
+		}
+		to, ok := KeyCode(rhs)
+		if !ok {
+			return nil, &KeymapError{file: name, line: ln, msg: "unknown key: " + rhs}
+		}
+		if _, dup := cur[from]; dup {
+			return nil, &KeymapError{file: name, line: ln, msg: "key remapped twice: " + lhs}
+		}
+		cur[from] = to
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return km, nil
+}
+
+// Strips '#' comment outside double quotes
+func stripComment(line string) string {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			if quoted {
+				i++
+			}
+		case '"':
+			quoted = !quoted
+		case '#':
+			if !quoted {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
+
+// Applies keymap to key codes; shared by keyboards
+// Device remap, global remap and the active host profile are applied in that order
+type Remapper struct {
+	mu      sync.RWMutex
+	km      *Keymap
+	profile string
+}
+
+func NewRemapper(km *Keymap) *Remapper {
+	if km == nil {
+		km = NewKeymap()
+	}
+	return &Remapper{km: km}
+}
+
+func (r *Remapper) SetKeymap(km *Keymap) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.km = km
+}
+
+// Selects host profile; empty disables host remapping
+func (r *Remapper) SetProfile(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.profile = name
+}
+
+func (r *Remapper) Profile() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.profile
+}
+
+// Returns key code to be sent for code pressed on device
+func (r *Remapper) Map(device string, code uint16) uint16 {
+	if r == nil {
+		return code
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if to, ok := r.km.Devices[device][code]; ok {
+		code = to
+	}
+	if to, ok := r.km.Global[code]; ok {
+		code = to
+	}
+	if to, ok := r.km.Hosts[r.profile][code]; ok {
+		code = to
+	}
+	return code
+}
@@ -0,0 +1,121 @@
+package hid
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gvalkov/golang-evdev"
+)
+
+const testKeymap = `
+# Caps Lock as Control everywhere
+KEY_CAPSLOCK = KEY_LEFTCTRL
+
+[device "Apple Keyboard"]
+LEFTMETA = LEFTALT
+
+[host "mac"]
+KEY_LEFTALT = KEY_LEFTMETA
+KEY_LEFTMETA = KEY_LEFTALT
+`
+
+func TestParseKeymap(t *testing.T) {
+	km, err := ParseKeymap(strings.NewReader(testKeymap), "test")
+	if err != nil {
+		t.Fatal("ParseKeymap failed", err)
+	}
+
+	r := NewRemapper(km)
+	if c := r.Map("any", evdev.KEY_CAPSLOCK); c != evdev.KEY_LEFTCTRL {
+		t.Error("Global remap is not applied: got ", c)
+	}
+
+	if c := r.Map("any", evdev.KEY_LEFTALT); c != evdev.KEY_LEFTALT {
+		t.Error("Host remap is applied without profile: got ", c)
+	}
+
+	r.SetProfile("mac")
+	if c := r.Map("any", evdev.KEY_LEFTALT); c != evdev.KEY_LEFTMETA {
+		t.Error("Host remap is not applied: got ", c)
+	}
+
+	// device remap first, then host profile swaps it back
+	if c := r.Map("Apple Keyboard", evdev.KEY_LEFTMETA); c != evdev.KEY_LEFTMETA {
+		t.Error("Remaps are not applied in order: got ", c)
+	}
+}
+
+func TestParseKeymapErrors(t *testing.T) {
+	for _, s := range []string{
+		"KEY_A KEY_B",
+		"KEY_A = KEY_NOSUCHKEY",
+		"[device Apple]",
+		"[layer \"x\"]",
+		"KEY_A = KEY_B\nKEY_A = KEY_C",
+	} {
+		if _, err := ParseKeymap(strings.NewReader(s), "test"); err == nil {
+			t.Error("Invalid keymap is accepted: ", s)
+		} else if !strings.HasPrefix(err.Error(), "test:") {
+			t.Error("Error does not tell location: ", err)
+		}
+	}
+}
+
+func TestParseKeymapLayers(t *testing.T) {
+	km, err := ParseKeymap(strings.NewReader("[layer 0]\nKEY_CAPSLOCK = LT(1, KEY_ESC)\n[layer \"1\"]\nKEY_H = KEY_LEFT\n"), "test")
+	if err != nil {
+		t.Fatal("ParseKeymap failed", err)
+	}
+	if a := km.Layers[0][evdev.KEY_CAPSLOCK]; a.Kind != ACTLAYERTAP || a.Layer != 1 || a.Code != evdev.KEY_ESC {
+		t.Error("Layer action is not parsed: ", a)
+	}
+	if a := km.Layers[1][evdev.KEY_H]; a.Kind != ACTKEY || a.Code != evdev.KEY_LEFT {
+		t.Error("Layer key is not parsed: ", a)
+	}
+}
+
+func TestParseKeymapMacros(t *testing.T) {
+	km, err := ParseKeymap(strings.NewReader(`
+[macros]
+KEY_LEFTCTRL+KEY_M = "# not a comment", KEY_ENTER  # comment
+[abbrevs]
+btw = by the way
+sig = "Regards,\nPotch"
+`), "test")
+	if err != nil {
+		t.Fatal("ParseKeymap failed", err)
+	}
+	if len(km.Macros) != 1 || len(km.Macros[0].Trigger) != 2 || km.Macros[0].Steps[0].Text != "# not a comment" {
+		t.Error("Macro is not parsed: ", km.Macros)
+	}
+	if km.Abbrevs["btw"] != "by the way" || km.Abbrevs["sig"] != "Regards,\nPotch" {
+		t.Error("Abbreviations are not parsed: ", km.Abbrevs)
+	}
+}
+
+func TestJISANSIKeymap(t *testing.T) {
+	km, err := LoadKeymap("../keymaps/jis-ansi.keymap")
+	if err != nil {
+		t.Fatal("LoadKeymap failed", err)
+	}
+	r := NewRemapper(km)
+
+	r.SetProfile("jis-ansi")
+	if c := r.Map("any", evdev.KEY_YEN); c != evdev.KEY_BACKSLASH {
+		t.Error("JIS key is not moved to ANSI position: got ", c)
+	}
+	r.SetProfile("ansi-jis")
+	if c := r.Map("any", evdev.KEY_COMPOSE); c != evdev.KEY_RO {
+		t.Error("JIS key is not put on ANSI key: got ", c)
+	}
+
+	// keys moved one to one come back
+	for _, code := range []uint16{evdev.KEY_GRAVE, evdev.KEY_BACKSLASH, evdev.KEY_RIGHTALT} {
+		r.SetProfile("ansi-jis")
+		jis := r.Map("any", code)
+		r.SetProfile("jis-ansi")
+		if c := r.Map("any", jis); jis == code || c != code {
+			t.Error("Key does not come back: ", code, jis, c)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package hid
+
+import (
+	"time"
+
+	"github.com/gvalkov/golang-evdev"
+	"github.com/potch8228/gobt/metrics"
+)
+
+type ReportType byte
+
+const (
+	REPORTKEYBOARD ReportType = iota
+	REPORTMOUSE
+	REPORTVENDOR
+)
+
+func (t ReportType) String() string {
+	switch t {
+	case REPORTKEYBOARD:
+		return "keyboard"
+	case REPORTMOUSE:
+		return "mouse"
+	case REPORTVENDOR:
+		return "vendor"
+	}
+	return "unknown"
+}
+
+// Input report to be sent on the interrupt channel
+// Data is owned by the device; receivers must copy it to keep it
+type Report struct {
+	Type ReportType
+	Data []byte
+	// evdev time of the event causing the report; zero for generated reports(e.g. macros)
+	Time time.Time
+	// Name of the device sending the report
+	Device string
+}
+
+// Report in boot protocol format
+// Boot keyboard and mouse use report ID 1 and 2 respectively, and boot mouse has no wheel
+// Vendor reports have no boot format and give nil
+func (r Report) Boot() []byte {
+	switch {
+	case r.Type == REPORTVENDOR:
+		return nil
+	case r.Type == REPORTKEYBOARD && len(r.Data) == 10:
+		b := append([]byte(nil), r.Data...)
+		b[1] = 0x01
+		return b
+	case r.Type == REPORTMOUSE && len(r.Data) == 6:
+		b := append([]byte(nil), r.Data[:5]...)
+		b[1] = 0x02
+		return b
+	}
+	return append([]byte(nil), r.Data...)
+}
+
+var reportsSent = metrics.Default.Counter("gobt_input_reports_total", "Reports sent by local input devices, by report type.", "type")
+
+// Counters of reportsSent by ReportType; looked up once to keep sending free of allocations
+var reportCounters = [...]*metrics.Counter{
+	REPORTKEYBOARD: reportsSent.With(REPORTKEYBOARD.String()),
+	REPORTMOUSE:    reportsSent.With(REPORTMOUSE.String()),
+	REPORTVENDOR:   reportsSent.With(REPORTVENDOR.String()),
+}
+
+// Destination of device reports; e.g. connected host(s)
+type Sink interface {
+	Send(r Report) error
+}
+
+// Reports which release every key and button
+func ReleaseReports() []Report {
+	return []Report{
+		{Type: REPORTKEYBOARD, Data: []byte{0xA1, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		{Type: REPORTMOUSE, Data: []byte{0xA1, 0x01, 0x00, 0x00, 0x00, 0x00}},
+	}
+}
+
+func eventTime(ev *evdev.InputEvent) time.Time {
+	return time.Unix(int64(ev.Time.Sec), int64(ev.Time.Usec)*1000)
+}
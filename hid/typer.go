@@ -0,0 +1,74 @@
+package hid
+
+import (
+	"fmt"
+	"time"
+)
+
+// Types text on a host as keyboard reports
+type Typer struct {
+	Layout Layout
+	// Interval between reports; some hosts drop keys sent back to back
+	Delay time.Duration
+}
+
+func NewTyper(layout Layout, delay time.Duration) *Typer {
+	if layout == nil {
+		layout = LayoutUS
+	}
+	return &Typer{Layout: layout, Delay: delay}
+}
+
+type TypeError struct {
+	r      rune
+	layout string
+}
+
+func (e *TypeError) Error() string {
+	return fmt.Sprintf("TypeError: %q cannot be typed with layout %s", e.r, e.layout)
+}
+
+// Reports pressing and releasing every stroke typing text
+func (t *Typer) Reports(text string) ([]Report, error) {
+	var reps []Report
+	for _, r := range text {
+		strokes, ok := t.Layout.Strokes(r)
+		if !ok {
+			return nil, &TypeError{r: r, layout: t.Layout.Name()}
+		}
+		for _, s := range strokes {
+			var rep macroReport
+			rep.mods = s.Mods
+			rep.set(s.Code, true)
+			reps = append(reps,
+				Report{Type: REPORTKEYBOARD, Data: rep.bytes()},
+				Report{Type: REPORTKEYBOARD, Data: (&macroReport{}).bytes()})
+		}
+	}
+	return reps, nil
+}
+
+// Sends reports typing text to sink; stops releasing keys when cancel is closed
+// Nothing is sent when text cannot be typed
+func (t *Typer) Type(sink Sink, text string, cancel <-chan struct{}) error {
+	reps, err := t.Reports(text)
+	if err != nil {
+		return err
+	}
+
+	for i, rep := range reps {
+		if err := sink.Send(rep); err != nil {
+			return err
+		}
+		select {
+		case <-cancel:
+			if i%2 == 0 {
+				// key is held; release it
+				return sink.Send(reps[i+1])
+			}
+			return nil
+		case <-time.After(t.Delay):
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package hid
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTyperReports(t *testing.T) {
+	tests := []struct {
+		layout string
+		text   string
+		want   [][]byte
+	}{
+		{"us", "a@", [][]byte{kbdReport(0, 0x04), kbdReport(MODLSHIFT, 0x1f)}},
+		{"uk", "@", [][]byte{kbdReport(MODLSHIFT, 0x34)}},
+		// y and z are swapped, AltGr+Q is @
+		{"de", "z@", [][]byte{kbdReport(0, 0x1c), kbdReport(MODRALT, 0x14)}},
+		// dead circumflex, then e
+		{"de", "ê", [][]byte{kbdReport(0, 0x35), kbdReport(0, 0x08)}},
+		// dead key itself is followed by space
+		{"de", "´", [][]byte{kbdReport(0, 0x2e), kbdReport(0, 0x2c)}},
+		{"fr", "aé", [][]byte{kbdReport(0, 0x14), kbdReport(0, 0x1f)}},
+		{"jp", ":", [][]byte{kbdReport(0, 0x34)}},
+	}
+
+	for _, tt := range tests {
+		l, ok := LookupLayout(tt.layout)
+		if !ok {
+			t.Fatal("Layout not found", tt.layout)
+		}
+		reps, err := NewTyper(l, 0).Reports(tt.text)
+		if err != nil {
+			t.Error(tt.layout, tt.text, err)
+			continue
+		}
+		if len(reps) != 2*len(tt.want) {
+			t.Errorf("%s %q: got %d reports, want %d", tt.layout, tt.text, len(reps), 2*len(tt.want))
+			continue
+		}
+		for i, w := range tt.want {
+			if !bytes.Equal(reps[2*i].Data, w) {
+				t.Errorf("%s %q: stroke %d: got %v, want %v", tt.layout, tt.text, i, reps[2*i].Data, w)
+			}
+			if !bytes.Equal(reps[2*i+1].Data, kbdReport(0)) {
+				t.Errorf("%s %q: stroke %d is not released", tt.layout, tt.text, i)
+			}
+		}
+	}
+}
+
+func TestTyperUntypeable(t *testing.T) {
+	if _, err := NewTyper(LayoutUS, 0).Reports("aé"); err == nil {
+		t.Error("Untypeable character is accepted")
+	}
+}
@@ -0,0 +1,346 @@
+// Code generated by gen_usage.go from Linux v6.1 drivers/hid/hid-input.c; DO NOT EDIT.
+
+package hid
+
+// Kernel version keyUsages and usageKeys are generated from
+const USAGETABLEVERSION = "v6.1"
+
+// HID usage(Keyboard page) of evdev key code; 0 is unmapped
+var keyUsages = [195]Usage{
+	1:   0x29, // KEY_ESC
+	2:   0x1e, // KEY_1
+	3:   0x1f, // KEY_2
+	4:   0x20, // KEY_3
+	5:   0x21, // KEY_4
+	6:   0x22, // KEY_5
+	7:   0x23, // KEY_6
+	8:   0x24, // KEY_7
+	9:   0x25, // KEY_8
+	10:  0x26, // KEY_9
+	11:  0x27, // KEY_0
+	12:  0x2d, // KEY_MINUS
+	13:  0x2e, // KEY_EQUAL
+	14:  0x2a, // KEY_BACKSPACE
+	15:  0x2b, // KEY_TAB
+	16:  0x14, // KEY_Q
+	17:  0x1a, // KEY_W
+	18:  0x08, // KEY_E
+	19:  0x15, // KEY_R
+	20:  0x17, // KEY_T
+	21:  0x1c, // KEY_Y
+	22:  0x18, // KEY_U
+	23:  0x0c, // KEY_I
+	24:  0x12, // KEY_O
+	25:  0x13, // KEY_P
+	26:  0x2f, // KEY_LEFTBRACE
+	27:  0x30, // KEY_RIGHTBRACE
+	28:  0x28, // KEY_ENTER
+	29:  0xe0, // KEY_LEFTCTRL
+	30:  0x04, // KEY_A
+	31:  0x16, // KEY_S
+	32:  0x07, // KEY_D
+	33:  0x09, // KEY_F
+	34:  0x0a, // KEY_G
+	35:  0x0b, // KEY_H
+	36:  0x0d, // KEY_J
+	37:  0x0e, // KEY_K
+	38:  0x0f, // KEY_L
+	39:  0x33, // KEY_SEMICOLON
+	40:  0x34, // KEY_APOSTROPHE
+	41:  0x35, // KEY_GRAVE
+	42:  0xe1, // KEY_LEFTSHIFT
+	43:  0x31, // KEY_BACKSLASH
+	44:  0x1d, // KEY_Z
+	45:  0x1b, // KEY_X
+	46:  0x06, // KEY_C
+	47:  0x19, // KEY_V
+	48:  0x05, // KEY_B
+	49:  0x11, // KEY_N
+	50:  0x10, // KEY_M
+	51:  0x36, // KEY_COMMA
+	52:  0x37, // KEY_DOT
+	53:  0x38, // KEY_SLASH
+	54:  0xe5, // KEY_RIGHTSHIFT
+	55:  0x55, // KEY_KPASTERISK
+	56:  0xe2, // KEY_LEFTALT
+	57:  0x2c, // KEY_SPACE
+	58:  0x39, // KEY_CAPSLOCK
+	59:  0x3a, // KEY_F1
+	60:  0x3b, // KEY_F2
+	61:  0x3c, // KEY_F3
+	62:  0x3d, // KEY_F4
+	63:  0x3e, // KEY_F5
+	64:  0x3f, // KEY_F6
+	65:  0x40, // KEY_F7
+	66:  0x41, // KEY_F8
+	67:  0x42, // KEY_F9
+	68:  0x43, // KEY_F10
+	69:  0x53, // KEY_NUMLOCK
+	70:  0x47, // KEY_SCROLLLOCK
+	71:  0x5f, // KEY_KP7
+	72:  0x60, // KEY_KP8
+	73:  0x61, // KEY_KP9
+	74:  0x56, // KEY_KPMINUS
+	75:  0x5c, // KEY_KP4
+	76:  0x5d, // KEY_KP5
+	77:  0x5e, // KEY_KP6
+	78:  0x57, // KEY_KPPLUS
+	79:  0x59, // KEY_KP1
+	80:  0x5a, // KEY_KP2
+	81:  0x5b, // KEY_KP3
+	82:  0x62, // KEY_KP0
+	83:  0x63, // KEY_KPDOT
+	85:  0x94, // KEY_ZENKAKUHANKAKU
+	86:  0x64, // KEY_102ND
+	87:  0x44, // KEY_F11
+	88:  0x45, // KEY_F12
+	89:  0x87, // KEY_RO
+	90:  0x92, // KEY_KATAKANA
+	91:  0x93, // KEY_HIRAGANA
+	92:  0x8a, // KEY_HENKAN
+	93:  0x88, // KEY_KATAKANAHIRAGANA
+	94:  0x8b, // KEY_MUHENKAN
+	95:  0x8c, // KEY_KPJPCOMMA
+	96:  0x58, // KEY_KPENTER
+	97:  0xe4, // KEY_RIGHTCTRL
+	98:  0x54, // KEY_KPSLASH
+	99:  0x46, // KEY_SYSRQ
+	100: 0xe6, // KEY_RIGHTALT
+	102: 0x4a, // KEY_HOME
+	103: 0x52, // KEY_UP
+	104: 0x4b, // KEY_PAGEUP
+	105: 0x50, // KEY_LEFT
+	106: 0x4f, // KEY_RIGHT
+	107: 0x4d, // KEY_END
+	108: 0x51, // KEY_DOWN
+	109: 0x4e, // KEY_PAGEDOWN
+	110: 0x49, // KEY_INSERT
+	111: 0x4c, // KEY_DELETE
+	113: 0x7f, // KEY_MUTE
+	114: 0x81, // KEY_VOLUMEDOWN
+	115: 0x80, // KEY_VOLUMEUP
+	116: 0x66, // KEY_POWER
+	117: 0x67, // KEY_KPEQUAL
+	119: 0x48, // KEY_PAUSE
+	121: 0x85, // KEY_KPCOMMA
+	122: 0x90, // KEY_HANGEUL
+	123: 0x91, // KEY_HANJA
+	124: 0x89, // KEY_YEN
+	125: 0xe3, // KEY_LEFTMETA
+	126: 0xe7, // KEY_RIGHTMETA
+	127: 0x65, // KEY_COMPOSE
+	128: 0x78, // KEY_STOP
+	129: 0x79, // KEY_AGAIN
+	130: 0x76, // KEY_PROPS
+	131: 0x7a, // KEY_UNDO
+	132: 0x77, // KEY_FRONT
+	133: 0x7c, // KEY_COPY
+	134: 0x74, // KEY_OPEN
+	135: 0x7d, // KEY_PASTE
+	136: 0x7e, // KEY_FIND
+	137: 0x7b, // KEY_CUT
+	138: 0x75, // KEY_HELP
+	140: 0xfb, // KEY_CALC
+	142: 0xf8, // KEY_SLEEP
+	150: 0xf0, // KEY_WWW
+	152: 0xf9, // KEY_COFFEE
+	158: 0xf1, // KEY_BACK
+	159: 0xf2, // KEY_FORWARD
+	161: 0xec, // KEY_EJECTCD
+	163: 0xeb, // KEY_NEXTSONG
+	164: 0xe8, // KEY_PLAYPAUSE
+	165: 0xea, // KEY_PREVIOUSSONG
+	166: 0xe9, // KEY_STOPCD
+	173: 0xfa, // KEY_REFRESH
+	176: 0xf7, // KEY_EDIT
+	177: 0xf5, // KEY_SCROLLUP
+	178: 0xf6, // KEY_SCROLLDOWN
+	179: 0xb6, // KEY_KPLEFTPAREN
+	180: 0xb7, // KEY_KPRIGHTPAREN
+	183: 0x68, // KEY_F13
+	184: 0x69, // KEY_F14
+	185: 0x6a, // KEY_F15
+	186: 0x6b, // KEY_F16
+	187: 0x6c, // KEY_F17
+	188: 0x6d, // KEY_F18
+	189: 0x6e, // KEY_F19
+	190: 0x6f, // KEY_F20
+	191: 0x70, // KEY_F21
+	192: 0x71, // KEY_F22
+	193: 0x72, // KEY_F23
+	194: 0x73, // KEY_F24
+}
+
+// evdev key code of HID usage(Keyboard page); 0 is unmapped
+var usageKeys = [256]uint16{
+	0x04: 30,  // KEY_A
+	0x05: 48,  // KEY_B
+	0x06: 46,  // KEY_C
+	0x07: 32,  // KEY_D
+	0x08: 18,  // KEY_E
+	0x09: 33,  // KEY_F
+	0x0a: 34,  // KEY_G
+	0x0b: 35,  // KEY_H
+	0x0c: 23,  // KEY_I
+	0x0d: 36,  // KEY_J
+	0x0e: 37,  // KEY_K
+	0x0f: 38,  // KEY_L
+	0x10: 50,  // KEY_M
+	0x11: 49,  // KEY_N
+	0x12: 24,  // KEY_O
+	0x13: 25,  // KEY_P
+	0x14: 16,  // KEY_Q
+	0x15: 19,  // KEY_R
+	0x16: 31,  // KEY_S
+	0x17: 20,  // KEY_T
+	0x18: 22,  // KEY_U
+	0x19: 47,  // KEY_V
+	0x1a: 17,  // KEY_W
+	0x1b: 45,  // KEY_X
+	0x1c: 21,  // KEY_Y
+	0x1d: 44,  // KEY_Z
+	0x1e: 2,   // KEY_1
+	0x1f: 3,   // KEY_2
+	0x20: 4,   // KEY_3
+	0x21: 5,   // KEY_4
+	0x22: 6,   // KEY_5
+	0x23: 7,   // KEY_6
+	0x24: 8,   // KEY_7
+	0x25: 9,   // KEY_8
+	0x26: 10,  // KEY_9
+	0x27: 11,  // KEY_0
+	0x28: 28,  // KEY_ENTER
+	0x29: 1,   // KEY_ESC
+	0x2a: 14,  // KEY_BACKSPACE
+	0x2b: 15,  // KEY_TAB
+	0x2c: 57,  // KEY_SPACE
+	0x2d: 12,  // KEY_MINUS
+	0x2e: 13,  // KEY_EQUAL
+	0x2f: 26,  // KEY_LEFTBRACE
+	0x30: 27,  // KEY_RIGHTBRACE
+	0x31: 43,  // KEY_BACKSLASH
+	0x32: 43,  // KEY_BACKSLASH
+	0x33: 39,  // KEY_SEMICOLON
+	0x34: 40,  // KEY_APOSTROPHE
+	0x35: 41,  // KEY_GRAVE
+	0x36: 51,  // KEY_COMMA
+	0x37: 52,  // KEY_DOT
+	0x38: 53,  // KEY_SLASH
+	0x39: 58,  // KEY_CAPSLOCK
+	0x3a: 59,  // KEY_F1
+	0x3b: 60,  // KEY_F2
+	0x3c: 61,  // KEY_F3
+	0x3d: 62,  // KEY_F4
+	0x3e: 63,  // KEY_F5
+	0x3f: 64,  // KEY_F6
+	0x40: 65,  // KEY_F7
+	0x41: 66,  // KEY_F8
+	0x42: 67,  // KEY_F9
+	0x43: 68,  // KEY_F10
+	0x44: 87,  // KEY_F11
+	0x45: 88,  // KEY_F12
+	0x46: 99,  // KEY_SYSRQ
+	0x47: 70,  // KEY_SCROLLLOCK
+	0x48: 119, // KEY_PAUSE
+	0x49: 110, // KEY_INSERT
+	0x4a: 102, // KEY_HOME
+	0x4b: 104, // KEY_PAGEUP
+	0x4c: 111, // KEY_DELETE
+	0x4d: 107, // KEY_END
+	0x4e: 109, // KEY_PAGEDOWN
+	0x4f: 106, // KEY_RIGHT
+	0x50: 105, // KEY_LEFT
+	0x51: 108, // KEY_DOWN
+	0x52: 103, // KEY_UP
+	0x53: 69,  // KEY_NUMLOCK
+	0x54: 98,  // KEY_KPSLASH
+	0x55: 55,  // KEY_KPASTERISK
+	0x56: 74,  // KEY_KPMINUS
+	0x57: 78,  // KEY_KPPLUS
+	0x58: 96,  // KEY_KPENTER
+	0x59: 79,  // KEY_KP1
+	0x5a: 80,  // KEY_KP2
+	0x5b: 81,  // KEY_KP3
+	0x5c: 75,  // KEY_KP4
+	0x5d: 76,  // KEY_KP5
+	0x5e: 77,  // KEY_KP6
+	0x5f: 71,  // KEY_KP7
+	0x60: 72,  // KEY_KP8
+	0x61: 73,  // KEY_KP9
+	0x62: 82,  // KEY_KP0
+	0x63: 83,  // KEY_KPDOT
+	0x64: 86,  // KEY_102ND
+	0x65: 127, // KEY_COMPOSE
+	0x66: 116, // KEY_POWER
+	0x67: 117, // KEY_KPEQUAL
+	0x68: 183, // KEY_F13
+	0x69: 184, // KEY_F14
+	0x6a: 185, // KEY_F15
+	0x6b: 186, // KEY_F16
+	0x6c: 187, // KEY_F17
+	0x6d: 188, // KEY_F18
+	0x6e: 189, // KEY_F19
+	0x6f: 190, // KEY_F20
+	0x70: 191, // KEY_F21
+	0x71: 192, // KEY_F22
+	0x72: 193, // KEY_F23
+	0x73: 194, // KEY_F24
+	0x74: 134, // KEY_OPEN
+	0x75: 138, // KEY_HELP
+	0x76: 130, // KEY_PROPS
+	0x77: 132, // KEY_FRONT
+	0x78: 128, // KEY_STOP
+	0x79: 129, // KEY_AGAIN
+	0x7a: 131, // KEY_UNDO
+	0x7b: 137, // KEY_CUT
+	0x7c: 133, // KEY_COPY
+	0x7d: 135, // KEY_PASTE
+	0x7e: 136, // KEY_FIND
+	0x7f: 113, // KEY_MUTE
+	0x80: 115, // KEY_VOLUMEUP
+	0x81: 114, // KEY_VOLUMEDOWN
+	0x85: 121, // KEY_KPCOMMA
+	0x87: 89,  // KEY_RO
+	0x88: 93,  // KEY_KATAKANAHIRAGANA
+	0x89: 124, // KEY_YEN
+	0x8a: 92,  // KEY_HENKAN
+	0x8b: 94,  // KEY_MUHENKAN
+	0x8c: 95,  // KEY_KPJPCOMMA
+	0x90: 122, // KEY_HANGEUL
+	0x91: 123, // KEY_HANJA
+	0x92: 90,  // KEY_KATAKANA
+	0x93: 91,  // KEY_HIRAGANA
+	0x94: 85,  // KEY_ZENKAKUHANKAKU
+	0x9c: 111, // KEY_DELETE
+	0xb6: 179, // KEY_KPLEFTPAREN
+	0xb7: 180, // KEY_KPRIGHTPAREN
+	0xd8: 111, // KEY_DELETE
+	0xe0: 29,  // KEY_LEFTCTRL
+	0xe1: 42,  // KEY_LEFTSHIFT
+	0xe2: 56,  // KEY_LEFTALT
+	0xe3: 125, // KEY_LEFTMETA
+	0xe4: 97,  // KEY_RIGHTCTRL
+	0xe5: 54,  // KEY_RIGHTSHIFT
+	0xe6: 100, // KEY_RIGHTALT
+	0xe7: 126, // KEY_RIGHTMETA
+	0xe8: 164, // KEY_PLAYPAUSE
+	0xe9: 166, // KEY_STOPCD
+	0xea: 165, // KEY_PREVIOUSSONG
+	0xeb: 163, // KEY_NEXTSONG
+	0xec: 161, // KEY_EJECTCD
+	0xed: 115, // KEY_VOLUMEUP
+	0xee: 114, // KEY_VOLUMEDOWN
+	0xef: 113, // KEY_MUTE
+	0xf0: 150, // KEY_WWW
+	0xf1: 158, // KEY_BACK
+	0xf2: 159, // KEY_FORWARD
+	0xf3: 128, // KEY_STOP
+	0xf4: 136, // KEY_FIND
+	0xf5: 177, // KEY_SCROLLUP
+	0xf6: 178, // KEY_SCROLLDOWN
+	0xf7: 176, // KEY_EDIT
+	0xf8: 142, // KEY_SLEEP
+	0xf9: 152, // KEY_COFFEE
+	0xfa: 173, // KEY_REFRESH
+	0xfb: 140, // KEY_CALC
+}
@@ -2,37 +2,170 @@ package gobt
 
 import (
 	"fmt"
+	"sync"
+	"time"
 
 	"golang.org/x/sys/unix"
 
 	"github.com/godbus/dbus"
 	"github.com/potch8228/gobt/bluetooth"
+	"github.com/potch8228/gobt/hid"
 	btlog "github.com/potch8228/gobt/log"
+	"github.com/potch8228/gobt/metrics"
 )
 
+// Interrupt connection accepted before its control channel is handed over by BlueZ
+type pendingIntr struct {
+	sintr    *bluetooth.Bluetooth
+	accepted time.Time
+}
+
 type HidProfile struct {
 	path dbus.ObjectPath
 
+	// Connected hosts keyed by BlueZ device object
+	mu sync.Mutex
 	gb map[dbus.ObjectPath]*GoBt
 
-	connIntr *bluetooth.Bluetooth
+	connIntr    *bluetooth.Bluetooth
+	intrTimeout time.Duration
+	hotkeys     *hid.Hotkeys
+	remapper    *hid.Remapper
+	macros      *hid.Macros
+	policy      *HostPolicy
+	router      *Router
+	devices     *Devices
+	latency     *LatencyStats
 
-	sintr *bluetooth.Bluetooth
-	sctrl *bluetooth.Bluetooth
+	// Interrupt connections matched with control channels by peer address
+	intrMu      sync.Mutex
+	intrWaiters map[bluetooth.Addr]chan *bluetooth.Bluetooth
+	intrPending map[bluetooth.Addr]pendingIntr
 }
 
-func NewHidProfile(path string, connIntr *bluetooth.Bluetooth) *HidProfile {
-	return &HidProfile{
-		path:     (dbus.ObjectPath)(path),
-		gb:       make(map[dbus.ObjectPath]*GoBt),
-		connIntr: connIntr,
+func NewHidProfile(path string, connIntr *bluetooth.Bluetooth, policy *HostPolicy, intrTimeout time.Duration) *HidProfile {
+	p := &HidProfile{
+		path:        (dbus.ObjectPath)(path),
+		gb:          make(map[dbus.ObjectPath]*GoBt),
+		connIntr:    connIntr,
+		intrTimeout: intrTimeout,
+		hotkeys:     hid.NewHotkeys(),
+		remapper:    hid.NewRemapper(nil),
+		macros:      hid.NewMacros(nil),
+		policy:      policy,
+		latency:     NewLatencyStats(),
+		intrWaiters: make(map[bluetooth.Addr]chan *bluetooth.Bluetooth),
+		intrPending: make(map[bluetooth.Addr]pendingIntr),
 	}
+
+	p.router = NewRouter(p.remapper, p.macros)
+	p.devices = NewDevices(p.router, hid.KeyboardConfig{Hotkeys: p.hotkeys, Remapper: p.remapper, Macros: p.macros})
+	// macros are kept local along with device input, and merged with keys held on keyboards
+	p.macros.SetSink(p.devices.Source())
+	p.router.OnSwitch(p.devices.ReleaseAll)
+	p.registerMetrics(metrics.Default)
+
+	go p.acceptIntrLoop()
+	return p
 }
 
 func (p *HidProfile) Path() dbus.ObjectPath {
 	return p.path
 }
 
+// Hotkeys shared by all keyboards forwarded through this profile
+func (p *HidProfile) Hotkeys() *hid.Hotkeys {
+	return p.hotkeys
+}
+
+// Key remapping shared by all keyboards; host profiles are selected by the router
+func (p *HidProfile) Remapper() *hid.Remapper {
+	return p.remapper
+}
+
+// Macros and abbreviations typed to the hosts
+func (p *HidProfile) Macros() *hid.Macros {
+	return p.macros
+}
+
+// Local input devices; running independently of host connections
+func (p *HidProfile) Devices() *Devices {
+	return p.devices
+}
+
+// Router which decides the host receiving input
+func (p *HidProfile) Router() *Router {
+	return p.router
+}
+
+// Report latency of every device and host
+func (p *HidProfile) Latency() *LatencyStats {
+	return p.latency
+}
+
+// Connected hosts in connection order
+func (p *HidProfile) Hosts() []HostStatus {
+	active := p.router.Active()
+	var hs []HostStatus
+	for _, gb := range p.router.Hosts() {
+		st := gb.Status()
+		st.Active = gb == active
+		hs = append(hs, st)
+	}
+	return hs
+}
+
+// Makes connected host addr receive input
+func (p *HidProfile) SwitchTo(addr bluetooth.Addr) error {
+	gb := p.router.Host(addr)
+	if gb == nil {
+		return &HidProfileError{msg: "host not connected", addr: addr}
+	}
+	p.router.SwitchTo(gb)
+	return nil
+}
+
+// Closes connection of host addr
+func (p *HidProfile) Disconnect(addr bluetooth.Addr) error {
+	gb := p.router.Host(addr)
+	if gb == nil {
+		return &HidProfileError{msg: "host not connected", addr: addr}
+	}
+	gb.Close()
+	return nil
+}
+
+// Types text on the active host with its layout; returns when typed
+func (p *HidProfile) Type(text string) error {
+	if p.router.Active() == nil {
+		return &HidProfileError{msg: "no host connected"}
+	}
+	// fails before typing anything when text has characters missing in the layout
+	if _, err := hid.NewTyper(p.macros.Layout(), 0).Reports(text); err != nil {
+		return err
+	}
+	p.macros.Type(text)
+	p.macros.Wait()
+	return nil
+}
+
+// Taps chord on the active host; keys are pressed in order and released in reverse
+func (p *HidProfile) SendChord(c hid.Chord) error {
+	if p.router.Active() == nil {
+		return &HidProfileError{msg: "no host connected"}
+	}
+	var steps []hid.MacroStep
+	for _, code := range c {
+		steps = append(steps, hid.MacroStep{Kind: hid.MACROPRESS, Code: code})
+	}
+	for i := len(c) - 1; i >= 0; i-- {
+		steps = append(steps, hid.MacroStep{Kind: hid.MACRORELEASE, Code: c[i]})
+	}
+	p.macros.Play(steps)
+	p.macros.Wait()
+	return nil
+}
+
 func (p *HidProfile) Release() *dbus.Error {
 	btlog.Debug("Release")
 	return nil
@@ -41,16 +174,14 @@ func (p *HidProfile) Release() *dbus.Error {
 func (p *HidProfile) NewConnection(dev dbus.ObjectPath, fd dbus.UnixFD, fdProps map[string]dbus.Variant) *dbus.Error {
 	btlog.Debug("NewConnection", dev, fd, fdProps)
 
-	var err error
-	p.sintr, err = p.connIntr.Accept()
-	if err != nil {
-		p.connIntr.Close()
-		btlog.Debug("Accept failed", err, bluetooth.PSMINTR)
-		return dbus.NewError(fmt.Sprintf("Accept failed: %v", bluetooth.PSMINTR), []interface{}{err})
+	addr, ok := p.policy.AllowedDevice(dev)
+	if !ok {
+		unix.Close(int(fd))
+		btlog.Debug("NewConnection: host is not allowed", dev, addr)
+		return rejectedError(addr)
 	}
-	btlog.Debug("Connection Accepted", bluetooth.PSMINTR)
 
-	p.sctrl, err = bluetooth.NewBluetoothSocket(int(fd))
+	sctrl, err := bluetooth.NewBluetoothSocket(int(fd))
 	if err != nil {
 		_err := unix.Close(int(fd))
 
@@ -63,28 +194,186 @@ func (p *HidProfile) NewConnection(dev dbus.ObjectPath, fd dbus.UnixFD, fdProps
 	}
 	btlog.Debug("Created New Ctrl Socket")
 
-	p.gb[dev] = NewGoBt(p.sintr, p.sctrl)
+	if raddr := sctrl.RemoteAddr(); raddr != addr {
+		sctrl.Close()
+		btlog.Debug("NewConnection: ctrl peer does not match device", raddr, addr)
+		return rejectedError(raddr)
+	}
+
+	sintr, err := p.waitIntr(addr)
+	if err != nil {
+		sctrl.Close()
+		btlog.Debug("Accept failed", err, bluetooth.PSMINTR)
+		return dbus.NewError(fmt.Sprintf("Accept failed: %v", bluetooth.PSMINTR), []interface{}{err.Error()})
+	}
+	btlog.Debug("Connection Accepted", bluetooth.PSMINTR, addr)
+
+	// host reconnected without disconnection request
+	if old := p.host(dev); old != nil {
+		old.Close()
+	}
+
+	gb := NewGoBt(dev, addr, sintr, sctrl, p.latency)
+	if gb == nil {
+		sintr.Close()
+		sctrl.Close()
+		return dbus.NewError("org.bluez.Error.Failed", []interface{}{"HID session could not be started"})
+	}
+
+	p.router.Add(gb)
+	p.mu.Lock()
+	p.gb[dev] = gb
+	// under p.mu so that a closing host does not ungrab devices after this
+	p.devices.SetConnected(true)
+	p.mu.Unlock()
+
+	hostConnects.With(addr.String()).Inc()
+	hostConnected.Set(addr.String(), 1)
+
+	go p.forgetOnClose(gb)
 	return nil
 }
 
 func (p *HidProfile) RequestDisconnection(dev dbus.ObjectPath) *dbus.Error {
 	btlog.Debug("RequestDisconnection", dev)
-	p.gb[dev].Close()
+	if gb := p.host(dev); gb != nil {
+		gb.Close()
+	}
 	return nil
 }
 
 func (p *HidProfile) Close() {
 	btlog.Debug("Hid Profile will close")
-	for k := range p.gb {
-		p.gb[k].Close()
-		p.gb[k] = nil
+	p.connIntr.Close()
+
+	p.mu.Lock()
+	gbs := make([]*GoBt, 0, len(p.gb))
+	for _, gb := range p.gb {
+		gbs = append(gbs, gb)
+	}
+	p.gb = make(map[dbus.ObjectPath]*GoBt)
+	p.mu.Unlock()
+
+	for _, gb := range gbs {
+		p.router.Remove(gb)
+		gb.Close()
+	}
+	p.devices.Stop()
+
+	p.intrMu.Lock()
+	for addr, pi := range p.intrPending {
+		pi.sintr.Close()
+		delete(p.intrPending, addr)
+	}
+	p.intrMu.Unlock()
+}
+
+func (p *HidProfile) host(dev dbus.ObjectPath) *GoBt {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.gb[dev]
+}
+
+func (p *HidProfile) forgetOnClose(gb *GoBt) {
+	<-gb.Done()
+	p.router.Remove(gb)
+
+	p.mu.Lock()
+	if p.gb[gb.dev] == gb {
+		delete(p.gb, gb.dev)
+		btlog.Debug("Host connection closed", gb.dev, gb.addr)
+		hostConnected.Set(gb.addr.String(), 0)
+	}
+	hostDisconnects.With(gb.addr.String()).Inc()
+	p.devices.SetConnected(len(p.gb) > 0)
+	p.mu.Unlock()
+}
+
+// Accepts interrupt connections and hands them to NewConnection waiting for the same peer
+func (p *HidProfile) acceptIntrLoop() {
+	for {
+		sintr, err := p.connIntr.Accept()
+		if err != nil {
+			btlog.Debug("Interrupt accept loop quitting", err)
+			return
+		}
+		p.handleIntr(sintr, sintr.RemoteAddr())
+	}
+}
+
+// Hands interrupt connection from addr to its waiting control channel or keeps it pending
+func (p *HidProfile) handleIntr(sintr *bluetooth.Bluetooth, addr bluetooth.Addr) {
+	if !p.policy.Allowed(addr) {
+		btlog.Debug("Refused interrupt connection from not allowed host", addr)
+		sintr.Close()
+		return
+	}
+
+	p.intrMu.Lock()
+	defer p.intrMu.Unlock()
+	if w, ok := p.intrWaiters[addr]; ok {
+		delete(p.intrWaiters, addr)
+		w <- sintr
+		return
+	}
+	p.expirePendingLocked()
+	if old, ok := p.intrPending[addr]; ok {
+		old.sintr.Close()
+	}
+	p.intrPending[addr] = pendingIntr{sintr: sintr, accepted: time.Now()}
+}
+
+// Waits for the interrupt connection from addr
+func (p *HidProfile) waitIntr(addr bluetooth.Addr) (*bluetooth.Bluetooth, error) {
+	p.intrMu.Lock()
+	if pi, ok := p.intrPending[addr]; ok {
+		delete(p.intrPending, addr)
+		p.intrMu.Unlock()
+		return pi.sintr, nil
 	}
 
-	if p.sintr != nil {
-		p.sintr.Close()
+	w := make(chan *bluetooth.Bluetooth, 1)
+	p.intrWaiters[addr] = w
+	p.intrMu.Unlock()
+
+	select {
+	case sintr := <-w:
+		return sintr, nil
+	case <-time.After(p.intrTimeout):
+	}
+
+	p.intrMu.Lock()
+	defer p.intrMu.Unlock()
+	if p.intrWaiters[addr] == w {
+		delete(p.intrWaiters, addr)
 	}
+	// accepted just after timing out
+	select {
+	case sintr := <-w:
+		return sintr, nil
+	default:
+	}
+	return nil, unix.ETIMEDOUT
+}
+
+func (p *HidProfile) expirePendingLocked() {
+	for addr, pi := range p.intrPending {
+		if time.Since(pi.accepted) > p.intrTimeout {
+			btlog.Debug("Dropping unmatched interrupt connection", addr)
+			pi.sintr.Close()
+			delete(p.intrPending, addr)
+		}
+	}
+}
+
+type HidProfileError struct {
+	msg  string
+	addr bluetooth.Addr
+}
 
-	if p.sctrl != nil {
-		p.sctrl.Close()
+func (e *HidProfileError) Error() string {
+	if e.addr == bluetooth.ADDRANY {
+		return "HidProfileError: " + e.msg
 	}
+	return fmt.Sprintf("HidProfileError: '%s' host: %s", e.msg, e.addr)
 }
@@ -0,0 +1,195 @@
+package gobt
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/potch8228/gobt/bluetooth"
+)
+
+// Profile matching interrupt connections only; they are handed in with handleIntr
+func newIntrProfile(policy *HostPolicy, timeout time.Duration) *HidProfile {
+	return &HidProfile{
+		intrTimeout: timeout,
+		policy:      policy,
+		intrWaiters: make(map[bluetooth.Addr]chan *bluetooth.Bluetooth),
+		intrPending: make(map[bluetooth.Addr]pendingIntr),
+	}
+}
+
+// Interrupt connection and the non-blocking fd of its peer end
+func intrConn(t *testing.T) (*bluetooth.Bluetooth, int) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET|unix.SOCK_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bt, err := bluetooth.NewBluetoothSocket(fds[0])
+	if err != nil {
+		unix.Close(fds[1])
+		t.Fatal(err)
+	}
+	return bt, fds[1]
+}
+
+// Reports whether the connection of peer end has been closed
+func closed(t *testing.T, peer int) bool {
+	n, err := unix.Read(peer, make([]byte, 1))
+	if err == unix.EAGAIN {
+		return false
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n == 0
+}
+
+func addr(t *testing.T, s string) bluetooth.Addr {
+	a, err := bluetooth.ParseAddr(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+type intrResult struct {
+	sintr *bluetooth.Bluetooth
+	err   error
+}
+
+func waitIntrAsync(p *HidProfile, addr bluetooth.Addr) <-chan intrResult {
+	res := make(chan intrResult, 1)
+	go func() {
+		sintr, err := p.waitIntr(addr)
+		res <- intrResult{sintr, err}
+	}()
+	return res
+}
+
+// Polls until addrs wait for their interrupt connections
+func waitingFor(t *testing.T, p *HidProfile, addrs ...bluetooth.Addr) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		p.intrMu.Lock()
+		n := 0
+		for _, a := range addrs {
+			if _, ok := p.intrWaiters[a]; ok {
+				n++
+			}
+		}
+		p.intrMu.Unlock()
+		if n == len(addrs) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for control channels")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestIntrBeforeControl(t *testing.T) {
+	p := newIntrProfile(nil, time.Second)
+	a := addr(t, "AA:BB:CC:DD:EE:FF")
+	intr, peer := intrConn(t)
+	defer unix.Close(peer)
+	defer intr.Close()
+
+	p.handleIntr(intr, a)
+	sintr, err := p.waitIntr(a)
+	if err != nil || sintr != intr {
+		t.Errorf("waitIntr %v %v", sintr, err)
+	}
+	if n := len(p.intrPending); n != 0 {
+		t.Errorf("%d interrupts still pending", n)
+	}
+}
+
+func TestControlBeforeIntr(t *testing.T) {
+	p := newIntrProfile(nil, time.Second)
+	a := addr(t, "AA:BB:CC:DD:EE:FF")
+	intr, peer := intrConn(t)
+	defer unix.Close(peer)
+	defer intr.Close()
+
+	res := waitIntrAsync(p, a)
+	waitingFor(t, p, a)
+	p.handleIntr(intr, a)
+	if r := <-res; r.err != nil || r.sintr != intr {
+		t.Errorf("waitIntr %v %v", r.sintr, r.err)
+	}
+	if n := len(p.intrWaiters); n != 0 {
+		t.Errorf("%d control channels still waiting", n)
+	}
+}
+
+func TestIntrTimeout(t *testing.T) {
+	p := newIntrProfile(nil, 20*time.Millisecond)
+	a := addr(t, "AA:BB:CC:DD:EE:FF")
+	if _, err := p.waitIntr(a); err != unix.ETIMEDOUT {
+		t.Errorf("waitIntr error %v; want ETIMEDOUT", err)
+	}
+	if n := len(p.intrWaiters); n != 0 {
+		t.Errorf("%d control channels still waiting", n)
+	}
+
+	// unmatched interrupt connections are dropped when the next one comes
+	stale, stalePeer := intrConn(t)
+	defer unix.Close(stalePeer)
+	p.handleIntr(stale, a)
+	time.Sleep(40 * time.Millisecond)
+
+	intr, peer := intrConn(t)
+	defer unix.Close(peer)
+	defer intr.Close()
+	p.handleIntr(intr, addr(t, "11:22:33:44:55:66"))
+	if _, ok := p.intrPending[a]; ok || len(p.intrPending) != 1 {
+		t.Errorf("pending interrupts %v", p.intrPending)
+	}
+	if !closed(t, stalePeer) {
+		t.Error("stale interrupt connection not closed")
+	}
+	if closed(t, peer) {
+		t.Error("pending interrupt connection closed")
+	}
+}
+
+func TestIntrInterleaved(t *testing.T) {
+	p := newIntrProfile(nil, time.Second)
+	a, b := addr(t, "AA:BB:CC:DD:EE:FF"), addr(t, "11:22:33:44:55:66")
+	intrA, peerA := intrConn(t)
+	defer unix.Close(peerA)
+	defer intrA.Close()
+	intrB, peerB := intrConn(t)
+	defer unix.Close(peerB)
+	defer intrB.Close()
+
+	resA := waitIntrAsync(p, a)
+	resB := waitIntrAsync(p, b)
+	waitingFor(t, p, a, b)
+
+	// in the opposite order of the control channels
+	p.handleIntr(intrB, b)
+	p.handleIntr(intrA, a)
+	if r := <-resA; r.err != nil || r.sintr != intrA {
+		t.Errorf("host A got %v %v", r.sintr, r.err)
+	}
+	if r := <-resB; r.err != nil || r.sintr != intrB {
+		t.Errorf("host B got %v %v", r.sintr, r.err)
+	}
+}
+
+func TestIntrNotAllowed(t *testing.T) {
+	p := newIntrProfile(NewHostPolicy([]bluetooth.Addr{addr(t, "AA:BB:CC:DD:EE:FF")}), time.Second)
+	intr, peer := intrConn(t)
+	defer unix.Close(peer)
+
+	p.handleIntr(intr, addr(t, "11:22:33:44:55:66"))
+	if !closed(t, peer) {
+		t.Error("refused interrupt connection not closed")
+	}
+	if n := len(p.intrPending); n != 0 {
+		t.Errorf("%d interrupts pending", n)
+	}
+}
@@ -0,0 +1,75 @@
+package gobt
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/potch8228/gobt/metrics"
+)
+
+// Latency of reports from the evdev event to the socket write
+// Histograms of hosts are keyed by host address; of devices by device name
+type LatencyStats struct {
+	// evdev event to report enqueue, by host
+	Enqueue *metrics.Set
+	// report enqueue to socket write, by host
+	Queue *metrics.Set
+	// evdev event to socket write, by host
+	Hosts *metrics.Set
+	// evdev event to socket write, by device
+	Devices *metrics.Set
+}
+
+func NewLatencyStats() *LatencyStats {
+	return &LatencyStats{
+		Enqueue: metrics.NewSet(metrics.LATENCYBOUNDS),
+		Queue:   metrics.NewSet(metrics.LATENCYBOUNDS),
+		Hosts:   metrics.NewSet(metrics.LATENCYBOUNDS),
+		Devices: metrics.NewSet(metrics.LATENCYBOUNDS),
+	}
+}
+
+// Histograms of one host, looked up once per connection
+type hostLatency struct {
+	enqueue *metrics.Histogram
+	queue   *metrics.Histogram
+	total   *metrics.Histogram
+	devices *metrics.Set
+}
+
+func (l *LatencyStats) host(addr string) *hostLatency {
+	if l == nil {
+		return nil
+	}
+	return &hostLatency{
+		enqueue: l.Enqueue.Get(addr),
+		queue:   l.Queue.Get(addr),
+		total:   l.Hosts.Get(addr),
+		devices: l.Devices,
+	}
+}
+
+// Writes summary of every histogram to w
+func (l *LatencyStats) Dump(w io.Writer) {
+	dump := func(title string, s *metrics.Set) {
+		snaps := s.Snapshot()
+		for _, n := range s.Names() {
+			fmt.Fprintf(w, "%s %s: %v\n", title, n, snaps[n])
+		}
+	}
+	dump("event->enqueue host", l.Enqueue)
+	dump("enqueue->write host", l.Queue)
+	dump("event->write host", l.Hosts)
+	dump("event->write device", l.Devices)
+}
+
+// Records duration since t when t is set
+func observeSince(h *metrics.Histogram, t, now time.Time) {
+	if t.IsZero() {
+		return
+	}
+	if d := now.Sub(t); d > 0 {
+		h.Observe(d)
+	}
+}
@@ -1,12 +1,23 @@
 package log
 
 import (
+	"io"
 	"log"
 	"os"
+	"sync/atomic"
 )
 
 var btlog = NewLogger()
 
+// Debug output of the package functions; starts from DEBUG=1 and may be changed by SetEnabled while logging
+var enabled int32
+
+func init() {
+	if btlog.Enable {
+		enabled = 1
+	}
+}
+
 type Logger struct {
 	logger *log.Logger
 	Enable bool
@@ -30,11 +41,30 @@ func (l *Logger) Debug(args ...interface{}) {
 }
 
 func Debug(args ...interface{}) {
-	if btlog.Enable {
+	if Enabled() {
 		btlog.Debug(args...)
 	}
 }
 
+// Reports whether debug output is enabled; lets callers skip building expensive messages
+func Enabled() bool {
+	return atomic.LoadInt32(&enabled) != 0
+}
+
+// Enables or disables debug output; e.g. from the configuration file
+func SetEnabled(e bool) {
+	var v int32
+	if e {
+		v = 1
+	}
+	atomic.StoreInt32(&enabled, v)
+}
+
+// Sets destination of debug output and fatal errors; standard output by default
+func SetOutput(w io.Writer) {
+	btlog.logger.SetOutput(w)
+}
+
 func ForceDebug(args ...interface{}) {
 	btlog.Debug(args...)
 }
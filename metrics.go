@@ -0,0 +1,49 @@
+package gobt
+
+import (
+	"github.com/potch8228/gobt/metrics"
+)
+
+var (
+	hostConnected   = metrics.Default.Gauge("gobt_host_connected", "1 while the host is connected, 0 after it disconnected.", "host")
+	hostConnects    = metrics.Default.Counter("gobt_host_connects_total", "HID sessions started with the host.", "host")
+	hostDisconnects = metrics.Default.Counter("gobt_host_disconnects_total", "HID sessions of the host closed.", "host")
+	handshakes      = metrics.Default.Counter("gobt_hidp_handshakes_total", "HIDP handshakes sent to hosts, by result.", "result")
+)
+
+// Label of HIDP handshake result code
+func handshakeName(code byte) string {
+	switch code {
+	case HIDPHSHKSUCCESSFUL:
+		return "successful"
+	case HIDPHSHKERRINVALIDREPORTID:
+		return "invalid_report_id"
+	case HIDPHSHKERRUNSUPPORTED:
+		return "unsupported"
+	case HIDPHSHKERRINVALIDPARAM:
+		return "invalid_parameter"
+	}
+	return "unknown"
+}
+
+// Registers metrics read from the profile; queue depths, input devices and latency
+func (p *HidProfile) registerMetrics(r *metrics.Registry) {
+	r.GaugeFunc("gobt_report_queue_depth", "Reports waiting to be written to the host.", "host", func() map[string]float64 {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		depths := make(map[string]float64, len(p.gb))
+		for _, gb := range p.gb {
+			depths[gb.addr.String()] = float64(gb.QueueDepth())
+		}
+		return depths
+	})
+	r.GaugeFunc("gobt_input_devices", "Local input devices attached, by kind.", "kind", func() map[string]float64 {
+		kbds, mses := p.devices.Count()
+		return map[string]float64{"keyboard": float64(kbds), "mouse": float64(mses)}
+	})
+
+	r.Histograms("gobt_report_enqueue_seconds", "Time from the evdev event to queueing the report for the host.", "host", p.latency.Enqueue)
+	r.Histograms("gobt_report_queue_seconds", "Time reports wait in the queue of the host.", "host", p.latency.Queue)
+	r.Histograms("gobt_report_latency_seconds", "Time from the evdev event to writing the report to the host.", "host", p.latency.Hosts)
+	r.Histograms("gobt_device_report_latency_seconds", "Time from the evdev event to writing the report, by device.", "device", p.latency.Devices)
+}
@@ -0,0 +1,160 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Upper bounds of latency buckets; 250us to 512ms doubling
+var LATENCYBOUNDS = []time.Duration{
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	1 * time.Millisecond,
+	2 * time.Millisecond,
+	4 * time.Millisecond,
+	8 * time.Millisecond,
+	16 * time.Millisecond,
+	32 * time.Millisecond,
+	64 * time.Millisecond,
+	128 * time.Millisecond,
+	256 * time.Millisecond,
+	512 * time.Millisecond,
+}
+
+// Counts durations in buckets of fixed upper bounds; safe for concurrent use
+type Histogram struct {
+	mu     sync.Mutex
+	bounds []time.Duration
+	// counts[i] counts durations up to bounds[i]; the last one counts the rest
+	counts []uint64
+	count  uint64
+	sum    time.Duration
+	max    time.Duration
+}
+
+// Creates histogram with ascending bucket bounds
+func NewHistogram(bounds []time.Duration) *Histogram {
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+func (h *Histogram) Observe(d time.Duration) {
+	i := 0
+	for i < len(h.bounds) && d > h.bounds[i] {
+		i++
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[i]++
+	h.count++
+	h.sum += d
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// Copy of the histogram at this moment
+func (h *Histogram) Snapshot() Snapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return Snapshot{
+		Bounds: h.bounds,
+		Counts: append([]uint64(nil), h.counts...),
+		Count:  h.count,
+		Sum:    h.sum,
+		Max:    h.max,
+	}
+}
+
+type Snapshot struct {
+	Bounds []time.Duration
+	// Counts[i] counts durations up to Bounds[i]; Counts[len(Bounds)] counts the rest
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+	Max    time.Duration
+}
+
+func (s Snapshot) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / time.Duration(s.Count)
+}
+
+// Upper bound of the bucket holding quantile q(0 to 1); Max when it is beyond the last bound
+func (s Snapshot) Quantile(q float64) time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	rank := uint64(q*float64(s.Count) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var n uint64
+	for i, c := range s.Counts {
+		n += c
+		if n >= rank {
+			if i < len(s.Bounds) && s.Bounds[i] < s.Max {
+				return s.Bounds[i]
+			}
+			return s.Max
+		}
+	}
+	return s.Max
+}
+
+// Summary like "n=120 mean=3.1ms p50=4ms p90=8ms p99=16ms max=11.2ms"
+func (s Snapshot) String() string {
+	return fmt.Sprintf("n=%d mean=%v p50=%v p90=%v p99=%v max=%v",
+		s.Count, s.Mean(), s.Quantile(0.5), s.Quantile(0.9), s.Quantile(0.99), s.Max)
+}
+
+// Histograms by name, created on first use
+type Set struct {
+	mu     sync.Mutex
+	bounds []time.Duration
+	hs     map[string]*Histogram
+}
+
+func NewSet(bounds []time.Duration) *Set {
+	return &Set{bounds: bounds, hs: make(map[string]*Histogram)}
+}
+
+func (s *Set) Get(name string) *Histogram {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.hs[name]
+	if !ok {
+		h = NewHistogram(s.bounds)
+		s.hs[name] = h
+	}
+	return h
+}
+
+// Names of histograms in order
+func (s *Set) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ns []string
+	for n := range s.hs {
+		ns = append(ns, n)
+	}
+	sort.Strings(ns)
+	return ns
+}
+
+func (s *Set) Snapshot() map[string]Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snaps := make(map[string]Snapshot, len(s.hs))
+	for n, h := range s.hs {
+		snaps[n] = h.Snapshot()
+	}
+	return snaps
+}
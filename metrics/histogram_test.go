@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram([]time.Duration{time.Millisecond, 4 * time.Millisecond, 16 * time.Millisecond})
+	for _, d := range []time.Duration{
+		500 * time.Microsecond,
+		time.Millisecond,
+		2 * time.Millisecond,
+		3 * time.Millisecond,
+		10 * time.Millisecond,
+		40 * time.Millisecond,
+	} {
+		h.Observe(d)
+	}
+
+	s := h.Snapshot()
+	want := []uint64{2, 2, 1, 1}
+	for i := range want {
+		if s.Counts[i] != want[i] {
+			t.Errorf("bucket %d = %d; want %d", i, s.Counts[i], want[i])
+		}
+	}
+	if s.Count != 6 || s.Max != 40*time.Millisecond {
+		t.Errorf("count = %d max = %v", s.Count, s.Max)
+	}
+	if m := s.Mean(); m != 56500*time.Microsecond/6 {
+		t.Errorf("mean = %v", m)
+	}
+	if q := s.Quantile(0.5); q != 4*time.Millisecond {
+		t.Errorf("p50 = %v; want 4ms", q)
+	}
+	if q := s.Quantile(0.99); q != 40*time.Millisecond {
+		t.Errorf("p99 = %v; want max", q)
+	}
+}
+
+func TestHistogramAllocs(t *testing.T) {
+	h := NewHistogram(LATENCYBOUNDS)
+	allocs := testing.AllocsPerRun(100, func() {
+		h.Observe(3 * time.Millisecond)
+	})
+	if allocs != 0 {
+		t.Errorf("Observe allocates %v times", allocs)
+	}
+}
@@ -0,0 +1,252 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Registry used by gobt packages
+var Default = NewRegistry()
+
+// Metrics written in the Prometheus text exposition format
+// Registering a name again replaces the metric
+type Registry struct {
+	mu    sync.Mutex
+	names []string
+	ms    map[string]entry
+}
+
+type entry struct {
+	help string
+	typ  string
+	m    collector
+}
+
+// Writes samples of metric name
+type collector interface {
+	collect(w io.Writer, name string)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{ms: make(map[string]entry)}
+}
+
+func (r *Registry) register(name, help, typ string, m collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.ms[name]; !ok {
+		r.names = append(r.names, name)
+		sort.Strings(r.names)
+	}
+	r.ms[name] = entry{help: help, typ: typ, m: m}
+}
+
+// Counters of label values; label may be empty for one counter
+func (r *Registry) Counter(name, help, label string) *CounterVec {
+	c := &CounterVec{label: label, cs: make(map[string]*Counter)}
+	r.register(name, help, "counter", c)
+	return c
+}
+
+// Gauges of label values set by the caller
+func (r *Registry) Gauge(name, help, label string) *GaugeVec {
+	g := &GaugeVec{label: label, gs: make(map[string]*uint64)}
+	r.register(name, help, "gauge", g)
+	return g
+}
+
+// Gauges read from fn at each scrape; fn gives values by label value
+func (r *Registry) GaugeFunc(name, help, label string, fn func() map[string]float64) {
+	r.register(name, help, "gauge", gaugeFunc{label: label, fn: fn})
+}
+
+// Histograms of set in seconds, labeled by their names in the set
+func (r *Registry) Histograms(name, help, label string, set *Set) {
+	r.register(name, help, "histogram", histograms{label: label, set: set})
+}
+
+// Writes every metric to w
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	names := append([]string(nil), r.names...)
+	ms := make([]entry, len(names))
+	for i, n := range names {
+		ms[i] = r.ms[n]
+	}
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for i, n := range names {
+		fmt.Fprintf(bw, "# HELP %s %s\n", n, ms[i].help)
+		fmt.Fprintf(bw, "# TYPE %s %s\n", n, ms[i].typ)
+		ms[i].m.collect(bw, n)
+	}
+	return bw.Flush()
+}
+
+// Serves WriteText; e.g. on /metrics
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteText(w)
+}
+
+type Counter struct {
+	v uint64
+}
+
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.v, 1)
+}
+
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.v)
+}
+
+type CounterVec struct {
+	label string
+	mu    sync.Mutex
+	cs    map[string]*Counter
+}
+
+// Counter of label value v, created on first use
+func (c *CounterVec) With(v string) *Counter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ct, ok := c.cs[v]
+	if !ok {
+		ct = &Counter{}
+		c.cs[v] = ct
+	}
+	return ct
+}
+
+func (c *CounterVec) collect(w io.Writer, name string) {
+	c.mu.Lock()
+	vals := make(map[string]float64, len(c.cs))
+	for v, ct := range c.cs {
+		vals[v] = float64(ct.Value())
+	}
+	c.mu.Unlock()
+	writeSamples(w, name, c.label, vals)
+}
+
+type GaugeVec struct {
+	label string
+	mu    sync.Mutex
+	// float64 bits
+	gs map[string]*uint64
+}
+
+func (g *GaugeVec) Set(v string, f float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	bits, ok := g.gs[v]
+	if !ok {
+		bits = new(uint64)
+		g.gs[v] = bits
+	}
+	atomic.StoreUint64(bits, math.Float64bits(f))
+}
+
+func (g *GaugeVec) collect(w io.Writer, name string) {
+	g.mu.Lock()
+	vals := make(map[string]float64, len(g.gs))
+	for v, bits := range g.gs {
+		vals[v] = math.Float64frombits(atomic.LoadUint64(bits))
+	}
+	g.mu.Unlock()
+	writeSamples(w, name, g.label, vals)
+}
+
+type gaugeFunc struct {
+	label string
+	fn    func() map[string]float64
+}
+
+func (g gaugeFunc) collect(w io.Writer, name string) {
+	writeSamples(w, name, g.label, g.fn())
+}
+
+type histograms struct {
+	label string
+	set   *Set
+}
+
+func (h histograms) collect(w io.Writer, name string) {
+	snaps := h.set.Snapshot()
+	vs := make([]string, 0, len(snaps))
+	for v := range snaps {
+		vs = append(vs, v)
+	}
+	sort.Strings(vs)
+	for _, v := range vs {
+		s := snaps[v]
+		var n uint64
+		for i, c := range s.Counts {
+			n += c
+			le := "+Inf"
+			if i < len(s.Bounds) {
+				le = formatFloat(s.Bounds[i].Seconds())
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", name, labels(h.label, v, "le", le), n)
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", name, labels(h.label, v), formatFloat(s.Sum.Seconds()))
+		fmt.Fprintf(w, "%s_count%s %d\n", name, labels(h.label, v), s.Count)
+	}
+}
+
+func writeSamples(w io.Writer, name, label string, vals map[string]float64) {
+	for _, v := range sortedKeys(vals) {
+		fmt.Fprintf(w, "%s%s %s\n", name, labels(label, v), formatFloat(vals[v]))
+	}
+}
+
+// Label set like {host="00:11:22:33:44:55"}; pairs with empty names are left out
+func labels(pairs ...string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i] == "" {
+			continue
+		}
+		if b.Len() == 0 {
+			b.WriteByte('{')
+		} else {
+			b.WriteByte(',')
+		}
+		b.WriteString(pairs[i])
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(pairs[i+1]))
+		b.WriteByte('"')
+	}
+	if b.Len() > 0 {
+		b.WriteByte('}')
+	}
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]float64) []string {
+	ks := make([]string, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestRegistryWriteText(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("test_writes_total", "Writes.", "host")
+	c.With("a").Inc()
+	c.With(`b"c`).Inc()
+	c.With("a").Inc()
+	r.Gauge("test_up", "Up.", "").Set("", 1)
+	r.GaugeFunc("test_depth", "Depth.", "host", func() map[string]float64 {
+		return map[string]float64{"a": 3}
+	})
+	set := NewSet([]time.Duration{time.Millisecond, 10 * time.Millisecond})
+	set.Get("a").Observe(500 * time.Microsecond)
+	set.Get("a").Observe(20 * time.Millisecond)
+	r.Histograms("test_latency_seconds", "Latency.", "host", set)
+
+	var b bytes.Buffer
+	if err := r.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP test_depth Depth.
+# TYPE test_depth gauge
+test_depth{host="a"} 3
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{host="a",le="0.001"} 1
+test_latency_seconds_bucket{host="a",le="0.01"} 1
+test_latency_seconds_bucket{host="a",le="+Inf"} 2
+test_latency_seconds_sum{host="a"} 0.0205
+test_latency_seconds_count{host="a"} 2
+# HELP test_up Up.
+# TYPE test_up gauge
+test_up 1
+# HELP test_writes_total Writes.
+# TYPE test_writes_total counter
+test_writes_total{host="a"} 2
+test_writes_total{host="b\"c"} 1
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
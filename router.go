@@ -0,0 +1,359 @@
+package gobt
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gvalkov/golang-evdev"
+	"github.com/potch8228/gobt/bluetooth"
+	"github.com/potch8228/gobt/hid"
+	btlog "github.com/potch8228/gobt/log"
+)
+
+type RouteMode byte
+
+const (
+	// Reports go to the active host only
+	ROUTEACTIVE RouteMode = iota
+	// Reports go to every connected host
+	ROUTEBROADCAST
+)
+
+func (m RouteMode) String() string {
+	if m == ROUTEBROADCAST {
+		return "broadcast"
+	}
+	return "active"
+}
+
+type SwitchConfig struct {
+	Mode RouteMode
+	// Toggles between ROUTEACTIVE and ROUTEBROADCAST
+	ModeHotkey hid.Chord
+
+	// Host slots switched by Hotkeys; empty assigns slots in connection order
+	Hosts []bluetooth.Addr
+	// Hotkeys[i] switches to slot i
+	Hotkeys []hid.Chord
+
+	// Key tapped NextTaps times within NextTapInterval switches to the next host
+	// Zero NextTaps disables it
+	NextTapKey      uint16
+	NextTaps        int
+	NextTapInterval time.Duration
+
+	// Keymap host profile selected while the host is active; hosts without one get no host remapping
+	Keymaps map[bluetooth.Addr]string
+
+	// Layout macros and abbreviations are typed with; Layouts overrides it per host
+	Layout  string
+	Layouts map[bluetooth.Addr]string
+}
+
+func DefaultSwitchConfig() SwitchConfig {
+	cfg := SwitchConfig{
+		NextTapKey:      evdev.KEY_SCROLLLOCK,
+		NextTaps:        2,
+		NextTapInterval: 400 * time.Millisecond,
+		Layout:          "us",
+	}
+	for _, k := range []uint16{evdev.KEY_1, evdev.KEY_2, evdev.KEY_3, evdev.KEY_4} {
+		cfg.Hotkeys = append(cfg.Hotkeys, hid.Chord{evdev.KEY_LEFTCTRL, evdev.KEY_LEFTALT, k})
+	}
+	return cfg
+}
+
+// Routes device reports to the active host or every host
+type Router struct {
+	mu     sync.Mutex
+	mode   RouteMode
+	slots  []bluetooth.Addr
+	hosts  []*GoBt
+	active *GoBt
+
+	remapper *hid.Remapper
+	keymaps  map[bluetooth.Addr]string
+
+	macros  *hid.Macros
+	layout  string
+	layouts map[bluetooth.Addr]string
+	// Shows what the active host types while debugging
+	echo *hid.Echo
+	// Called after the active host changes
+	onSwitch func()
+}
+
+func NewRouter(remapper *hid.Remapper, macros *hid.Macros) *Router {
+	return &Router{remapper: remapper, macros: macros}
+}
+
+// Sets fn called after the active host changes; e.g. to forget keys held on the previous host
+func (r *Router) OnSwitch(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onSwitch = fn
+}
+
+// Applies host slots and registers switching hotkeys
+func (r *Router) Configure(cfg SwitchConfig, hotkeys *hid.Hotkeys) {
+	r.mu.Lock()
+	r.slots = cfg.Hosts
+	r.keymaps = cfg.Keymaps
+	r.layout = cfg.Layout
+	r.layouts = cfg.Layouts
+	r.selectHostLocked()
+	r.mu.Unlock()
+	r.SetMode(cfg.Mode)
+
+	if len(cfg.ModeHotkey) > 0 {
+		hotkeys.Register(cfg.ModeHotkey, func() {
+			if r.Mode() == ROUTEBROADCAST {
+				r.SetMode(ROUTEACTIVE)
+			} else {
+				r.SetMode(ROUTEBROADCAST)
+			}
+		})
+	}
+
+	for i, c := range cfg.Hotkeys {
+		slot := i
+		hotkeys.Register(c, func() {
+			if err := r.Switch(slot); err != nil {
+				btlog.Debug("Router: switching failed", slot, err)
+			}
+		})
+	}
+
+	if cfg.NextTaps > 0 {
+		hotkeys.RegisterTap(cfg.NextTapKey, cfg.NextTaps, cfg.NextTapInterval, r.Next)
+	}
+}
+
+func (r *Router) Mode() RouteMode {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.mode
+}
+
+// Changes routing mode; keys held on hosts which stop receiving input are released
+func (r *Router) SetMode(m RouteMode) {
+	r.mu.Lock()
+	old := r.mode
+	r.mode = m
+	var released []*GoBt
+	if old == ROUTEBROADCAST && m == ROUTEACTIVE {
+		for _, h := range r.hosts {
+			if h != r.active {
+				released = append(released, h)
+			}
+		}
+	}
+	r.mu.Unlock()
+
+	for _, h := range released {
+		release(h)
+	}
+	btlog.Debug("Router: mode", m)
+}
+
+// Sends report to the active host, or every host in ROUTEBROADCAST mode
+// Each host has its own queue so a stalled host does not block the others
+func (r *Router) Send(rep hid.Report) error {
+	r.mu.Lock()
+	if rep.Type == hid.REPORTKEYBOARD && r.echo != nil && btlog.Enabled() {
+		if s := r.echo.Feed(rep.Data); s != "" {
+			btlog.Debug("Router: host types", strconv.Quote(s))
+		}
+	}
+	if r.mode == ROUTEACTIVE {
+		gb := r.active
+		r.mu.Unlock()
+		if gb == nil {
+			return nil
+		}
+		return gb.Send(rep)
+	}
+	hosts := append([]*GoBt(nil), r.hosts...)
+	r.mu.Unlock()
+
+	var rerr error
+	for _, gb := range hosts {
+		if err := gb.Send(rep); err != nil {
+			rerr = err
+		}
+	}
+	return rerr
+}
+
+// Adds connected host; the first host becomes active
+func (r *Router) Add(gb *GoBt) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hosts = append(r.hosts, gb)
+	if r.active == nil {
+		r.active = gb
+		r.selectHostLocked()
+		btlog.Debug("Router: active host", gb.addr)
+	}
+}
+
+func (r *Router) Remove(gb *GoBt) {
+	r.mu.Lock()
+	for i, h := range r.hosts {
+		if h == gb {
+			r.hosts = append(r.hosts[:i], r.hosts[i+1:]...)
+			break
+		}
+	}
+
+	var switched func()
+	if r.active == gb {
+		r.active = nil
+		if len(r.hosts) > 0 {
+			r.active = r.hosts[0]
+			switched = r.onSwitch
+			btlog.Debug("Router: active host", r.active.addr)
+		}
+		r.selectHostLocked()
+	}
+	r.mu.Unlock()
+
+	if switched != nil {
+		switched()
+	}
+}
+
+func (r *Router) Active() *GoBt {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.active
+}
+
+// Connected hosts in connection order
+func (r *Router) Hosts() []*GoBt {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*GoBt(nil), r.hosts...)
+}
+
+// Switches to host in slot
+func (r *Router) Switch(slot int) error {
+	r.mu.Lock()
+	var gb *GoBt
+	switch {
+	case len(r.slots) > 0:
+		if slot < len(r.slots) {
+			gb = r.findLocked(r.slots[slot])
+		}
+	case slot < len(r.hosts):
+		gb = r.hosts[slot]
+	}
+	r.mu.Unlock()
+
+	if gb == nil {
+		return &RouterError{msg: "no host connected in slot", slot: slot}
+	}
+	r.SwitchTo(gb)
+	return nil
+}
+
+// Switches to the next connected host
+func (r *Router) Next() {
+	r.mu.Lock()
+	if len(r.hosts) == 0 {
+		r.mu.Unlock()
+		return
+	}
+	next := r.hosts[0]
+	for i, h := range r.hosts {
+		if h == r.active {
+			next = r.hosts[(i+1)%len(r.hosts)]
+			break
+		}
+	}
+	r.mu.Unlock()
+
+	r.SwitchTo(next)
+}
+
+// Makes gb active after releasing every key held on the previous host
+func (r *Router) SwitchTo(gb *GoBt) {
+	r.mu.Lock()
+	old := r.active
+	if old == gb || r.findLocked(gb.addr) != gb {
+		r.mu.Unlock()
+		return
+	}
+	r.active = gb
+	r.selectHostLocked()
+	broadcast := r.mode == ROUTEBROADCAST
+	switched := r.onSwitch
+	r.mu.Unlock()
+
+	if old != nil && !broadcast {
+		release(old)
+	}
+	if switched != nil {
+		switched()
+	}
+	btlog.Debug("Router: switched host", gb.addr)
+}
+
+// Selects keymap host profile and layout of the active host
+func (r *Router) selectHostLocked() {
+	profile, layout := "", r.layout
+	if r.active != nil {
+		profile = r.keymaps[r.active.addr]
+		if l, ok := r.layouts[r.active.addr]; ok {
+			layout = l
+		}
+	}
+
+	l, ok := hid.LookupLayout(layout)
+	if !ok && layout != "" {
+		btlog.Debug("Router: unknown layout", layout)
+	}
+	r.echo = hid.NewEcho(l)
+
+	if r.remapper != nil {
+		r.remapper.SetProfile(profile)
+	}
+	if r.macros != nil {
+		r.macros.SetLayout(l)
+	}
+}
+
+func release(gb *GoBt) {
+	for _, rep := range hid.ReleaseReports() {
+		if err := gb.Send(rep); err != nil {
+			btlog.Debug("Router: failure on releasing keys", gb.addr, err)
+		}
+	}
+}
+
+// Connected host of addr; nil when not connected
+func (r *Router) Host(addr bluetooth.Addr) *GoBt {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.findLocked(addr)
+}
+
+func (r *Router) findLocked(addr bluetooth.Addr) *GoBt {
+	for _, h := range r.hosts {
+		if h.addr == addr {
+			return h
+		}
+	}
+	return nil
+}
+
+type RouterError struct {
+	msg  string
+	slot int
+}
+
+func (re *RouterError) Error() string {
+	return fmt.Sprintf("RouterError: '%s' slot: %d", re.msg, re.slot)
+}
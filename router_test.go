@@ -0,0 +1,248 @@
+package gobt
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/potch8228/gobt/bluetooth"
+	"github.com/potch8228/gobt/hid"
+)
+
+// Connected host whose interrupt channel is w
+type testHost struct {
+	*GoBt
+	w *gateWriter
+	// Writes already checked
+	read int
+}
+
+// Host which does not write its queued reports until closed
+func newStalledHost(t *testing.T, a string) *testHost {
+	return startTestHost(t, a, &gateWriter{gate: make(chan struct{})})
+}
+
+func newTestHost(t *testing.T, a string) *testHost {
+	return startTestHost(t, a, &gateWriter{})
+}
+
+func startTestHost(t *testing.T, a string, w *gateWriter) *testHost {
+	h := &testHost{
+		GoBt: &GoBt{
+			addr:     addr(t, a),
+			sched:    NewScheduler(w, 0),
+			protocol: HIDPPROTOCOLREPORT,
+			cctl:     make(chan GoBtPollState, 2),
+		},
+		w: w,
+	}
+	go h.sched.Run()
+	return h
+}
+
+func (h *testHost) Close() {
+	if h.w.gate != nil {
+		close(h.w.gate)
+	}
+	h.sched.Close()
+}
+
+// Waits for n more reports written to the host
+func (h *testHost) reports(t *testing.T, n int) [][]byte {
+	got := h.w.wait(t, h.read+n)[h.read : h.read+n]
+	h.read += n
+	return got
+}
+
+// Reports whether no more report was written to the host for a while
+func (h *testHost) idle() bool {
+	time.Sleep(10 * time.Millisecond)
+	h.w.mu.Lock()
+	defer h.w.mu.Unlock()
+	return len(h.w.writes) == h.read
+}
+
+func TestRouterSwitch(t *testing.T) {
+	a, b := newTestHost(t, "AA:BB:CC:DD:EE:01"), newTestHost(t, "AA:BB:CC:DD:EE:02")
+	defer a.Close()
+	defer b.Close()
+
+	r := NewRouter(nil, nil)
+	r.Add(a.GoBt)
+	r.Add(b.GoBt)
+	if r.Active() != a.GoBt {
+		t.Fatal("first host is not active")
+	}
+
+	r.Send(keyboard(0x04))
+	r.SwitchTo(b.GoBt)
+	if r.Active() != b.GoBt {
+		t.Errorf("active %v", r.Active().addr)
+	}
+	// keys held on the previous host are released
+	want := [][]byte{keyboard(0x04).Data}
+	for _, rep := range hid.ReleaseReports() {
+		want = append(want, rep.Data)
+	}
+	got := a.reports(t, len(want))
+	for i := range want {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Errorf("report %d = % x; want % x", i, got[i], want[i])
+		}
+	}
+
+	r.Send(keyboard(0x05))
+	if got := b.reports(t, 1); !bytes.Equal(got[0], keyboard(0x05).Data) {
+		t.Errorf("new host got % x", got)
+	}
+	if !a.idle() {
+		t.Error("previous host got reports")
+	}
+
+	// switching to the active host sends nothing
+	r.SwitchTo(b.GoBt)
+	if !b.idle() {
+		t.Error("active host got reports")
+	}
+
+	r.Next()
+	if r.Active() != a.GoBt {
+		t.Errorf("next host %v", r.Active().addr)
+	}
+	r.Next()
+	if r.Active() != b.GoBt {
+		t.Errorf("next host %v", r.Active().addr)
+	}
+
+	// the first host becomes active when the active one disconnects
+	r.Remove(b.GoBt)
+	if r.Active() != a.GoBt {
+		t.Errorf("active %v after removal", r.Active())
+	}
+	r.Remove(a.GoBt)
+	if r.Active() != nil {
+		t.Errorf("active %v without hosts", r.Active().addr)
+	}
+	if err := r.Send(keyboard(0x04)); err != nil {
+		t.Error("send without hosts:", err)
+	}
+}
+
+// Slots follow the configured hosts, not the connection order
+func TestRouterConfiguredSlots(t *testing.T) {
+	a, b := newTestHost(t, "AA:BB:CC:DD:EE:01"), newTestHost(t, "AA:BB:CC:DD:EE:02")
+	defer a.Close()
+	defer b.Close()
+
+	r := NewRouter(nil, nil)
+	r.Configure(SwitchConfig{Hosts: []bluetooth.Addr{b.addr, addr(t, "AA:BB:CC:DD:EE:03"), a.addr}}, hid.NewHotkeys())
+	r.Add(a.GoBt)
+	r.Add(b.GoBt)
+
+	if err := r.Switch(0); err != nil || r.Active() != b.GoBt {
+		t.Errorf("slot 0: active %v error %v", r.Active().addr, err)
+	}
+	if err := r.Switch(2); err != nil || r.Active() != a.GoBt {
+		t.Errorf("slot 2: active %v error %v", r.Active().addr, err)
+	}
+	// configured host which is not connected
+	if err := r.Switch(1); err == nil {
+		t.Error("switched to a disconnected host")
+	}
+	if err := r.Switch(3); err == nil {
+		t.Error("switched to a slot out of range")
+	}
+	if r.Active() != a.GoBt {
+		t.Errorf("active %v after failed switches", r.Active().addr)
+	}
+}
+
+// Without configured hosts slots are in connection order
+func TestRouterSlotsConnectionOrder(t *testing.T) {
+	a, b := newTestHost(t, "AA:BB:CC:DD:EE:01"), newTestHost(t, "AA:BB:CC:DD:EE:02")
+	defer a.Close()
+	defer b.Close()
+
+	r := NewRouter(nil, nil)
+	r.Add(b.GoBt)
+	r.Add(a.GoBt)
+	if err := r.Switch(1); err != nil || r.Active() != a.GoBt {
+		t.Errorf("slot 1: active %v error %v", r.Active().addr, err)
+	}
+	if err := r.Switch(0); err != nil || r.Active() != b.GoBt {
+		t.Errorf("slot 0: active %v error %v", r.Active().addr, err)
+	}
+	if err := r.Switch(2); err == nil {
+		t.Error("switched to an empty slot")
+	}
+}
+
+func TestRouterBroadcast(t *testing.T) {
+	a, b := newTestHost(t, "AA:BB:CC:DD:EE:01"), newTestHost(t, "AA:BB:CC:DD:EE:02")
+	defer a.Close()
+	defer b.Close()
+
+	r := NewRouter(nil, nil)
+	r.Add(a.GoBt)
+	r.Add(b.GoBt)
+	r.SetMode(ROUTEBROADCAST)
+
+	if err := r.Send(keyboard(0x04)); err != nil {
+		t.Error(err)
+	}
+	for _, h := range []*testHost{a, b} {
+		if got := h.reports(t, 1); !bytes.Equal(got[0], keyboard(0x04).Data) {
+			t.Errorf("host %v got % x", h.addr, got)
+		}
+	}
+
+	// no release while every host keeps receiving input
+	r.SwitchTo(b.GoBt)
+	if !a.idle() {
+		t.Error("keys released in broadcast mode")
+	}
+
+	// keys held on hosts which stop receiving input are released
+	r.SetMode(ROUTEACTIVE)
+	if got := a.reports(t, len(hid.ReleaseReports())); !bytes.Equal(got[0], hid.ReleaseReports()[0].Data) {
+		t.Errorf("inactive host got % x", got)
+	}
+	if !b.idle() {
+		t.Error("active host got reports")
+	}
+}
+
+// A stalled host loses reports but does not hold up the others
+func TestRouterBroadcastStalled(t *testing.T) {
+	a, b := newStalledHost(t, "AA:BB:CC:DD:EE:01"), newTestHost(t, "AA:BB:CC:DD:EE:02")
+	defer a.Close()
+	defer b.Close()
+
+	r := NewRouter(nil, nil)
+	r.Add(a.GoBt)
+	r.Add(b.GoBt)
+	r.SetMode(ROUTEBROADCAST)
+
+	// distinct buttons keep mouse reports from being merged
+	n := SCHEDQUEUELEN + 10
+	var failed int
+	for i := 0; i < n; i++ {
+		if err := r.Send(mouse(byte(i), 1, 0)); err != nil {
+			failed++
+		}
+		if got := b.reports(t, 1); got[0][2] != byte(i) {
+			t.Fatalf("report %d = % x", i, got[0])
+		}
+	}
+	// the first report may have been taken by the stalled writer
+	if failed != n-SCHEDQUEUELEN-1 && failed != n-SCHEDQUEUELEN {
+		t.Errorf("%d reports dropped for the stalled host", failed)
+	}
+	// keyboard reports are never dropped
+	if err := r.Send(keyboard(0x04)); err != nil {
+		t.Error("keyboard report dropped:", err)
+	}
+	if got := b.reports(t, 1); !bytes.Equal(got[0], keyboard(0x04).Data) {
+		t.Errorf("last report = % x", got[0])
+	}
+}
@@ -0,0 +1,299 @@
+package gobt
+
+import (
+	"io"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/potch8228/gobt/hid"
+	btlog "github.com/potch8228/gobt/log"
+)
+
+const (
+	// Reports queued per host before mouse reports are dropped; keyboard reports are always queued
+	SCHEDQUEUELEN = 64
+	// Minimum time between reports written to a host; ~125 reports per second
+	DEFAULTREPORTINTERVAL = 8 * time.Millisecond
+	// Unit of the idle rate set by SET_IDLE
+	IDLERATEUNIT = 4 * time.Millisecond
+)
+
+// Paces reports written on the interrupt channel of a host
+// Mouse reports queued while the link is busy are merged into one; keyboard transitions are kept in order
+type Scheduler struct {
+	mu     sync.Mutex
+	w      io.Writer
+	queue  []queued
+	wake   chan struct{}
+	done   chan struct{}
+	closed bool
+
+	interval time.Duration
+	// Unchanged keyboard report is repeated this often while keys are held; zero reports changes only
+	idle time.Duration
+	// Last report written of each type; relative mouse motion is cleared
+	last map[hid.ReportType][]byte
+
+	// Latency histograms of the host; nil records nothing
+	latency *hostLatency
+}
+
+// Report waiting with the time it was queued
+type queued struct {
+	hid.Report
+	at time.Time
+}
+
+func NewScheduler(w io.Writer, interval time.Duration) *Scheduler {
+	return &Scheduler{
+		w:        w,
+		queue:    make([]queued, 0, SCHEDQUEUELEN),
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		interval: interval,
+		last:     make(map[hid.ReportType][]byte),
+	}
+}
+
+// Records latency of reports written to host addr in stats; call before Run
+func (s *Scheduler) Measure(stats *LatencyStats, addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = stats.host(addr)
+}
+
+// Queues copy of report
+func (s *Scheduler) Send(rep hid.Report) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return &SchedulerError{msg: "scheduler closed"}
+	}
+
+	now := time.Now()
+	if s.latency != nil {
+		observeSince(s.latency.enqueue, rep.Time, now)
+	}
+	if rep.Type == hid.REPORTMOUSE && s.mergeLocked(rep.Data) {
+		return nil
+	}
+	if rep.Type != hid.REPORTKEYBOARD && len(s.queue) >= SCHEDQUEUELEN {
+		return &SchedulerError{msg: "output queue full"}
+	}
+	rep.Data = append([]byte(nil), rep.Data...)
+	s.queue = append(s.queue, queued{Report: rep, at: now})
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Adds mouse motion to the last queued report when it holds the same buttons
+// The merged report keeps the time of its first event
+func (s *Scheduler) mergeLocked(data []byte) bool {
+	if len(s.queue) == 0 || len(data) < 5 {
+		return false
+	}
+	tail := s.queue[len(s.queue)-1].Data
+	if s.queue[len(s.queue)-1].Type != hid.REPORTMOUSE || len(tail) != len(data) || tail[1] != data[1] || tail[2] != data[2] {
+		return false
+	}
+
+	var sums [3]int
+	for i := 3; i < len(data); i++ {
+		sums[i-3] = int(int8(tail[i])) + int(int8(data[i]))
+		if sums[i-3] < -127 || sums[i-3] > 127 {
+			return false
+		}
+	}
+	for i := 3; i < len(data); i++ {
+		tail[i] = byte(int8(sums[i-3]))
+	}
+	return true
+}
+
+// Reports waiting to be written
+func (s *Scheduler) Depth() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.queue)
+}
+
+// Sets idle rate requested by the host with SET_IDLE
+func (s *Scheduler) SetIdle(idle time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.idle = idle
+}
+
+func (s *Scheduler) Idle() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.idle
+}
+
+// Last report of type written; nil before any
+func (s *Scheduler) Last(t hid.ReportType) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]byte(nil), s.last[t]...)
+}
+
+// Writes queued reports until Close
+func (s *Scheduler) Run() {
+	pace, idle := stoppedTimer(), stoppedTimer()
+	var next time.Time
+	for {
+		// mouse reports queued while waiting are merged
+		if d := time.Until(next); d > 0 {
+			pace.Reset(d)
+			select {
+			case <-pace.C:
+			case <-s.done:
+				return
+			}
+		}
+
+		rep, ok := s.pop()
+		if !ok {
+			var repeat <-chan time.Time
+			if d := s.Idle(); d > 0 && s.holding() {
+				idle.Reset(d)
+				repeat = idle.C
+			}
+			select {
+			case <-s.wake:
+				stopTimer(idle)
+				continue
+			case <-repeat:
+				rep = queued{Report: hid.Report{Type: hid.REPORTKEYBOARD, Data: s.Last(hid.REPORTKEYBOARD)}}
+			case <-s.done:
+				return
+			}
+		}
+
+		_, err := s.w.Write(rep.Data)
+		now := time.Now()
+		next = now.Add(s.interval)
+		switch {
+		case err == syscall.EAGAIN:
+			// link is congested; the report is written again after the interval
+			// a repeated report is not queued and waits for the next repeat
+			if !rep.at.IsZero() {
+				s.requeue(rep)
+			}
+			next = now.Add(s.retryInterval())
+		case err != nil:
+			btlog.Debug("Scheduler: failure on sending report", rep.Type, err)
+		default:
+			s.written(rep, now)
+		}
+	}
+}
+
+// Wait before writing again a report which got EAGAIN
+func (s *Scheduler) retryInterval() time.Duration {
+	if s.interval > 0 {
+		return s.interval
+	}
+	return DEFAULTREPORTINTERVAL
+}
+
+func stoppedTimer() *time.Timer {
+	t := time.NewTimer(time.Hour)
+	t.Stop()
+	return t
+}
+
+// Stops timer and drains its channel so it can be Reset
+func stopTimer(t *time.Timer) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+}
+
+func (s *Scheduler) pop() (queued, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.queue) == 0 {
+		return queued{}, false
+	}
+	rep := s.queue[0]
+	copy(s.queue, s.queue[1:])
+	s.queue[len(s.queue)-1] = queued{}
+	s.queue = s.queue[:len(s.queue)-1]
+	return rep, true
+}
+
+// Puts report back at the head of the queue
+func (s *Scheduler) requeue(rep queued) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.queue = append(s.queue, queued{})
+	copy(s.queue[1:], s.queue)
+	s.queue[0] = rep
+}
+
+func (s *Scheduler) written(rep queued, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l := s.latency; l != nil {
+		observeSince(l.queue, rep.at, now)
+		observeSince(l.total, rep.Time, now)
+		if !rep.Time.IsZero() && rep.Device != "" {
+			observeSince(l.devices.Get(rep.Device), rep.Time, now)
+		}
+	}
+	last := append(s.last[rep.Type][:0], rep.Data...)
+	if rep.Type == hid.REPORTMOUSE {
+		for i := 3; i < len(last); i++ {
+			last[i] = 0x00
+		}
+	}
+	s.last[rep.Type] = last
+}
+
+// Reports whether the last keyboard report holds any key
+func (s *Scheduler) holding() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kbd := s.last[hid.REPORTKEYBOARD]
+	if len(kbd) < 3 {
+		return false
+	}
+	for _, b := range kbd[2:] {
+		if b != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Stops Run; queued reports are discarded. Safe to call more than once
+func (s *Scheduler) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.queue = s.queue[:0]
+	close(s.done)
+}
+
+type SchedulerError struct {
+	msg string
+}
+
+func (e *SchedulerError) Error() string {
+	return "SchedulerError: " + e.msg
+}
@@ -0,0 +1,219 @@
+package gobt
+
+import (
+	"bytes"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/potch8228/gobt/hid"
+)
+
+// Records writes; each write waits for gate while it is set
+type gateWriter struct {
+	mu     sync.Mutex
+	writes [][]byte
+	gate   chan struct{}
+}
+
+func (w *gateWriter) Write(b []byte) (int, error) {
+	if w.gate != nil {
+		<-w.gate
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writes = append(w.writes, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+func (w *gateWriter) wait(t *testing.T, n int) [][]byte {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		w.mu.Lock()
+		got := len(w.writes)
+		w.mu.Unlock()
+		if got >= n {
+			break
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.writes) < n {
+		t.Fatalf("got %d writes; want %d", len(w.writes), n)
+	}
+	return append([][]byte(nil), w.writes...)
+}
+
+func mouse(buttons byte, x, y int8) hid.Report {
+	return hid.Report{Type: hid.REPORTMOUSE, Data: []byte{0xA1, 0x01, buttons, byte(x), byte(y), 0x00}}
+}
+
+func keyboard(key byte) hid.Report {
+	return hid.Report{Type: hid.REPORTKEYBOARD, Data: []byte{0xA1, 0x02, 0x00, 0x00, key, 0x00, 0x00, 0x00, 0x00, 0x00}}
+}
+
+func TestSchedulerMergesMouse(t *testing.T) {
+	w := &gateWriter{gate: make(chan struct{})}
+	s := NewScheduler(w, 0)
+	go s.Run()
+	defer s.Close()
+
+	// first report keeps the writer busy
+	s.Send(mouse(0, 1, 1))
+	time.Sleep(10 * time.Millisecond)
+	s.Send(mouse(0, 100, -5))
+	s.Send(mouse(0, 20, -5))
+	s.Send(mouse(0, 10, 0))
+	s.Send(keyboard(0x04))
+	s.Send(mouse(0x01, 1, 0))
+	s.Send(mouse(0x01, 1, 0))
+	if d := s.Depth(); d != 4 {
+		t.Errorf("queue depth = %d; want 4", d)
+	}
+	close(w.gate)
+
+	want := [][]byte{
+		mouse(0, 1, 1).Data,
+		mouse(0, 120, -10).Data,
+		mouse(0, 10, 0).Data,
+		keyboard(0x04).Data,
+		mouse(0x01, 2, 0).Data,
+	}
+	got := w.wait(t, len(want))
+	for i := range want {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Errorf("write %d = % x; want % x", i, got[i], want[i])
+		}
+	}
+}
+
+func TestSchedulerKeepsKeyboard(t *testing.T) {
+	w := &gateWriter{gate: make(chan struct{})}
+	s := NewScheduler(w, 0)
+	go s.Run()
+	defer s.Close()
+
+	n := SCHEDQUEUELEN * 2
+	for i := 0; i < n; i++ {
+		if err := s.Send(keyboard(byte(i))); err != nil {
+			t.Fatal("keyboard report dropped", i, err)
+		}
+	}
+	if err := s.Send(mouse(0x01, 0, 0)); err == nil {
+		t.Error("mouse report queued beyond the queue length")
+	}
+	close(w.gate)
+
+	got := w.wait(t, n)
+	for i := 0; i < n; i++ {
+		if got[i][4] != byte(i) {
+			t.Fatalf("write %d = % x; keyboard reports out of order", i, got[i])
+		}
+	}
+}
+
+// Fails the first busy writes with EAGAIN, as a congested non-blocking socket does
+type busyWriter struct {
+	gateWriter
+	busy  int
+	tries int
+}
+
+func (w *busyWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	w.tries++
+	if w.busy > 0 {
+		w.busy--
+		w.mu.Unlock()
+		return -1, syscall.EAGAIN
+	}
+	w.mu.Unlock()
+	return w.gateWriter.Write(b)
+}
+
+func TestSchedulerRetriesBusy(t *testing.T) {
+	w := &busyWriter{busy: 3}
+	s := NewScheduler(w, time.Millisecond)
+	go s.Run()
+	defer s.Close()
+
+	s.Send(keyboard(0x04))
+	s.Send(keyboard(0x00))
+	s.Send(keyboard(0x05))
+	if last := s.Last(hid.REPORTKEYBOARD); len(last) != 0 {
+		t.Errorf("last report %x before any successful write", last)
+	}
+
+	writes := w.wait(t, 3)
+	for i, want := range [][]byte{keyboard(0x04).Data, keyboard(0x00).Data, keyboard(0x05).Data} {
+		if !bytes.Equal(writes[i], want) {
+			t.Errorf("write %d = %x; want %x", i, writes[i], want)
+		}
+	}
+	w.mu.Lock()
+	if w.tries != 6 {
+		t.Errorf("%d writes tried; want 6", w.tries)
+	}
+	w.mu.Unlock()
+	if last := s.Last(hid.REPORTKEYBOARD); !bytes.Equal(last, keyboard(0x05).Data) {
+		t.Errorf("last report %x", last)
+	}
+}
+
+func TestSchedulerIdle(t *testing.T) {
+	w := &gateWriter{}
+	s := NewScheduler(w, 0)
+	s.SetIdle(10 * time.Millisecond)
+	go s.Run()
+	defer s.Close()
+
+	s.Send(keyboard(0x04))
+	got := w.wait(t, 3)
+	for _, b := range got {
+		if !bytes.Equal(b, keyboard(0x04).Data) {
+			t.Errorf("repeated % x; want held key", b)
+		}
+	}
+
+	s.Send(keyboard(0x00))
+	time.Sleep(20 * time.Millisecond)
+	w.mu.Lock()
+	n := len(w.writes)
+	w.mu.Unlock()
+	time.Sleep(40 * time.Millisecond)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.writes) != n {
+		t.Errorf("released keyboard repeated %d times", len(w.writes)-n)
+	}
+}
+
+func TestSchedulerLatency(t *testing.T) {
+	stats := NewLatencyStats()
+	w := &gateWriter{}
+	s := NewScheduler(w, 0)
+	s.Measure(stats, "host")
+	go s.Run()
+	defer s.Close()
+
+	rep := keyboard(0x04)
+	rep.Time, rep.Device = time.Now().Add(-5*time.Millisecond), "kbd"
+	s.Send(rep)
+	// generated reports have no event time
+	s.Send(keyboard(0x00))
+	w.wait(t, 2)
+	time.Sleep(5 * time.Millisecond)
+
+	if n := stats.Enqueue.Get("host").Snapshot().Count; n != 1 {
+		t.Errorf("event->enqueue count = %d; want 1", n)
+	}
+	if n := stats.Queue.Get("host").Snapshot().Count; n != 2 {
+		t.Errorf("enqueue->write count = %d; want 2", n)
+	}
+	snap := stats.Devices.Get("kbd").Snapshot()
+	if snap.Count != 1 || snap.Max < 5*time.Millisecond {
+		t.Errorf("device latency %v; want one report of at least 5ms", snap)
+	}
+}
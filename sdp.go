@@ -0,0 +1,245 @@
+package gobt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/xml"
+	"text/template"
+
+	"github.com/potch8228/gobt/hid"
+)
+
+// Device subclass; used for both HID SDP record (0x0202) and the minor Class of Device
+type DeviceClass byte
+
+const (
+	CLASSKEYBOARD DeviceClass = 0x40
+	CLASSMOUSE    DeviceClass = 0x80
+	CLASSCOMBO    DeviceClass = 0xc0
+)
+
+const (
+	// Major Device Class: Peripheral
+	CODMAJORPERIPHERAL = 0x05
+
+	// Vendor ID Source for the Device ID record
+	VIDSOURCEBLUETOOTH = 0x0001
+	VIDSOURCEUSB       = 0x0002
+
+	DIDUUID = "00001200-0000-1000-8000-00805f9b34fb"
+)
+
+// Describes how gobt presents itself to hosts
+type Identity struct {
+	ServiceName string
+	Description string
+	Provider    string
+
+	// HID country code (0x0203); 0 for not localized, 33 for US, 15 for Japan etc.
+	CountryCode byte
+	Class       DeviceClass
+
+	// Device ID (PnP Information) record
+	VendorIDSource uint16
+	VendorID       uint16
+	ProductID      uint16
+	Version        uint16
+}
+
+func DefaultIdentity() Identity {
+	return Identity{
+		ServiceName:    "Raspberry Pi Virtual Keyboard",
+		Description:    "USB > BT Keyboard",
+		Provider:       "Raspberry Pi",
+		CountryCode:    0x00,
+		Class:          CLASSKEYBOARD,
+		VendorIDSource: VIDSOURCEUSB,
+		VendorID:       0x1d6b, // Linux Foundation
+		ProductID:      0x0246, // BlueZ
+		Version:        0x0100,
+	}
+}
+
+// Returns major and minor Class of Device fields for the adapter
+func (id Identity) ClassOfDevice() (byte, byte) {
+	return CODMAJORPERIPHERAL, byte(id.Class)
+}
+
+// Generates HID profile SDP record
+func (id Identity) ServiceRecord() (string, error) {
+	return executeRecord(hidRecordTmpl, id)
+}
+
+// Generates Device ID (PnP Information) SDP record
+func (id Identity) DeviceIDRecord() (string, error) {
+	return executeRecord(didRecordTmpl, id)
+}
+
+func executeRecord(tmpl *template.Template, id Identity) (string, error) {
+	b := new(bytes.Buffer)
+	if err := tmpl.Execute(b, id); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+var recordFuncs = template.FuncMap{
+	"xml": func(s string) (string, error) {
+		b := new(bytes.Buffer)
+		err := xml.EscapeText(b, []byte(s))
+		return b.String(), err
+	},
+	"descriptor": func() string {
+		return hex.EncodeToString(hid.ReportDescriptor)
+	},
+}
+
+// Bluetooth raw SDP record which is from Liam Fraser's implementation
+// See README.md for original link
+var hidRecordTmpl = template.Must(template.New("hid").Funcs(recordFuncs).Parse(`<?xml version="1.0" encoding="UTF-8" ?>
+<record>
+	<attribute id="0x0001">
+		<sequence>
+			<uuid value="0x1124" />
+		</sequence>
+	</attribute>
+	<attribute id="0x0004">
+		<sequence>
+			<sequence>
+				<uuid value="0x0100" />
+				<uint16 value="0x0011" />
+			</sequence>
+			<sequence>
+				<uuid value="0x0011" />
+			</sequence>
+		</sequence>
+	</attribute>
+	<attribute id="0x0005">
+		<sequence>
+			<uuid value="0x1002" />
+		</sequence>
+	</attribute>
+	<attribute id="0x0006">
+		<sequence>
+			<uint16 value="0x656e" />
+			<uint16 value="0x006a" />
+			<uint16 value="0x0100" />
+		</sequence>
+	</attribute>
+	<attribute id="0x0009">
+		<sequence>
+			<sequence>
+				<uuid value="0x1124" />
+				<uint16 value="0x0100" />
+			</sequence>
+		</sequence>
+	</attribute>
+	<attribute id="0x000d">
+		<sequence>
+			<sequence>
+				<sequence>
+					<uuid value="0x0100" />
+					<uint16 value="0x0013" />
+				</sequence>
+				<sequence>
+					<uuid value="0x0011" />
+				</sequence>
+			</sequence>
+		</sequence>
+	</attribute>
+	<attribute id="0x0100">
+		<text value="{{xml .ServiceName}}" />
+	</attribute>
+	<attribute id="0x0101">
+		<text value="{{xml .Description}}" />
+	</attribute>
+	<attribute id="0x0102">
+		<text value="{{xml .Provider}}" />
+	</attribute>
+	<attribute id="0x0200">
+		<uint16 value="{{printf "0x%04x" .Version}}" />
+	</attribute>
+	<attribute id="0x0201">
+		<uint16 value="0x0111" />
+	</attribute>
+	<attribute id="0x0202">
+		<uint8 value="{{printf "0x%02x" .Class}}" />
+	</attribute>
+	<attribute id="0x0203">
+		<uint8 value="{{printf "0x%02x" .CountryCode}}" />
+	</attribute>
+	<attribute id="0x0204">
+		<boolean value="true" />
+	</attribute>
+	<attribute id="0x0205">
+		<boolean value="true" />
+	</attribute>
+	<attribute id="0x0206">
+		<sequence>
+			<sequence>
+				<uint8 value="0x22" />
+				<text encoding="hex" value="{{descriptor}}" />
+			</sequence>
+		</sequence>
+	</attribute>
+	<attribute id="0x0207">
+		<sequence>
+			<sequence>
+				<uint16 value="0x0409" />
+				<uint16 value="0x0100" />
+			</sequence>
+		</sequence>
+	</attribute>
+	<attribute id="0x020b">
+		<uint16 value="0x0100" />
+	</attribute>
+	<attribute id="0x020c">
+		<uint16 value="0x0c80" />
+	</attribute>
+	<attribute id="0x020d">
+		<boolean value="false" />
+	</attribute>
+	<attribute id="0x020e">
+		<boolean value="true" />
+	</attribute>
+	<attribute id="0x020f">
+		<uint16 value="0x0640" />
+	</attribute>
+	<attribute id="0x0210">
+		<uint16 value="0x0320" />
+	</attribute>
+</record>
+`))
+
+var didRecordTmpl = template.Must(template.New("did").Funcs(recordFuncs).Parse(`<?xml version="1.0" encoding="UTF-8" ?>
+<record>
+	<attribute id="0x0001">
+		<sequence>
+			<uuid value="0x1200" />
+		</sequence>
+	</attribute>
+	<attribute id="0x0005">
+		<sequence>
+			<uuid value="0x1002" />
+		</sequence>
+	</attribute>
+	<attribute id="0x0200">
+		<uint16 value="0x0103" />
+	</attribute>
+	<attribute id="0x0201">
+		<uint16 value="{{printf "0x%04x" .VendorID}}" />
+	</attribute>
+	<attribute id="0x0202">
+		<uint16 value="{{printf "0x%04x" .ProductID}}" />
+	</attribute>
+	<attribute id="0x0203">
+		<uint16 value="{{printf "0x%04x" .Version}}" />
+	</attribute>
+	<attribute id="0x0204">
+		<boolean value="true" />
+	</attribute>
+	<attribute id="0x0205">
+		<uint16 value="{{printf "0x%04x" .VendorIDSource}}" />
+	</attribute>
+</record>
+`))
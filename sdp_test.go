@@ -0,0 +1,153 @@
+package gobt
+
+import (
+	"encoding/hex"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/potch8228/gobt/hid"
+)
+
+// Data element of an SDP record; sequences hold further elements
+type sdpElement struct {
+	XMLName  xml.Name
+	Value    string       `xml:"value,attr"`
+	Elements []sdpElement `xml:",any"`
+}
+
+type sdpRecord struct {
+	Attributes []struct {
+		ID       string       `xml:"id,attr"`
+		Elements []sdpElement `xml:",any"`
+	} `xml:"attribute"`
+}
+
+// Values of attributes holding a single element, keyed by attribute ID
+func parseRecord(t *testing.T, record string) (map[string]string, sdpRecord) {
+	var rec sdpRecord
+	if err := xml.Unmarshal([]byte(record), &rec); err != nil {
+		t.Fatalf("invalid record: %v\n%s", err, record)
+	}
+	values := make(map[string]string)
+	for _, a := range rec.Attributes {
+		if len(a.Elements) == 1 && a.Elements[0].XMLName.Local != "sequence" {
+			values[a.ID] = a.Elements[0].Value
+		}
+	}
+	return values, rec
+}
+
+func TestServiceRecord(t *testing.T) {
+	escaped := DefaultIdentity()
+	escaped.ServiceName = `Tom & "Jerry's" <Keyboard>`
+	escaped.Description = "USB > BT & more"
+	escaped.Provider = "<none>"
+	combo := DefaultIdentity()
+	combo.Class, combo.CountryCode, combo.Version = CLASSCOMBO, 33, 0x0111
+
+	for _, c := range []struct {
+		name string
+		id   Identity
+		want map[string]string
+	}{
+		{"default", DefaultIdentity(), map[string]string{
+			"0x0100": "Raspberry Pi Virtual Keyboard",
+			"0x0101": "USB > BT Keyboard",
+			"0x0102": "Raspberry Pi",
+			"0x0200": "0x0100",
+			"0x0202": "0x40",
+			"0x0203": "0x00",
+		}},
+		{"escaped", escaped, map[string]string{
+			"0x0100": `Tom & "Jerry's" <Keyboard>`,
+			"0x0101": "USB > BT & more",
+			"0x0102": "<none>",
+		}},
+		{"combo", combo, map[string]string{
+			"0x0200": "0x0111",
+			"0x0202": "0xc0",
+			"0x0203": "0x21",
+		}},
+	} {
+		record, err := c.id.ServiceRecord()
+		if err != nil {
+			t.Fatal(c.name, err)
+		}
+		values, rec := parseRecord(t, record)
+		for id, want := range c.want {
+			if values[id] != want {
+				t.Errorf("%s: attribute %s = %q; want %q", c.name, id, values[id], want)
+			}
+		}
+
+		for _, a := range rec.Attributes {
+			if a.ID != "0x0206" {
+				continue
+			}
+			desc := a.Elements[0].Elements[0].Elements[1].Value
+			if desc != hex.EncodeToString(hid.ReportDescriptor) {
+				t.Errorf("%s: report descriptor %s", c.name, desc)
+			}
+		}
+	}
+}
+
+func TestDeviceIDRecord(t *testing.T) {
+	bt := DefaultIdentity()
+	bt.VendorIDSource, bt.VendorID, bt.ProductID, bt.Version = VIDSOURCEBLUETOOTH, 0x000f, 0x1234, 0x0201
+	// names do not appear in the Device ID record
+	bt.ServiceName = "<&>"
+
+	for _, c := range []struct {
+		name string
+		id   Identity
+		want map[string]string
+	}{
+		{"default", DefaultIdentity(), map[string]string{
+			"0x0200": "0x0103",
+			"0x0201": "0x1d6b",
+			"0x0202": "0x0246",
+			"0x0203": "0x0100",
+			"0x0204": "true",
+			"0x0205": "0x0002",
+		}},
+		{"bluetooth", bt, map[string]string{
+			"0x0201": "0x000f",
+			"0x0202": "0x1234",
+			"0x0203": "0x0201",
+			"0x0205": "0x0001",
+		}},
+	} {
+		record, err := c.id.DeviceIDRecord()
+		if err != nil {
+			t.Fatal(c.name, err)
+		}
+		if strings.Contains(record, "<&>") {
+			t.Errorf("%s: service name in record", c.name)
+		}
+		values, _ := parseRecord(t, record)
+		for id, want := range c.want {
+			if values[id] != want {
+				t.Errorf("%s: attribute %s = %q; want %q", c.name, id, values[id], want)
+			}
+		}
+	}
+}
+
+func TestClassOfDevice(t *testing.T) {
+	for _, c := range []struct {
+		class DeviceClass
+		minor byte
+	}{
+		{CLASSKEYBOARD, 0x40},
+		{CLASSMOUSE, 0x80},
+		{CLASSCOMBO, 0xc0},
+	} {
+		id := DefaultIdentity()
+		id.Class = c.class
+		if major, minor := id.ClassOfDevice(); major != CODMAJORPERIPHERAL || minor != c.minor {
+			t.Errorf("class %#x: %#x %#x", c.class, major, minor)
+		}
+	}
+}
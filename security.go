@@ -0,0 +1,74 @@
+package gobt
+
+import (
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus"
+	"github.com/potch8228/gobt/bluetooth"
+)
+
+type SecurityConfig struct {
+	// Hosts allowed to pair and connect; empty allows any host
+	AllowedHosts []bluetooth.Addr
+	// How long to wait for the interrupt channel after the control channel is connected
+	InterruptTimeout time.Duration
+}
+
+func DefaultSecurityConfig() SecurityConfig {
+	return SecurityConfig{
+		InterruptTimeout: 10 * time.Second,
+	}
+}
+
+// Decides which hosts are allowed; shared by Agent and HidProfile
+type HostPolicy struct {
+	mu      sync.RWMutex
+	allowed map[bluetooth.Addr]bool
+}
+
+func NewHostPolicy(hosts []bluetooth.Addr) *HostPolicy {
+	hp := &HostPolicy{}
+	hp.SetAllowed(hosts)
+	return hp
+}
+
+// Replaces the allow-list; empty allows any host
+func (hp *HostPolicy) SetAllowed(hosts []bluetooth.Addr) {
+	allowed := make(map[bluetooth.Addr]bool, len(hosts))
+	for _, h := range hosts {
+		allowed[h] = true
+	}
+
+	hp.mu.Lock()
+	defer hp.mu.Unlock()
+	hp.allowed = allowed
+}
+
+func (hp *HostPolicy) Allowed(addr bluetooth.Addr) bool {
+	if hp == nil {
+		return true
+	}
+	hp.mu.RLock()
+	defer hp.mu.RUnlock()
+	return len(hp.allowed) == 0 || hp.allowed[addr]
+}
+
+// Checks host of BlueZ device object(e.g. /org/bluez/hci0/dev_AA_BB_CC_DD_EE_FF)
+func (hp *HostPolicy) AllowedDevice(dev dbus.ObjectPath) (bluetooth.Addr, bool) {
+	addr, err := deviceAddr(dev)
+	if err != nil {
+		return addr, false
+	}
+	return addr, hp.Allowed(addr)
+}
+
+func deviceAddr(dev dbus.ObjectPath) (bluetooth.Addr, error) {
+	return bluetooth.ParseAddr(strings.TrimPrefix(path.Base(string(dev)), "dev_"))
+}
+
+func rejectedError(addr bluetooth.Addr) *dbus.Error {
+	return dbus.NewError(AGENTERRREJECTED, []interface{}{"host " + addr.String() + " is not allowed"})
+}
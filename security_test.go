@@ -0,0 +1,68 @@
+package gobt
+
+import (
+	"testing"
+
+	"github.com/godbus/dbus"
+	"github.com/potch8228/gobt/bluetooth"
+)
+
+func TestHostPolicyAllowed(t *testing.T) {
+	a, _ := bluetooth.ParseAddr("AA:BB:CC:DD:EE:FF")
+	b, _ := bluetooth.ParseAddr("11:22:33:44:55:66")
+
+	for _, c := range []struct {
+		name    string
+		policy  *HostPolicy
+		addr    bluetooth.Addr
+		allowed bool
+	}{
+		{"nil policy", nil, a, true},
+		{"empty list", NewHostPolicy(nil), a, true},
+		{"listed host", NewHostPolicy([]bluetooth.Addr{a}), a, true},
+		{"other host", NewHostPolicy([]bluetooth.Addr{a}), b, false},
+		{"second listed host", NewHostPolicy([]bluetooth.Addr{a, b}), b, true},
+	} {
+		if got := c.policy.Allowed(c.addr); got != c.allowed {
+			t.Errorf("%s: Allowed(%s) = %v", c.name, c.addr, got)
+		}
+	}
+}
+
+func TestHostPolicyAllowedDevice(t *testing.T) {
+	a, _ := bluetooth.ParseAddr("AA:BB:CC:DD:EE:FF")
+	policy := NewHostPolicy([]bluetooth.Addr{a})
+
+	for _, c := range []struct {
+		dev     dbus.ObjectPath
+		addr    string
+		allowed bool
+	}{
+		{"/org/bluez/hci0/dev_AA_BB_CC_DD_EE_FF", "AA:BB:CC:DD:EE:FF", true},
+		{"/org/bluez/hci1/dev_aa_bb_cc_dd_ee_ff", "AA:BB:CC:DD:EE:FF", true},
+		{"/org/bluez/hci0/dev_11_22_33_44_55_66", "11:22:33:44:55:66", false},
+		{"/org/bluez/hci0", "00:00:00:00:00:00", false},
+		{"/org/bluez/hci0/dev_AA_BB", "00:00:00:00:00:00", false},
+	} {
+		addr, ok := policy.AllowedDevice(c.dev)
+		if ok != c.allowed || addr.String() != c.addr {
+			t.Errorf("AllowedDevice(%s) = %s %v; want %s %v", c.dev, addr, ok, c.addr, c.allowed)
+		}
+	}
+}
+
+// The allow-list can be replaced while running; empty allows any host again
+func TestHostPolicySetAllowed(t *testing.T) {
+	a, _ := bluetooth.ParseAddr("AA:BB:CC:DD:EE:FF")
+	b, _ := bluetooth.ParseAddr("11:22:33:44:55:66")
+	policy := NewHostPolicy([]bluetooth.Addr{a})
+
+	policy.SetAllowed([]bluetooth.Addr{b})
+	if policy.Allowed(a) || !policy.Allowed(b) {
+		t.Error("allow-list not replaced")
+	}
+	policy.SetAllowed(nil)
+	if !policy.Allowed(a) {
+		t.Error("empty allow-list denies hosts")
+	}
+}